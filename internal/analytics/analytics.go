@@ -0,0 +1,319 @@
+package analytics
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Grouping is the bucket size for time series. Buckets are computed on
+// performed_at in UTC.
+type Grouping string
+
+const (
+	GroupDay   Grouping = "day"
+	GroupWeek  Grouping = "week"
+	GroupMonth Grouping = "month"
+)
+
+func (g Grouping) Valid() bool {
+	switch g {
+	case GroupDay, GroupWeek, GroupMonth:
+		return true
+	}
+	return false
+}
+
+// Filter narrows down which workouts are aggregated. When an exercise is
+// given, sessions only count workouts that include it and set totals only
+// count that exercise's sets. The name matches catalog names and aliases.
+type Filter struct {
+	UserID       int
+	From         *time.Time
+	To           *time.Time
+	ExerciseID   *int
+	ExerciseName string
+}
+
+type VolumeBucket struct {
+	Period          time.Time `json:"period"`
+	Sessions        int       `json:"sessions"`
+	DurationMinutes int       `json:"duration_minutes"`
+	CaloriesBurned  int       `json:"calories_burned"`
+	Sets            int       `json:"sets"`
+	Reps            int       `json:"reps"`
+	Tonnage         float64   `json:"tonnage"`
+	DurationSeconds int       `json:"duration_seconds"`
+}
+
+type FrequencyBucket struct {
+	Period     time.Time `json:"period"`
+	Sessions   int       `json:"sessions"`
+	ActiveDays int       `json:"active_days"`
+}
+
+type Frequency struct {
+	Buckets   []FrequencyBucket `json:"buckets"`
+	ByWeekday map[string]int    `json:"by_weekday"`
+}
+
+type Summary struct {
+	Sessions        int        `json:"sessions"`
+	DurationMinutes int        `json:"duration_minutes"`
+	CaloriesBurned  int        `json:"calories_burned"`
+	Sets            int        `json:"sets"`
+	Reps            int        `json:"reps"`
+	Tonnage         float64    `json:"tonnage"`
+	DurationSeconds int        `json:"duration_seconds"`
+	Exercises       int        `json:"exercises"`
+	FirstWorkoutAt  *time.Time `json:"first_workout_at"`
+	LastWorkoutAt   *time.Time `json:"last_workout_at"`
+}
+
+type PostgresStatsStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStatsStore(db *sql.DB) *PostgresStatsStore {
+	return &PostgresStatsStore{db: db}
+}
+
+type StatsStore interface {
+	Volume(filter Filter, group Grouping) ([]VolumeBucket, error)
+	Frequency(filter Filter, group Grouping) (*Frequency, error)
+	Summary(filter Filter) (*Summary, error)
+}
+
+// query builds the filtered CTE every stat starts from. Set level totals
+// exclude warm-ups, so tonnage is sets x reps x weight of the work that
+// counted.
+type query struct {
+	args             []any
+	workoutFilter    string
+	exerciseFilter   string
+	groupPlaceholder string
+}
+
+func newQuery(filter Filter, group Grouping) *query {
+	q := &query{args: []any{filter.UserID}}
+	conditions := []string{"w.user_id = $1"}
+
+	if filter.From != nil {
+		conditions = append(conditions, "w.performed_at >= "+q.arg(*filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "w.performed_at < "+q.arg(*filter.To))
+	}
+
+	exerciseConditions := []string{"ws.set_type <> 'warmup'"}
+	var exercises string
+	switch {
+	case filter.ExerciseID != nil:
+		exercises = q.arg(*filter.ExerciseID)
+	case filter.ExerciseName != "":
+		name := q.arg(filter.ExerciseName)
+		exercises = fmt.Sprintf(`SELECT e.id
+			FROM exercises e
+			LEFT JOIN exercise_aliases a ON a.exercise_id = e.id
+			WHERE (e.user_id IS NULL OR e.user_id = $1)
+			AND (lower(e.name) = lower(%[1]s) OR lower(a.alias) = lower(%[1]s))`, name)
+	}
+	if exercises != "" {
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM workout_entries fe
+			WHERE fe.workout_id = w.id AND fe.exercise_id IN (%s)
+		)`, exercises))
+		exerciseConditions = append(exerciseConditions, fmt.Sprintf("we.exercise_id IN (%s)", exercises))
+	}
+
+	if group != "" {
+		q.groupPlaceholder = q.arg(string(group))
+	}
+	q.workoutFilter = strings.Join(conditions, " AND ")
+	q.exerciseFilter = strings.Join(exerciseConditions, " AND ")
+	return q
+}
+
+func (q *query) arg(value any) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// with returns the shared CTEs: filtered workouts (bucketed when grouping)
+// and the sets that belong to them
+func (q *query) with() string {
+	period := "NULL::TIMESTAMP"
+	if q.groupPlaceholder != "" {
+		period = fmt.Sprintf("date_trunc(%s, w.performed_at AT TIME ZONE 'UTC')", q.groupPlaceholder)
+	}
+
+	return fmt.Sprintf(`
+	WITH filtered AS (
+		SELECT w.id, w.performed_at, w.duration_minutes, COALESCE(w.calories_burned, 0) AS calories_burned, %s AS period
+		FROM workouts w
+		WHERE %s
+	),
+	sets AS (
+		SELECT f.period, we.exercise_id, ws.reps, ws.weight, ws.duration_seconds
+		FROM filtered f
+		JOIN workout_entries we ON we.workout_id = f.id
+		JOIN workout_sets ws ON ws.workout_entry_id = we.id
+		WHERE %s
+	)`, period, q.workoutFilter, q.exerciseFilter)
+}
+
+func (pg *PostgresStatsStore) Volume(filter Filter, group Grouping) ([]VolumeBucket, error) {
+	q := newQuery(filter, group)
+	query := q.with() + `
+	, sessions AS (
+		SELECT period, COUNT(*) AS sessions, SUM(duration_minutes) AS duration_minutes, SUM(calories_burned) AS calories_burned
+		FROM filtered
+		GROUP BY period
+	),
+	work AS (
+		SELECT period,
+			COUNT(*) AS sets,
+			COALESCE(SUM(reps), 0) AS reps,
+			COALESCE(SUM(reps * weight), 0) AS tonnage,
+			COALESCE(SUM(duration_seconds), 0) AS duration_seconds
+		FROM sets
+		GROUP BY period
+	)
+	SELECT s.period, s.sessions, s.duration_minutes, s.calories_burned,
+		COALESCE(wk.sets, 0), COALESCE(wk.reps, 0), COALESCE(wk.tonnage, 0), COALESCE(wk.duration_seconds, 0)
+	FROM sessions s
+	LEFT JOIN work wk ON wk.period = s.period
+	ORDER BY s.period
+	`
+
+	rows, err := pg.db.Query(query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []VolumeBucket{}
+	for rows.Next() {
+		var bucket VolumeBucket
+		err = rows.Scan(
+			&bucket.Period,
+			&bucket.Sessions,
+			&bucket.DurationMinutes,
+			&bucket.CaloriesBurned,
+			&bucket.Sets,
+			&bucket.Reps,
+			&bucket.Tonnage,
+			&bucket.DurationSeconds,
+		)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, rows.Err()
+}
+
+func (pg *PostgresStatsStore) Frequency(filter Filter, group Grouping) (*Frequency, error) {
+	q := newQuery(filter, group)
+	query := q.with() + `
+	SELECT period, COUNT(*), COUNT(DISTINCT (performed_at AT TIME ZONE 'UTC')::DATE)
+	FROM filtered
+	GROUP BY period
+	ORDER BY period
+	`
+
+	rows, err := pg.db.Query(query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	frequency := &Frequency{
+		Buckets:   []FrequencyBucket{},
+		ByWeekday: map[string]int{},
+	}
+	for rows.Next() {
+		var bucket FrequencyBucket
+		err = rows.Scan(&bucket.Period, &bucket.Sessions, &bucket.ActiveDays)
+		if err != nil {
+			return nil, err
+		}
+		frequency.Buckets = append(frequency.Buckets, bucket)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = q.with() + `
+	SELECT EXTRACT(DOW FROM performed_at AT TIME ZONE 'UTC')::INT, COUNT(*)
+	FROM filtered
+	GROUP BY 1
+	`
+
+	rows, err = pg.db.Query(query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		frequency.ByWeekday[strings.ToLower(weekday.String())] = 0
+	}
+	for rows.Next() {
+		var weekday, sessions int
+		err = rows.Scan(&weekday, &sessions)
+		if err != nil {
+			return nil, err
+		}
+		frequency.ByWeekday[strings.ToLower(time.Weekday(weekday).String())] = sessions
+	}
+
+	return frequency, rows.Err()
+}
+
+func (pg *PostgresStatsStore) Summary(filter Filter) (*Summary, error) {
+	q := newQuery(filter, "")
+	query := q.with() + `
+	SELECT
+		(SELECT COUNT(*) FROM filtered),
+		(SELECT COALESCE(SUM(duration_minutes), 0) FROM filtered),
+		(SELECT COALESCE(SUM(calories_burned), 0) FROM filtered),
+		(SELECT MIN(performed_at) FROM filtered),
+		(SELECT MAX(performed_at) FROM filtered),
+		COUNT(*),
+		COALESCE(SUM(reps), 0),
+		COALESCE(SUM(reps * weight), 0),
+		COALESCE(SUM(duration_seconds), 0),
+		COUNT(DISTINCT exercise_id)
+	FROM sets
+	`
+
+	summary := &Summary{}
+	var first, last sql.NullTime
+	err := pg.db.QueryRow(query, q.args...).Scan(
+		&summary.Sessions,
+		&summary.DurationMinutes,
+		&summary.CaloriesBurned,
+		&first,
+		&last,
+		&summary.Sets,
+		&summary.Reps,
+		&summary.Tonnage,
+		&summary.DurationSeconds,
+		&summary.Exercises,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if first.Valid {
+		summary.FirstWorkoutAt = &first.Time
+	}
+	if last.Valid {
+		summary.LastWorkoutAt = &last.Time
+	}
+
+	return summary, nil
+}
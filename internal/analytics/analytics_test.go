@@ -0,0 +1,114 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	workoutStore := store.NewPostgresWorkoutStore(db)
+	userStore := store.NewPostgresUserStore(db)
+	statsStore := NewPostgresStatsStore(db)
+
+	testUser := &store.User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	workouts := []*store.Workout{
+		{
+			UserID:          testUser.ID,
+			Title:           "Monday",
+			DurationMinutes: 60,
+			CaloriesBurned:  300,
+			PerformedAt:     time.Date(2025, 1, 6, 18, 0, 0, 0, time.UTC),
+			Entries: []store.WorkoutEntry{
+				{ExerciseName: "Squat", Sets: 3, Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(100), OrderIndex: 1},
+				{ExerciseName: "Plank", Sets: 2, DurationSeconds: testutil.IntPtr(60), OrderIndex: 2},
+			},
+		},
+		{
+			UserID:          testUser.ID,
+			Title:           "Thursday",
+			DurationMinutes: 30,
+			CaloriesBurned:  150,
+			PerformedAt:     time.Date(2025, 1, 9, 18, 0, 0, 0, time.UTC),
+			Entries: []store.WorkoutEntry{
+				{
+					ExerciseName: "Bench press",
+					OrderIndex:   1,
+					LoggedSets: []store.WorkoutSet{
+						{SetType: store.SetTypeWarmup, Reps: testutil.IntPtr(10), Weight: testutil.FloatPtr(40)},
+						{Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(80)},
+					},
+				},
+			},
+		},
+		{
+			UserID:          testUser.ID,
+			Title:           "Next Monday",
+			DurationMinutes: 45,
+			PerformedAt:     time.Date(2025, 1, 13, 18, 0, 0, 0, time.UTC),
+			Entries: []store.WorkoutEntry{
+				{ExerciseName: "Squat", Sets: 1, Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(110), OrderIndex: 1},
+			},
+		},
+	}
+	for _, workout := range workouts {
+		_, err := workoutStore.CreateWorkout(workout)
+		require.NoError(t, err)
+	}
+
+	t.Run("Volume by week", func(t *testing.T) {
+		volume, err := statsStore.Volume(Filter{UserID: testUser.ID}, GroupWeek)
+		require.NoError(t, err)
+		require.Len(t, volume, 2)
+
+		assert.Equal(t, time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC), volume[0].Period.UTC())
+		assert.Equal(t, 2, volume[0].Sessions)
+		assert.Equal(t, 90, volume[0].DurationMinutes)
+		assert.Equal(t, 450, volume[0].CaloriesBurned)
+		assert.Equal(t, 1500.0+400.0, volume[0].Tonnage)
+		assert.Equal(t, 120, volume[0].DurationSeconds)
+	})
+
+	t.Run("Volume for one exercise", func(t *testing.T) {
+		volume, err := statsStore.Volume(Filter{UserID: testUser.ID, ExerciseName: "squat"}, GroupWeek)
+		require.NoError(t, err)
+		require.Len(t, volume, 2)
+		assert.Equal(t, 1, volume[0].Sessions)
+		assert.Equal(t, 1500.0, volume[0].Tonnage)
+		assert.Equal(t, 550.0, volume[1].Tonnage)
+	})
+
+	t.Run("Frequency", func(t *testing.T) {
+		frequency, err := statsStore.Frequency(Filter{UserID: testUser.ID}, GroupWeek)
+		require.NoError(t, err)
+		require.Len(t, frequency.Buckets, 2)
+		assert.Equal(t, 2, frequency.ByWeekday["monday"])
+		assert.Equal(t, 1, frequency.ByWeekday["thursday"])
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		summary, err := statsStore.Summary(Filter{UserID: testUser.ID})
+		require.NoError(t, err)
+		assert.Equal(t, 3, summary.Sessions)
+		assert.Equal(t, 135, summary.DurationMinutes)
+		assert.Equal(t, 3, summary.Exercises)
+		assert.Equal(t, 1500.0+400.0+550.0, summary.Tonnage)
+	})
+}
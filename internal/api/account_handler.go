@@ -0,0 +1,286 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+// AccountHandler serves the personal data routes: exporting everything we
+// hold on the user and deleting the account.
+type AccountHandler struct {
+	userStore    store.UserStore
+	workoutStore store.WorkoutStore
+	tokenStore   store.TokenStore
+	logger       *log.Logger
+}
+
+func NewAccountHandler(userStore store.UserStore, workoutStore store.WorkoutStore, tokenStore store.TokenStore, logger *log.Logger) *AccountHandler {
+	return &AccountHandler{
+		userStore:    userStore,
+		workoutStore: workoutStore,
+		tokenStore:   tokenStore,
+		logger:       logger,
+	}
+}
+
+// exportedToken is the token metadata that goes in an export. Hashes stay out.
+type exportedToken struct {
+	ID        int       `json:"id"`
+	Scope     string    `json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+func newExportedToken(token *tokens.Token) exportedToken {
+	return exportedToken{
+		ID:        token.ID,
+		Scope:     token.Scope,
+		CreatedAt: token.CreatedAt,
+		Expiry:    token.Expiry,
+	}
+}
+
+// HandleExportData streams a ZIP with the user's profile, workouts and token
+// metadata, each as JSON and as CSV. Weights are exported in kilograms, the
+// unit they're stored in, whatever the user's preferred unit.
+func (ah *AccountHandler) HandleExportData(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="workouts-export.zip"`)
+
+	archive := zip.NewWriter(w)
+	err := ah.writeExport(archive, user)
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		ah.logger.Printf("[ERROR] Exporting data: %v", err)
+		// Part of the archive is already on its way, so there's no status
+		// left to send. Cutting the connection at least keeps the client
+		// from saving a truncated file as if it were complete.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (ah *AccountHandler) writeExport(archive *zip.Writer, user *store.User) error {
+	eachWorkout := func(fn func(*store.Workout) error) error {
+		return ah.workoutStore.EachWorkout(user.ID, fn)
+	}
+	eachToken := func(fn func(exportedToken) error) error {
+		return ah.tokenStore.EachToken(user.ID, func(token *tokens.Token) error {
+			return fn(newExportedToken(token))
+		})
+	}
+
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"profile.json", func(w io.Writer) error { return writeJSONFile(w, user) }},
+		{"profile.csv", func(w io.Writer) error { return writeProfileCSV(w, user) }},
+		{"workouts.json", func(w io.Writer) error { return writeJSONArray(w, eachWorkout) }},
+		{"workouts.csv", func(w io.Writer) error { return writeWorkoutsCSV(w, eachWorkout) }},
+		{"tokens.json", func(w io.Writer) error { return writeJSONArray(w, eachToken) }},
+		{"tokens.csv", func(w io.Writer) error { return writeTokensCSV(w, eachToken) }},
+	}
+
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+
+		err = file.write(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeJSONFile(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(v)
+}
+
+// writeJSONArray writes the values each walks through as a JSON array, one
+// element at a time
+func writeJSONArray[T any](w io.Writer, each func(func(T) error) error) error {
+	_, err := io.WriteString(w, "[")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	separator := "\n"
+	err = each(func(v T) error {
+		_, err := io.WriteString(w, separator)
+		if err != nil {
+			return err
+		}
+		separator = ","
+		return encoder.Encode(v)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+func writeProfileCSV(w io.Writer, user *store.User) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "username", "email", "bio", "preferred_unit", "activated", "role", "created_at", "updated_at"})
+	out.Write([]string{
+		strconv.Itoa(user.ID),
+		user.Username,
+		user.Email,
+		user.Bio,
+		string(user.PreferredUnit),
+		strconv.FormatBool(user.Activated),
+		string(user.Role),
+		formatCSVTime(user.CreatedAt),
+		formatCSVTime(user.UpdatedAt),
+	})
+	out.Flush()
+	return out.Error()
+}
+
+// writeWorkoutsCSV writes one row per entry, repeating the workout's columns
+// on each. Workouts without entries still get a row with the entry columns
+// left empty. The per-set log only makes it into the JSON.
+func writeWorkoutsCSV(w io.Writer, each func(func(*store.Workout) error) error) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{
+		"workout_id", "title", "description", "visibility", "performed_at", "duration_minutes", "calories_burned",
+		"exercise_id", "exercise_name", "sets", "reps", "duration_seconds", "weight_kg", "notes",
+	})
+
+	err := each(func(workout *store.Workout) error {
+		row := []string{
+			strconv.Itoa(workout.ID),
+			workout.Title,
+			workout.Description,
+			string(workout.Visibility),
+			formatCSVTime(workout.PerformedAt),
+			strconv.Itoa(workout.DurationMinutes),
+			strconv.Itoa(workout.CaloriesBurned),
+		}
+		if len(workout.Entries) == 0 {
+			out.Write(append(row, "", "", "", "", "", "", ""))
+		}
+		for _, entry := range workout.Entries {
+			out.Write(append(row,
+				formatCSVInt(entry.ExerciseID),
+				entry.ExerciseName,
+				strconv.Itoa(entry.Sets),
+				formatCSVInt(entry.Reps),
+				formatCSVInt(entry.DurationSeconds),
+				formatCSVFloat(entry.Weight),
+				entry.Notes,
+			))
+		}
+
+		// Flush as we go so the archive streams instead of buffering here
+		out.Flush()
+		return out.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+func writeTokensCSV(w io.Writer, each func(func(exportedToken) error) error) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "scope", "created_at", "expiry"})
+
+	err := each(func(token exportedToken) error {
+		return out.Write([]string{
+			strconv.Itoa(token.ID),
+			token.Scope,
+			formatCSVTime(token.CreatedAt),
+			formatCSVTime(token.Expiry),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+func formatCSVTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatCSVInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func formatCSVFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+// HandleDeleteAccount deletes the user and everything they own for good. The
+// password has to be entered again, so a stolen session alone can't do it.
+func (ah *AccountHandler) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ah.logger.Printf("[ERROR] Decoding on HandleDeleteAccount: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	passwordsMatch, err := user.PasswordHash.Matches(req.Password)
+	if err != nil {
+		ah.logger.Printf("[ERROR] PasswordHash.Matches: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+	if !passwordsMatch {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Password is incorrect"})
+		return
+	}
+
+	err = ah.userStore.DeleteUser(user.ID)
+	if writeStoreError(w, err, "User") {
+		return
+	}
+	if err != nil {
+		ah.logger.Printf("[ERROR] DeleteUser: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
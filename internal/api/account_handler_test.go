@@ -0,0 +1,144 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type exportWorkoutStore struct {
+	store.WorkoutStore
+	workouts []*store.Workout
+}
+
+func (s *exportWorkoutStore) EachWorkout(userID int, fn func(*store.Workout) error) error {
+	for _, workout := range s.workouts {
+		err := fn(workout)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type exportTokenStore struct {
+	store.TokenStore
+	tokens []*tokens.Token
+}
+
+func (s *exportTokenStore) EachToken(userID int, fn func(*tokens.Token) error) error {
+	for _, token := range s.tokens {
+		err := fn(token)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type deletingUserStore struct {
+	store.UserStore
+	deleted []int
+}
+
+func (s *deletingUserStore) DeleteUser(id int) error {
+	s.deleted = append(s.deleted, id)
+	return nil
+}
+
+func readZip(t *testing.T, body []byte) map[string][]byte {
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+
+	files := map[string][]byte{}
+	for _, file := range archive.File {
+		r, err := file.Open()
+		require.NoError(t, err)
+		files[file.Name], err = io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+	}
+	return files
+}
+
+func TestHandleExportData(t *testing.T) {
+	reps, weight := 5, 100.5
+	performedAt := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	workouts := &exportWorkoutStore{workouts: []*store.Workout{
+		{ID: 1, Title: "Legs", Visibility: store.VisibilityPublic, PerformedAt: performedAt, Entries: []store.WorkoutEntry{
+			{ExerciseName: "Squat", Sets: 3, Reps: &reps, Weight: &weight},
+			{ExerciseName: "Lunge", Sets: 2, Reps: &reps, Notes: "slow, controlled"},
+		}},
+		{ID: 2, Title: "Rest day walk", PerformedAt: performedAt.AddDate(0, 0, 1), Entries: []store.WorkoutEntry{}},
+	}}
+	tokenStore := &exportTokenStore{tokens: []*tokens.Token{
+		{ID: 7, Scope: tokens.ScopeAuth, Hash: []byte("secret"), CreatedAt: performedAt, Expiry: performedAt.Add(time.Hour)},
+	}}
+	handler := NewAccountHandler(&deletingUserStore{}, workouts, tokenStore, log.New(io.Discard, "", 0))
+
+	user := &store.User{ID: 1, Username: "gonzalo", Email: "gonzalo@example.com", PreferredUnit: store.UnitKilograms}
+	rec := httptest.NewRecorder()
+	handler.HandleExportData(rec, requestAs(user, http.MethodGet, "/users/me/export", ""))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+
+	files := readZip(t, rec.Body.Bytes())
+	for _, name := range []string{"profile.json", "profile.csv", "workouts.json", "workouts.csv", "tokens.json", "tokens.csv"} {
+		assert.Contains(t, files, name)
+	}
+
+	var profile store.User
+	require.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+	assert.Equal(t, "gonzalo@example.com", profile.Email)
+
+	var exported []store.Workout
+	require.NoError(t, json.Unmarshal(files["workouts.json"], &exported))
+	require.Len(t, exported, 2)
+	assert.Len(t, exported[0].Entries, 2)
+	assert.Equal(t, "Rest day walk", exported[1].Title)
+
+	rows, err := csv.NewReader(bytes.NewReader(files["workouts.csv"])).ReadAll()
+	require.NoError(t, err)
+	// The header, one row per entry and one for the workout without any
+	require.Len(t, rows, 4)
+	assert.Equal(t, []string{"1", "Legs", "", "public", "2026-03-01T10:00:00Z", "0", "0", "", "Squat", "3", "5", "", "100.5", ""}, rows[1])
+	assert.Equal(t, "slow, controlled", rows[2][13])
+	assert.Equal(t, "", rows[3][8])
+
+	var exportedTokens []map[string]any
+	require.NoError(t, json.Unmarshal(files["tokens.json"], &exportedTokens))
+	require.Len(t, exportedTokens, 1)
+	assert.Equal(t, tokens.ScopeAuth, exportedTokens[0]["scope"])
+	assert.NotContains(t, exportedTokens[0], "hash")
+}
+
+func TestHandleDeleteAccount(t *testing.T) {
+	user := &store.User{ID: 1, Username: "gonzalo", Email: "gonzalo@example.com"}
+	require.NoError(t, user.PasswordHash.Set("securepassword"))
+
+	userStore := &deletingUserStore{}
+	handler := NewAccountHandler(userStore, &exportWorkoutStore{}, &exportTokenStore{}, log.New(io.Discard, "", 0))
+
+	rec := httptest.NewRecorder()
+	handler.HandleDeleteAccount(rec, requestAs(user, http.MethodDelete, "/users/me", `{"password": "wrongpassword"}`))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, userStore.deleted)
+
+	rec = httptest.NewRecorder()
+	handler.HandleDeleteAccount(rec, requestAs(user, http.MethodDelete, "/users/me", `{"password": "securepassword"}`))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []int{1}, userStore.deleted)
+}
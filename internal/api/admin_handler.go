@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+// AdminHandler serves the support and user management routes. Every route is
+// mounted behind RequireRole(store.RoleAdmin).
+type AdminHandler struct {
+	userStore    store.UserStore
+	tokenStore   store.TokenStore
+	workoutStore store.WorkoutStore
+	logger       *log.Logger
+}
+
+func NewAdminHandler(userStore store.UserStore, tokenStore store.TokenStore, workoutStore store.WorkoutStore, logger *log.Logger) *AdminHandler {
+	return &AdminHandler{
+		userStore:    userStore,
+		tokenStore:   tokenStore,
+		workoutStore: workoutStore,
+		logger:       logger,
+	}
+}
+
+// readUser loads the user from the id param. It writes the error response
+// itself and returns nil when the request shouldn't go any further.
+func (ah *AdminHandler) readUser(w http.ResponseWriter, r *http.Request) *store.User {
+	userID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid user id"})
+		return nil
+	}
+
+	user, err := ah.userStore.GetUserByID(userID)
+	if writeStoreError(w, err, "User") {
+		return nil
+	}
+	if err != nil {
+		ah.logger.Printf("[ERROR] GetUserByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	return user
+}
+
+func (ah *AdminHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, offset := defaultUserPageSize, 0
+	value, err := parseIntParam(query.Get("limit"))
+	if err != nil || (value != nil && (*value < 1 || *value > maxUserPageSize)) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Limit must be between 1 and 200"})
+		return
+	}
+	if value != nil {
+		limit = *value
+	}
+
+	value, err = parseIntParam(query.Get("offset"))
+	if err != nil || (value != nil && *value < 0) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Offset must be zero or greater"})
+		return
+	}
+	if value != nil {
+		offset = *value
+	}
+
+	users, err := ah.userStore.ListUsers(query.Get("q"), limit, offset)
+	if err != nil {
+		ah.logger.Printf("[ERROR] ListUsers: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"users": users})
+}
+
+func (ah *AdminHandler) HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	user := ah.readUser(w, r)
+	if user == nil {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+// HandleUpdateUser changes a user's role or disables the account. Disabling
+// also signs the user out everywhere.
+func (ah *AdminHandler) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	user := ah.readUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var req struct {
+		Role     *store.Role `json:"role"`
+		Disabled *bool       `json:"disabled"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ah.logger.Printf("[ERROR] Decoding on HandleUpdateUser: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	if req.Role != nil && !req.Role.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Role must be user or admin"})
+		return
+	}
+
+	// Locking yourself out would leave nobody able to undo it
+	if user.ID == middleware.GetUser(r).ID {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "You cannot change your own role or disable yourself"})
+		return
+	}
+
+	if req.Role != nil {
+		user.Role = *req.Role
+	}
+
+	if req.Disabled != nil {
+		switch {
+		case *req.Disabled && !user.IsDisabled():
+			now := time.Now()
+			user.DisabledAt = &now
+		case !*req.Disabled:
+			user.DisabledAt = nil
+		}
+	}
+
+	err = ah.userStore.UpdateUser(user)
+	if writeStoreError(w, err, "User") {
+		return
+	}
+	if err != nil {
+		ah.logger.Printf("[ERROR] UpdateUser: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	if user.IsDisabled() && !ah.revokeSessions(w, user.ID) {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+func (ah *AdminHandler) HandleRevokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	user := ah.readUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if !ah.revokeSessions(w, user.ID) {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// revokeSessions deletes the user's access and refresh tokens. It writes the
+// error response itself and reports whether it succeeded.
+func (ah *AdminHandler) revokeSessions(w http.ResponseWriter, userID int) bool {
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh} {
+		err := ah.tokenStore.DeleteAllTokensForUser(userID, scope)
+		if err != nil {
+			ah.logger.Printf("[ERROR] DeleteAllTokensForUser: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return false
+		}
+	}
+	return true
+}
+
+// HandleListUserWorkouts lets support see a user's workouts with the same
+// filters and pagination as GET /workouts
+func (ah *AdminHandler) HandleListUserWorkouts(w http.ResponseWriter, r *http.Request) {
+	user := ah.readUser(w, r)
+	if user == nil {
+		return
+	}
+
+	filter, err := readWorkoutFilter(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	filter.UserID = user.ID
+
+	workouts, next, err := ah.workoutStore.ListWorkouts(filter)
+	if err != nil {
+		ah.logger.Printf("[ERROR] ListWorkouts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	writeWorkoutPage(w, workouts, next, unit)
+}
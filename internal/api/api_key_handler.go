@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+type APIKeyHandler struct {
+	apiKeyStore store.APIKeyStore
+	logger      *log.Logger
+}
+
+type createAPIKeyRequest struct {
+	Name        string             `json:"name"`
+	Permissions []store.Permission `json:"permissions"`
+	ExpiresAt   *time.Time         `json:"expires_at"`
+}
+
+func NewAPIKeyHandler(apiKeyStore store.APIKeyStore, logger *log.Logger) *APIKeyHandler {
+	return &APIKeyHandler{apiKeyStore: apiKeyStore, logger: logger}
+}
+
+func (ah *APIKeyHandler) validateCreateAPIKeyRequest(req *createAPIKeyRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("Name is required")
+	}
+
+	if len(req.Name) > 255 {
+		return errors.New("Name cannot be greater than 255 characters")
+	}
+
+	if len(req.Permissions) == 0 {
+		return errors.New("At least one permission is required")
+	}
+
+	for _, permission := range req.Permissions {
+		if !permission.Valid() {
+			return errors.New("Unknown permission " + string(permission))
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+
+	return nil
+}
+
+// HandleCreateAPIKey returns the new key in full. It's the only time it can
+// be seen, since only its hash is kept.
+func (ah *APIKeyHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ah.logger.Printf("[ERROR] Decoding on HandleCreateAPIKey: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	err = ah.validateCreateAPIKeyRequest(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	apiKey := &store.APIKey{
+		UserID:      currentUser.ID,
+		Name:        req.Name,
+		Permissions: req.Permissions,
+		ExpiresAt:   req.ExpiresAt,
+	}
+
+	err = ah.apiKeyStore.CreateAPIKey(apiKey)
+	if err != nil {
+		ah.logger.Printf("[ERROR] CreateAPIKey: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Failed to create API key"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"api_key": apiKey})
+}
+
+func (ah *APIKeyHandler) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	keys, err := ah.apiKeyStore.ListAPIKeys(currentUser.ID)
+	if err != nil {
+		ah.logger.Printf("[ERROR] ListAPIKeys: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"api_keys": keys})
+}
+
+func (ah *APIKeyHandler) HandleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid API key id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	err = ah.apiKeyStore.DeleteAPIKey(keyID, currentUser.ID)
+	if writeStoreError(w, err, "API key") {
+		return
+	}
+	if err != nil {
+		ah.logger.Printf("[ERROR] DeleteAPIKey: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
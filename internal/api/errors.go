@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+// constraintMessages explains the database constraints a request can break
+// in terms of the fields the client sent
+var constraintMessages = map[string]string{
+	"valid_workout_entry":  "Every entry needs either reps or duration_seconds, not both",
+	"valid_workout_set":    "Every set needs either reps or duration_seconds, not both",
+	"valid_set_type":       "Set type must be working, warmup, drop or failure",
+	"valid_rpe":            "RPE must be between 1 and 10",
+	"valid_template_entry": "Every entry needs positive target_sets and either target_reps_min or target_duration_seconds, with target_reps_max no lower than target_reps_min",
+	"valid_program_rule":   "Linear rules need a positive increment and percent_1rm rules a percent between 0 and 120",
+	"valid_deload_percent": "Deload percent must be greater than 0 and at most 100",
+}
+
+// writeStoreError responds to the store's typed errors: 404 when resource
+// isn't found, 409 when a unique field is taken and 422 when a constraint
+// rejects the request. It reports whether it wrote a response, so anything
+// else is left to the caller.
+func writeStoreError(w http.ResponseWriter, err error, resource string) bool {
+	var conflict *store.ErrConflict
+	var constraint *store.ErrConstraint
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": resource + " not found"})
+	case errors.As(err, &conflict):
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": conflict.Error(), "field": conflict.Field})
+	case errors.As(err, &constraint):
+		message, ok := constraintMessages[constraint.Name]
+		if !ok {
+			message = "The request breaks a data constraint"
+		}
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": message, "constraint": constraint.Name})
+	default:
+		return false
+	}
+	return true
+}
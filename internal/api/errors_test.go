@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteStoreError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantWritten bool
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:        "Not found",
+			err:         fmt.Errorf("loading workout: %w", store.ErrNotFound),
+			wantWritten: true,
+			wantStatus:  http.StatusNotFound,
+			wantBody:    `{"error": "Workout not found"}`,
+		},
+		{
+			name:        "Conflict",
+			err:         &store.ErrConflict{Field: "email"},
+			wantWritten: true,
+			wantStatus:  http.StatusConflict,
+			wantBody:    `{"error": "email is already taken", "field": "email"}`,
+		},
+		{
+			name:        "Known constraint",
+			err:         &store.ErrConstraint{Name: "valid_workout_entry"},
+			wantWritten: true,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantBody:    `{"error": "Every entry needs either reps or duration_seconds, not both", "constraint": "valid_workout_entry"}`,
+		},
+		{
+			name:        "Unknown constraint",
+			err:         &store.ErrConstraint{Name: "title"},
+			wantWritten: true,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantBody:    `{"error": "The request breaks a data constraint", "constraint": "title"}`,
+		},
+		{
+			name: "No error",
+			err:  nil,
+		},
+		{
+			name: "Other errors are left to the caller",
+			err:  errors.New("connection reset"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			written := writeStoreError(rec, tt.err, "Workout")
+
+			assert.Equal(t, tt.wantWritten, written)
+			if !tt.wantWritten {
+				assert.Zero(t, rec.Body.Len())
+				return
+			}
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.JSONEq(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
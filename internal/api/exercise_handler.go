@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+type ExerciseHandler struct {
+	exerciseStore store.ExerciseStore
+	logger        *log.Logger
+}
+
+type exerciseRequest struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+func NewExerciseHandler(exerciseStore store.ExerciseStore, logger *log.Logger) *ExerciseHandler {
+	return &ExerciseHandler{exerciseStore: exerciseStore, logger: logger}
+}
+
+func (eh *ExerciseHandler) validateExerciseRequest(req *exerciseRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("Name is required")
+	}
+
+	if len(req.Name) > 255 {
+		return errors.New("Name cannot be greater than 255 characters")
+	}
+
+	for _, alias := range req.Aliases {
+		if len(alias) > 255 {
+			return errors.New("Aliases cannot be greater than 255 characters")
+		}
+	}
+
+	return nil
+}
+
+// getOwnedExercise loads the exercise from the id param and makes sure the
+// current user is allowed to change it. It writes the error response itself
+// and returns nil when the request shouldn't go any further.
+func (eh *ExerciseHandler) getOwnedExercise(w http.ResponseWriter, r *http.Request) *store.Exercise {
+	exerciseID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid exercise id"})
+		return nil
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	exercise, err := eh.exerciseStore.GetExerciseByID(exerciseID, currentUser.ID)
+	if writeStoreError(w, err, "Exercise") {
+		return nil
+	}
+	if err != nil {
+		eh.logger.Printf("[ERROR] GetExerciseByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	if exercise.IsGlobal() {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "Catalog exercises cannot be modified"})
+		return nil
+	}
+
+	return exercise
+}
+
+func (eh *ExerciseHandler) HandleListExercises(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	exercises, err := eh.exerciseStore.ListExercises(currentUser.ID, r.URL.Query().Get("q"))
+	if err != nil {
+		eh.logger.Printf("[ERROR] ListExercises: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"exercises": exercises})
+}
+
+func (eh *ExerciseHandler) HandleGetExerciseByID(w http.ResponseWriter, r *http.Request) {
+	exerciseID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid exercise id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	exercise, err := eh.exerciseStore.GetExerciseByID(exerciseID, currentUser.ID)
+	if writeStoreError(w, err, "Exercise") {
+		return
+	}
+	if err != nil {
+		eh.logger.Printf("[ERROR] GetExerciseByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"exercise": exercise})
+}
+
+func (eh *ExerciseHandler) HandleCreateExercise(w http.ResponseWriter, r *http.Request) {
+	var req exerciseRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		eh.logger.Printf("[ERROR] Decoding on HandleCreateExercise: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	err = eh.validateExerciseRequest(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	exercise := &store.Exercise{
+		UserID:  &currentUser.ID,
+		Name:    req.Name,
+		Aliases: req.Aliases,
+	}
+
+	err = eh.exerciseStore.CreateExercise(exercise)
+	if writeStoreError(w, err, "Exercise") {
+		return
+	}
+	if err != nil {
+		eh.logger.Printf("[ERROR] CreateExercise: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Failed to create exercise"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"exercise": exercise})
+}
+
+func (eh *ExerciseHandler) HandleUpdateExerciseByID(w http.ResponseWriter, r *http.Request) {
+	exercise := eh.getOwnedExercise(w, r)
+	if exercise == nil {
+		return
+	}
+
+	var req exerciseRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		eh.logger.Printf("[ERROR] Decoding on HandleUpdateExerciseByID: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	err = eh.validateExerciseRequest(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	exercise.Name = req.Name
+	exercise.Aliases = req.Aliases
+
+	err = eh.exerciseStore.UpdateExercise(exercise)
+	if writeStoreError(w, err, "Exercise") {
+		return
+	}
+	if err != nil {
+		eh.logger.Printf("[ERROR] UpdateExercise: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"exercise": exercise})
+}
+
+func (eh *ExerciseHandler) HandleDeleteExerciseByID(w http.ResponseWriter, r *http.Request) {
+	exercise := eh.getOwnedExercise(w, r)
+	if exercise == nil {
+		return
+	}
+
+	err := eh.exerciseStore.DeleteExercise(int64(exercise.ID))
+	if errors.Is(err, store.ErrExerciseInUse) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "Exercise is used by logged workouts, templates or programs"})
+		return
+	}
+	if writeStoreError(w, err, "Exercise") {
+		return
+	}
+	if err != nil {
+		eh.logger.Printf("[ERROR] DeleteExercise: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
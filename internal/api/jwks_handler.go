@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+// JWKSHandler publishes the public keys signed access tokens can be verified
+// with
+type JWKSHandler struct {
+	signer *tokens.Signer
+}
+
+func NewJWKSHandler(signer *tokens.Signer) *JWKSHandler {
+	return &JWKSHandler{signer: signer}
+}
+
+func (jh *JWKSHandler) HandleGetJWKS(w http.ResponseWriter, r *http.Request) {
+	if jh.signer == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Access tokens are not signed"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(w, http.StatusOK, jh.signer.JWKS())
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gonstoll/workouts/internal/oidc"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+// oidcLoginTTL is how long a user has to finish logging in at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+type OIDCHandler struct {
+	sessionIssuer
+	providers     map[string]*oidc.Provider
+	identityStore store.IdentityStore
+	userStore     store.UserStore
+}
+
+func NewOIDCHandler(providers []*oidc.Provider, identityStore store.IdentityStore, userStore store.UserStore, tokenStore store.TokenStore, twoFactorStore store.TwoFactorStore, signer *tokens.Signer, logger *log.Logger) *OIDCHandler {
+	byName := map[string]*oidc.Provider{}
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+
+	return &OIDCHandler{
+		sessionIssuer: sessionIssuer{
+			tokenStore:     tokenStore,
+			twoFactorStore: twoFactorStore,
+			signer:         signer,
+			logger:         logger,
+		},
+		providers:     byName,
+		identityStore: identityStore,
+		userStore:     userStore,
+	}
+}
+
+func (oh *OIDCHandler) readProvider(w http.ResponseWriter, r *http.Request) *oidc.Provider {
+	provider, ok := oh.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Unknown identity provider"})
+		return nil
+	}
+	return provider
+}
+
+// HandleStartLogin returns the provider URL to send the user to. The provider
+// redirects back to the configured callback with a code and the state.
+func (oh *OIDCHandler) HandleStartLogin(w http.ResponseWriter, r *http.Request) {
+	provider := oh.readProvider(w, r)
+	if provider == nil {
+		return
+	}
+
+	login, err := oidc.NewLoginState()
+	if err != nil {
+		oh.logger.Printf("[ERROR] NewLoginState: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), login)
+	if err != nil {
+		oh.logger.Printf("[ERROR] AuthCodeURL: %v", err)
+		utils.WriteJSON(w, http.StatusBadGateway, utils.Envelope{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	err = oh.identityStore.CreateLoginState(&store.OIDCLoginState{
+		State:    login.State,
+		Provider: provider.Name(),
+		Nonce:    login.Nonce,
+		Verifier: login.Verifier,
+		Expiry:   time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		oh.logger.Printf("[ERROR] CreateLoginState: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"authorization_url": authURL})
+}
+
+// HandleCallback finishes the login and answers like POST /token/authentication
+func (oh *OIDCHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	provider := oh.readProvider(w, r)
+	if provider == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Login was not completed at the identity provider"})
+		return
+	}
+
+	if query.Get("code") == "" || query.Get("state") == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "code and state are required"})
+		return
+	}
+
+	login, err := oh.identityStore.ConsumeLoginState(query.Get("state"), provider.Name())
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Login expired or invalid, please start again"})
+		return
+	}
+	if err != nil {
+		oh.logger.Printf("[ERROR] ConsumeLoginState: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), &oidc.LoginState{
+		State:    login.State,
+		Nonce:    login.Nonce,
+		Verifier: login.Verifier,
+	})
+	if err != nil {
+		oh.logger.Printf("[ERROR] Exchange: %v", err)
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Could not verify the login with the identity provider"})
+		return
+	}
+
+	user := oh.resolveUser(w, provider.Name(), claims)
+	if user == nil {
+		return
+	}
+
+	if user.IsDisabled() {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "This account has been disabled"})
+		return
+	}
+
+	oh.writeLogin(w, user)
+}
+
+// resolveUser finds the user behind the provider's claims. An unknown identity
+// is linked to the activated account with the same email if the provider
+// verified it, or gets a new account. It writes the error response itself and returns nil when
+// the login can't go any further.
+func (oh *OIDCHandler) resolveUser(w http.ResponseWriter, provider string, claims *oidc.Claims) *store.User {
+	user, err := oh.identityStore.GetUserByIdentity(provider, claims.Subject)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		oh.logger.Printf("[ERROR] GetUserByIdentity: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+	if user != nil {
+		return user
+	}
+
+	if claims.Email == "" {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": "The identity provider did not share an email address"})
+		return nil
+	}
+
+	identity := &store.UserIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	existing, err := oh.userStore.GetUserByEmail(claims.Email)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		oh.logger.Printf("[ERROR] GetUserByEmail: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	if existing != nil {
+		// Without a verified email anyone could claim the account by setting
+		// its address at a provider
+		if !claims.EmailVerified {
+			utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "An account with this email already exists and the identity provider has not verified the email"})
+			return nil
+		}
+
+		// Whoever registered an account that was never activated hasn't shown
+		// they own the email, and may still know its password and 2FA. Linking
+		// would hand them the real owner's logins.
+		if !existing.Activated {
+			utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "An account with this email already exists but was never activated, activate it before signing in with the identity provider"})
+			return nil
+		}
+
+		identity.UserID = existing.ID
+		err = oh.identityStore.CreateIdentity(identity)
+		if err != nil {
+			oh.logger.Printf("[ERROR] CreateIdentity: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return nil
+		}
+
+		return existing
+	}
+
+	username, err := oh.freeUsername(claims)
+	if err != nil {
+		oh.logger.Printf("[ERROR] freeUsername: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	user = &store.User{
+		Username:  username,
+		Email:     claims.Email,
+		Activated: claims.EmailVerified,
+	}
+
+	// The account has no usable password until the user sets one through a
+	// password reset
+	err = user.PasswordHash.Set(rand.Text())
+	if err != nil {
+		oh.logger.Printf("[ERROR] PasswordHash.Set: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	err = oh.identityStore.CreateUserWithIdentity(user, identity)
+	if err != nil {
+		oh.logger.Printf("[ERROR] CreateUserWithIdentity: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	return user
+}
+
+// freeUsername picks a username for a new account from the provider's claims,
+// adding a random suffix when it's taken or reserved
+func (oh *OIDCHandler) freeUsername(claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	base = usernameUnsafe.ReplaceAllString(base, "")
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	candidate := base
+	for i := 0; i < 10; i++ {
+		if !isReservedUsername(candidate) {
+			_, err := oh.userStore.GetUserByUsername(candidate)
+			if errors.Is(err, store.ErrNotFound) {
+				return candidate, nil
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+
+		candidate = base + "-" + strings.ToLower(rand.Text()[:4])
+	}
+
+	return "", errors.New("no free username for " + base)
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gonstoll/workouts/internal/oidc"
+	"github.com/gonstoll/workouts/internal/oidc/oidctest"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeIdentityStore struct {
+	store.IdentityStore
+	identities map[string]*store.User
+	states     map[string]*store.OIDCLoginState
+	created    []*store.User
+}
+
+func (s *fakeIdentityStore) GetUserByIdentity(provider, subject string) (*store.User, error) {
+	user, ok := s.identities[provider+"|"+subject]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *fakeIdentityStore) CreateIdentity(identity *store.UserIdentity) error {
+	s.identities[identity.Provider+"|"+identity.Subject] = &store.User{ID: identity.UserID}
+	return nil
+}
+
+func (s *fakeIdentityStore) CreateUserWithIdentity(user *store.User, identity *store.UserIdentity) error {
+	user.ID = 100 + len(s.created)
+	s.created = append(s.created, user)
+	identity.UserID = user.ID
+	return s.CreateIdentity(identity)
+}
+
+func (s *fakeIdentityStore) CreateLoginState(login *store.OIDCLoginState) error {
+	s.states[login.State] = login
+	return nil
+}
+
+func (s *fakeIdentityStore) ConsumeLoginState(state, provider string) (*store.OIDCLoginState, error) {
+	login := s.states[state]
+	delete(s.states, state)
+	if login == nil || login.Provider != provider {
+		return nil, store.ErrNotFound
+	}
+	return login, nil
+}
+
+type oidcUserStore struct {
+	store.UserStore
+	byEmail map[string]*store.User
+}
+
+func (s *oidcUserStore) GetUserByEmail(email string) (*store.User, error) {
+	user, ok := s.byEmail[email]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *oidcUserStore) GetUserByUsername(username string) (*store.User, error) {
+	for _, user := range s.byEmail {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *oidcUserStore) UpdateUser(user *store.User) error {
+	return nil
+}
+
+type oidcTest struct {
+	idp        *oidctest.Server
+	router     *chi.Mux
+	identities *fakeIdentityStore
+	users      *oidcUserStore
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	idp := oidctest.NewServer("workouts", "secret")
+	t.Cleanup(idp.Close)
+
+	provider := oidc.NewProvider(idp.Config("corp", "http://localhost/auth/oidc/corp/callback"), nil)
+	identities := &fakeIdentityStore{identities: map[string]*store.User{}, states: map[string]*store.OIDCLoginState{}}
+	users := &oidcUserStore{byEmail: map[string]*store.User{}}
+	handler := NewOIDCHandler([]*oidc.Provider{provider}, identities, users, &loginTokenStore{}, &fakeTwoFactorStore{}, nil, log.New(io.Discard, "", 0))
+
+	router := chi.NewRouter()
+	router.Get("/auth/oidc/{provider}", handler.HandleStartLogin)
+	router.Get("/auth/oidc/{provider}/callback", handler.HandleCallback)
+
+	return &oidcTest{idp: idp, router: router, identities: identities, users: users}
+}
+
+// login runs the whole flow as the given IdP user and returns the callback
+// response
+func (ot *oidcTest) login(t *testing.T, user oidctest.User) *httptest.ResponseRecorder {
+	ot.idp.SetUser(user)
+
+	rec := httptest.NewRecorder()
+	ot.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/corp", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var start struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	err := json.NewDecoder(rec.Body).Decode(&start)
+	require.NoError(t, err)
+
+	code, state, err := ot.idp.Authorize(start.AuthorizationURL)
+	require.NoError(t, err)
+
+	query := url.Values{"code": {code}, "state": {state}}
+	rec = httptest.NewRecorder()
+	ot.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/corp/callback?"+query.Encode(), nil))
+	return rec
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	rec := ot.login(t, oidctest.User{Subject: "abc123", Email: "gonzalo@example.com", EmailVerified: true})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "auth_token")
+
+	require.Len(t, ot.identities.created, 1)
+	assert.Equal(t, "gonzalo", ot.identities.created[0].Username)
+	assert.True(t, ot.identities.created[0].Activated)
+
+	// Logging in again finds the same user through the identity
+	rec = ot.login(t, oidctest.User{Subject: "abc123", Email: "gonzalo@example.com", EmailVerified: true})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Len(t, ot.identities.created, 1)
+}
+
+func TestOIDCLoginSkipsReservedUsernames(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	rec := ot.login(t, oidctest.User{Subject: "abc123", Email: "me@example.com", EmailVerified: true})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	require.Len(t, ot.identities.created, 1)
+	assert.True(t, strings.HasPrefix(ot.identities.created[0].Username, "me-"), ot.identities.created[0].Username)
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	ot := newOIDCTest(t)
+	existing := &store.User{ID: 7, Username: "gonzalo", Email: "gonzalo@example.com", Activated: true}
+	ot.users.byEmail[existing.Email] = existing
+
+	rec := ot.login(t, oidctest.User{Subject: "unverified", Email: "gonzalo@example.com"})
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = ot.login(t, oidctest.User{Subject: "verified", Email: "gonzalo@example.com", EmailVerified: true})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Empty(t, ot.identities.created)
+	assert.Equal(t, 7, ot.identities.identities["corp|verified"].ID)
+}
+
+func TestOIDCLoginDoesNotLinkUnactivatedAccount(t *testing.T) {
+	ot := newOIDCTest(t)
+	// Someone else registered the address first and never activated it
+	squatter := &store.User{ID: 7, Username: "squatter", Email: "gonzalo@example.com"}
+	ot.users.byEmail[squatter.Email] = squatter
+
+	rec := ot.login(t, oidctest.User{Subject: "verified", Email: "gonzalo@example.com", EmailVerified: true})
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.NotContains(t, ot.identities.identities, "corp|verified")
+	assert.False(t, squatter.Activated)
+}
+
+func TestOIDCCallbackRejectsUnknownState(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	rec := httptest.NewRecorder()
+	ot.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/corp/callback?code=x&state=made-up", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	ot.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/nope", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+)
+
+func parseDateParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+func parseIntParam(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// readDateRange reads the from/to query params. A bare date in to includes
+// that whole day.
+func readDateRange(query url.Values) (*time.Time, *time.Time, error) {
+	from, err := parseDateParam(query.Get("from"))
+	if err != nil {
+		return nil, nil, errors.New("Invalid from date")
+	}
+
+	to, err := parseDateParam(query.Get("to"))
+	if err != nil {
+		return nil, nil, errors.New("Invalid to date")
+	}
+
+	if to != nil && len(query.Get("to")) == len(time.DateOnly) {
+		endOfDay := to.AddDate(0, 0, 1)
+		to = &endOfDay
+	}
+
+	return from, to, nil
+}
+
+// readUnit returns the unit weights are read and written in for this request:
+// the unit query param when it's set, the user's preferred unit otherwise
+func readUnit(r *http.Request) (store.WeightUnit, error) {
+	unit := store.WeightUnit(r.URL.Query().Get("unit"))
+	if unit == "" {
+		return middleware.GetUser(r).PreferredUnit, nil
+	}
+
+	if !unit.Valid() {
+		return "", errors.New("Unit must be kg or lb")
+	}
+
+	return unit, nil
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type profileUserStore struct {
+	store.UserStore
+	users    []*store.User
+	updated  *store.User
+	password *store.User
+}
+
+func (s *profileUserStore) find(match func(*store.User) bool) (*store.User, error) {
+	for _, user := range s.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *profileUserStore) GetUserByUsername(username string) (*store.User, error) {
+	return s.find(func(u *store.User) bool { return u.Username == username })
+}
+
+func (s *profileUserStore) GetUserByEmail(email string) (*store.User, error) {
+	return s.find(func(u *store.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+func (s *profileUserStore) UpdateUser(user *store.User) error {
+	s.updated = user
+	return nil
+}
+
+func (s *profileUserStore) UpdatePassword(user *store.User) error {
+	s.password = user
+	return nil
+}
+
+type sessionTokenStore struct {
+	fakeTokenStore
+	keptFamily string
+}
+
+func (s *sessionTokenStore) GetTokenFamily(plaintext, scope string) (string, error) {
+	return "family-" + plaintext, nil
+}
+
+func (s *sessionTokenStore) DeleteOtherSessions(userID int, familyID string) error {
+	s.keptFamily = familyID
+	return nil
+}
+
+// requestAs authenticates the request as a copy of user, like Authenticate
+// loading a fresh one for every request
+func requestAs(user *store.User, method, target, body string) *http.Request {
+	current := *user
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req = middleware.SetUser(req, &current)
+	return req.WithContext(context.WithValue(req.Context(), middleware.TokenContextKey, "current"))
+}
+
+func TestHandleUpdateCurrentUser(t *testing.T) {
+	user := &store.User{ID: 1, Username: "gonzalo", Email: "gonzalo@example.com", Activated: true}
+	require.NoError(t, user.PasswordHash.Set("securepassword"))
+	taken := &store.User{ID: 2, Username: "maria", Email: "maria@example.com"}
+	userStore := &profileUserStore{users: []*store.User{user, taken}}
+	tokenStore := &fakeTokenStore{}
+	mail := &recordingMailer{}
+	handler := NewUserHandler(userStore, tokenStore, store.NewMemoryLoginAttemptStore(), mail, log.New(io.Discard, "", 0))
+
+	rec := httptest.NewRecorder()
+	handler.HandleUpdateCurrentUser(rec, requestAs(user, http.MethodPatch, "/users/me", `{"username": "maria"}`))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.HandleUpdateCurrentUser(rec, requestAs(user, http.MethodPatch, "/users/me", `{"email": "not-an-email"}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.HandleUpdateCurrentUser(rec, requestAs(user, http.MethodPatch, "/users/me", `{"username": "Me"}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "me is a route under /users")
+
+	rec = httptest.NewRecorder()
+	handler.HandleUpdateCurrentUser(rec, requestAs(user, http.MethodPatch, "/users/me", `{"preferred_unit": ""}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Nil(t, userStore.updated)
+
+	rec = httptest.NewRecorder()
+	handler.HandleUpdateCurrentUser(rec, requestAs(user, http.MethodPatch, "/users/me", `{"email": "gonzalo@new.example.com"}`))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "a new email needs the current password")
+	assert.Nil(t, userStore.updated)
+
+	rec = httptest.NewRecorder()
+	handler.HandleUpdateCurrentUser(rec, requestAs(user, http.MethodPatch, "/users/me", `{"bio": "Lifts things", "email": "gonzalo@new.example.com", "current_password": "securepassword"}`))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.NotNil(t, userStore.updated)
+	assert.Equal(t, "Lifts things", userStore.updated.Bio)
+	assert.Equal(t, "gonzalo", userStore.updated.Username)
+	assert.False(t, userStore.updated.Activated, "a new email needs activating")
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "gonzalo@new.example.com", mail.sent[0].recipient)
+	assert.ElementsMatch(t, []string{tokens.ScopeActivation, tokens.ScopePasswordReset}, tokenStore.deletedScopes)
+}
+
+func TestHandleChangePassword(t *testing.T) {
+	user := &store.User{ID: 1, Username: "gonzalo"}
+	err := user.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	userStore := &profileUserStore{users: []*store.User{user}}
+	tokenStore := &sessionTokenStore{}
+	handler := NewUserHandler(userStore, tokenStore, store.NewMemoryLoginAttemptStore(), &recordingMailer{}, log.New(io.Discard, "", 0))
+
+	rec := httptest.NewRecorder()
+	handler.HandleChangePassword(rec, requestAs(user, http.MethodPut, "/users/me/password", `{"current_password": "wrong", "new_password": "newpassword"}`))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Nil(t, userStore.password)
+
+	rec = httptest.NewRecorder()
+	handler.HandleChangePassword(rec, requestAs(user, http.MethodPut, "/users/me/password", `{"current_password": "securepassword", "new_password": "newpassword"}`))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.NotNil(t, userStore.password)
+	matches, err := userStore.password.PasswordHash.Matches("newpassword")
+	require.NoError(t, err)
+	assert.True(t, matches)
+	assert.Equal(t, "family-current", tokenStore.keptFamily)
+}
@@ -0,0 +1,474 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+const defaultDeloadPercent = 60
+
+type ProgramHandler struct {
+	programStore  store.ProgramStore
+	templateStore store.TemplateStore
+	workoutStore  store.WorkoutStore
+	recordStore   store.RecordStore
+	logger        *log.Logger
+}
+
+type programRequest struct {
+	Name          string                  `json:"name"`
+	Description   string                  `json:"description"`
+	DeloadPercent *float64                `json:"deload_percent"`
+	Weeks         []store.ProgramWeek     `json:"weeks"`
+	Rules         []store.ProgressionRule `json:"rules"`
+}
+
+func NewProgramHandler(programStore store.ProgramStore, templateStore store.TemplateStore, workoutStore store.WorkoutStore, recordStore store.RecordStore, logger *log.Logger) *ProgramHandler {
+	return &ProgramHandler{
+		programStore:  programStore,
+		templateStore: templateStore,
+		workoutStore:  workoutStore,
+		recordStore:   recordStore,
+		logger:        logger,
+	}
+}
+
+func (ph *ProgramHandler) validateProgramRequest(req *programRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("Name is required")
+	}
+
+	if len(req.Name) > 255 {
+		return errors.New("Name cannot be greater than 255 characters")
+	}
+
+	if req.DeloadPercent != nil && (*req.DeloadPercent <= 0 || *req.DeloadPercent > 100) {
+		return errors.New("deload_percent must be greater than 0 and at most 100")
+	}
+
+	weeks := map[int]bool{}
+	for i, week := range req.Weeks {
+		number := week.WeekNumber
+		if number == 0 {
+			number = i + 1
+		}
+		if weeks[number] {
+			return errors.New("Week numbers must be unique")
+		}
+		weeks[number] = true
+
+		days := map[int]bool{}
+		for j, day := range week.Days {
+			number := day.DayNumber
+			if number == 0 {
+				number = j + 1
+			}
+			if days[number] {
+				return errors.New("Day numbers must be unique within a week")
+			}
+			days[number] = true
+
+			if day.TemplateID == 0 {
+				return errors.New("Every day needs a template_id")
+			}
+		}
+	}
+
+	for _, rule := range req.Rules {
+		switch rule.RuleType {
+		case store.RuleLinear:
+			if rule.Increment == nil || *rule.Increment <= 0 {
+				return errors.New("Linear rules need a positive increment")
+			}
+		case store.RulePercentOneRepMax:
+			if rule.Percent == nil || *rule.Percent <= 0 || *rule.Percent > 120 {
+				return errors.New("percent_1rm rules need a percent greater than 0 and at most 120")
+			}
+		default:
+			return errors.New("rule_type must be one of linear, percent_1rm")
+		}
+	}
+
+	return nil
+}
+
+func (ph *ProgramHandler) writeProgramError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, store.ErrUnknownTemplate):
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Every day needs one of your templates"})
+	case errors.Is(err, store.ErrUnknownExercise):
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Every rule needs a known exercise_id or an exercise_name"})
+	default:
+		return false
+	}
+	return true
+}
+
+func (ph *ProgramHandler) readProgram(w http.ResponseWriter, r *http.Request) *store.Program {
+	programID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid program id"})
+		return nil
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	program, err := ph.programStore.GetProgramByID(programID, currentUser.ID)
+	if writeStoreError(w, err, "Program") {
+		return nil
+	}
+	if err != nil {
+		ph.logger.Printf("[ERROR] GetProgramByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	return program
+}
+
+func (ph *ProgramHandler) HandleListPrograms(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	programs, err := ph.programStore.ListPrograms(currentUser.ID)
+	if err != nil {
+		ph.logger.Printf("[ERROR] ListPrograms: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	for _, program := range programs {
+		program.ConvertWeights(unit.FromKilograms)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"programs": programs})
+}
+
+func (ph *ProgramHandler) HandleGetProgramByID(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	program := ph.readProgram(w, r)
+	if program == nil {
+		return
+	}
+
+	program.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"program": program})
+}
+
+func (ph *ProgramHandler) HandleCreateProgram(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	var req programRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ph.logger.Printf("[ERROR] Decoding on HandleCreateProgram: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	err = ph.validateProgramRequest(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	program := &store.Program{
+		UserID:        currentUser.ID,
+		Name:          req.Name,
+		Description:   req.Description,
+		DeloadPercent: defaultDeloadPercent,
+		Weeks:         req.Weeks,
+		Rules:         req.Rules,
+	}
+	if req.DeloadPercent != nil {
+		program.DeloadPercent = *req.DeloadPercent
+	}
+	program.ConvertWeights(unit.ToKilograms)
+
+	err = ph.programStore.CreateProgram(program)
+	if ph.writeProgramError(w, err) || writeStoreError(w, err, "Program") {
+		return
+	}
+	if err != nil {
+		ph.logger.Printf("[ERROR] CreateProgram: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Failed to create program"})
+		return
+	}
+
+	program.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"program": program})
+}
+
+func (ph *ProgramHandler) HandleUpdateProgramByID(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	program := ph.readProgram(w, r)
+	if program == nil {
+		return
+	}
+
+	var req programRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ph.logger.Printf("[ERROR] Decoding on HandleUpdateProgramByID: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	err = ph.validateProgramRequest(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	program.Name = req.Name
+	program.Description = req.Description
+	program.Weeks = req.Weeks
+	program.Rules = req.Rules
+	if req.DeloadPercent != nil {
+		program.DeloadPercent = *req.DeloadPercent
+	}
+	program.ConvertWeights(unit.ToKilograms)
+
+	err = ph.programStore.UpdateProgram(program)
+	if ph.writeProgramError(w, err) || writeStoreError(w, err, "Program") {
+		return
+	}
+	if err != nil {
+		ph.logger.Printf("[ERROR] UpdateProgram: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	program.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"program": program})
+}
+
+func (ph *ProgramHandler) HandleDeleteProgramByID(w http.ResponseWriter, r *http.Request) {
+	programID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid program id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	err = ph.programStore.DeleteProgram(programID, currentUser.ID)
+	if writeStoreError(w, err, "Program") {
+		return
+	}
+	if err != nil {
+		ph.logger.Printf("[ERROR] DeleteProgram: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+func (ph *ProgramHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	programID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid program id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	enrollment, err := ph.programStore.Enroll(programID, currentUser.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Program not found"})
+		return
+	case errors.Is(err, store.ErrEmptyProgram):
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Program has no days"})
+		return
+	case errors.Is(err, store.ErrAlreadyEnrolled):
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "You are already enrolled in this program"})
+		return
+	case writeStoreError(w, err, "Program"):
+		return
+	case err != nil:
+		ph.logger.Printf("[ERROR] Enroll: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	enrollment.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"enrollment": enrollment})
+}
+
+func (ph *ProgramHandler) HandleListEnrollments(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	enrollments, err := ph.programStore.ListEnrollments(currentUser.ID)
+	if err != nil {
+		ph.logger.Printf("[ERROR] ListEnrollments: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	for _, enrollment := range enrollments {
+		enrollment.ConvertWeights(unit.FromKilograms)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"enrollments": enrollments})
+}
+
+func (ph *ProgramHandler) HandleDeleteEnrollment(w http.ResponseWriter, r *http.Request) {
+	enrollmentID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid enrollment id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	err = ph.programStore.DeleteEnrollment(enrollmentID, currentUser.ID)
+	if writeStoreError(w, err, "Enrollment") {
+		return
+	}
+	if err != nil {
+		ph.logger.Printf("[ERROR] DeleteEnrollment: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleGetToday returns the workout the enrollment prescribes next. Nothing
+// is saved: clients log it through POST /workouts with the enrollment_id,
+// which is what moves the program forward.
+func (ph *ProgramHandler) HandleGetToday(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	enrollmentID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid enrollment id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	enrollment, err := ph.programStore.GetEnrollment(enrollmentID, currentUser.ID)
+	if writeStoreError(w, err, "Enrollment") {
+		return
+	}
+	if err != nil {
+		ph.logger.Printf("[ERROR] GetEnrollment: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	if enrollment.IsCompleted() {
+		enrollment.ConvertWeights(unit.FromKilograms)
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"enrollment": enrollment, "workout": nil})
+		return
+	}
+
+	program, err := ph.programStore.GetProgramByID(int64(enrollment.ProgramID), currentUser.ID)
+	if err != nil {
+		ph.logger.Printf("[ERROR] GetProgramByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	week, day := program.Day(enrollment.CurrentWeek, enrollment.CurrentDay)
+	if day == nil {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "The program no longer has this day"})
+		return
+	}
+
+	template, err := ph.templateStore.GetTemplateByID(int64(day.TemplateID), currentUser.ID)
+	if err != nil {
+		ph.logger.Printf("[ERROR] GetTemplateByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	exerciseIDs := []int{}
+	for _, entry := range template.Entries {
+		exerciseIDs = append(exerciseIDs, *entry.ExerciseID)
+	}
+
+	last, err := ph.workoutStore.GetLastEntries(currentUser.ID, exerciseIDs)
+	if err != nil {
+		ph.logger.Printf("[ERROR] GetLastEntries: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	records, err := ph.recordStore.ListPersonalRecords(currentUser.ID, nil)
+	if err != nil {
+		ph.logger.Printf("[ERROR] ListPersonalRecords: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	oneRepMaxes := map[int]float64{}
+	for _, record := range records {
+		if record.RecordType == store.RecordEstimated1RM {
+			oneRepMaxes[record.ExerciseID] = record.Value
+		}
+	}
+
+	// Prescriptions are rounded again once converted so they stay loadable
+	// with the user's plates
+	workout := program.Prescribe(enrollment, template, last, oneRepMaxes)
+	workout.ConvertWeights(func(weight float64) float64 {
+		return unit.RoundToPlates(unit.FromKilograms(weight))
+	})
+	enrollment.ConvertWeights(unit.FromKilograms)
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"enrollment": enrollment,
+		"week":       week,
+		"day":        day,
+		"workout":    workout,
+	})
+}
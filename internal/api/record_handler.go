@@ -0,0 +1,48 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+type RecordHandler struct {
+	recordStore store.RecordStore
+	logger      *log.Logger
+}
+
+func NewRecordHandler(recordStore store.RecordStore, logger *log.Logger) *RecordHandler {
+	return &RecordHandler{recordStore: recordStore, logger: logger}
+}
+
+func (rh *RecordHandler) HandleListRecords(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	exerciseID, err := parseIntParam(r.URL.Query().Get("exercise_id"))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid exercise_id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	records, err := rh.recordStore.ListPersonalRecords(currentUser.ID, exerciseID)
+	if err != nil {
+		rh.logger.Printf("[ERROR] ListPersonalRecords: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	for i := range records {
+		records[i].ConvertWeights(unit.FromKilograms)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"records": records})
+}
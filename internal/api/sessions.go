@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+const (
+	accessTokenTTL = 15 * time.Minute
+	// signedAccessTokenTTL is shorter since signed tokens can't be revoked
+	// before they expire
+	signedAccessTokenTTL = 5 * time.Minute
+	refreshTokenTTL      = 30 * 24 * time.Hour
+	// challengeTTL is how long a login has to supply its second factor
+	challengeTTL = 5 * time.Minute
+)
+
+// sessionIssuer hands out tokens to users who have proved who they are, by
+// password or through an identity provider. With a signer, access tokens go
+// out as signed JWTs and their row in the tokens table is kept as the
+// session's record, so it can still be listed and logged out.
+type sessionIssuer struct {
+	tokenStore     store.TokenStore
+	twoFactorStore store.TwoFactorStore
+	signer         *tokens.Signer
+	logger         *log.Logger
+}
+
+func (si *sessionIssuer) accessTTL() time.Duration {
+	if si.signer != nil {
+		return signedAccessTokenTTL
+	}
+	return accessTokenTTL
+}
+
+// writeLogin answers a successful login. With 2FA on, that only earns a
+// challenge to trade in at POST /token/2fa.
+func (si *sessionIssuer) writeLogin(w http.ResponseWriter, user *store.User) {
+	secret, err := si.twoFactorStore.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		si.logger.Printf("[ERROR] GetTOTP: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	if secret.Enabled() {
+		challenge, err := si.tokenStore.CreateNewToken(user.ID, challengeTTL, tokens.ScopeTwoFactor)
+		if err != nil {
+			si.logger.Printf("[ERROR] CreateNewToken: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"two_factor_required": true, "challenge_token": challenge})
+		return
+	}
+
+	si.writeTokenPair(w, user)
+}
+
+// writeTokenPair starts a new session for a user who has fully logged in
+func (si *sessionIssuer) writeTokenPair(w http.ResponseWriter, user *store.User) {
+	pair, err := si.tokenStore.CreateTokenPair(user.ID, si.accessTTL(), refreshTokenTTL)
+	if err != nil {
+		si.logger.Printf("[ERROR] CreateTokenPair: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	si.writePair(w, pair, user)
+}
+
+// writePair swaps in a signed access token when they're enabled and writes the
+// pair out. user may only be nil when they aren't.
+func (si *sessionIssuer) writePair(w http.ResponseWriter, pair *store.TokenPair, user *store.User) {
+	if si.signer != nil {
+		signed, err := si.signer.Sign(tokens.AccessClaims{
+			UserID:    user.ID,
+			Username:  user.Username,
+			Role:      string(user.Role),
+			Activated: user.Activated,
+			Unit:      string(user.PreferredUnit),
+			TokenID:   pair.Access.ID,
+			FamilyID:  pair.Access.FamilyID,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: pair.Access.Expiry.Unix(),
+		})
+		if err != nil {
+			si.logger.Printf("[ERROR] Sign: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return
+		}
+		pair.Access.Plaintext = signed
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": pair.Access, "refresh_token": pair.Refresh})
+}
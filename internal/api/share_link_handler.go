@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+// ShareLinkHandler serves the links owners hand out to show a workout to
+// people without an account, and the read-only view behind them
+type ShareLinkHandler struct {
+	workoutStore store.WorkoutStore
+	logger       *log.Logger
+}
+
+func NewShareLinkHandler(workoutStore store.WorkoutStore, logger *log.Logger) *ShareLinkHandler {
+	return &ShareLinkHandler{workoutStore: workoutStore, logger: logger}
+}
+
+// readOwnWorkoutID reads the workout id param and checks the current user
+// owns it. It writes the error response itself and reports false when the
+// request shouldn't go any further.
+func (sh *ShareLinkHandler) readOwnWorkoutID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	workoutID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid workout id"})
+		return 0, false
+	}
+
+	owner, err := sh.workoutStore.GetWorkoutOwner(workoutID)
+	if writeStoreError(w, err, "Workout") {
+		return 0, false
+	}
+	if err != nil {
+		sh.logger.Printf("[ERROR] GetWorkoutOwner: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return 0, false
+	}
+
+	if owner != middleware.GetUser(r).ID {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "You are not authorized to share this workout"})
+		return 0, false
+	}
+
+	return workoutID, true
+}
+
+// HandleCreateShareLink returns the new link's token. It's the only time it
+// can be seen, since only its hash is kept.
+func (sh *ShareLinkHandler) HandleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		sh.logger.Printf("[ERROR] Decoding on HandleCreateShareLink: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "expires_at must be in the future"})
+		return
+	}
+
+	workoutID, ok := sh.readOwnWorkoutID(w, r)
+	if !ok {
+		return
+	}
+
+	link := &store.ShareLink{WorkoutID: int(workoutID), ExpiresAt: req.ExpiresAt}
+	err = sh.workoutStore.CreateShareLink(link)
+	if err != nil {
+		sh.logger.Printf("[ERROR] CreateShareLink: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Failed to create share link"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"share_link": link, "path": "/shared/" + link.Token})
+}
+
+func (sh *ShareLinkHandler) HandleListShareLinks(w http.ResponseWriter, r *http.Request) {
+	workoutID, ok := sh.readOwnWorkoutID(w, r)
+	if !ok {
+		return
+	}
+
+	links, err := sh.workoutStore.ListShareLinks(workoutID)
+	if err != nil {
+		sh.logger.Printf("[ERROR] ListShareLinks: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"share_links": links})
+}
+
+func (sh *ShareLinkHandler) HandleDeleteShareLink(w http.ResponseWriter, r *http.Request) {
+	linkID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid share link id"})
+		return
+	}
+
+	err = sh.workoutStore.DeleteShareLink(linkID, middleware.GetUser(r).ID)
+	if writeStoreError(w, err, "Share link") {
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("[ERROR] DeleteShareLink: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleGetSharedWorkout is the read-only view behind a share link. It needs
+// no authentication: the token in the path is the credential, so it's kept
+// out of caches and Referer headers. The view leaves out the workout's IDs.
+func (sh *ShareLinkHandler) HandleGetSharedWorkout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	unit := store.WeightUnit(r.URL.Query().Get("unit"))
+	if unit == "" {
+		unit = store.UnitKilograms
+	}
+	if !unit.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Unit must be kg or lb"})
+		return
+	}
+
+	shared, err := sh.workoutStore.GetSharedWorkout(chi.URLParam(r, "token"))
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "This link is invalid, expired or was revoked"})
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("[ERROR] GetSharedWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	shared.Workout.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"shared": shared.View()})
+}
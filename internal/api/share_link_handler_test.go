@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type shareWorkoutStore struct {
+	store.WorkoutStore
+	owners map[int64]int
+	links  map[string]*store.SharedWorkout
+}
+
+func (s *shareWorkoutStore) GetWorkoutOwner(id int64) (int, error) {
+	owner, ok := s.owners[id]
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	return owner, nil
+}
+
+func (s *shareWorkoutStore) CreateShareLink(link *store.ShareLink) error {
+	link.ID = len(s.links) + 1
+	link.Token = "token"
+	return nil
+}
+
+func (s *shareWorkoutStore) GetSharedWorkout(token string) (*store.SharedWorkout, error) {
+	shared, ok := s.links[token]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return shared, nil
+}
+
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	routeContext := chi.NewRouteContext()
+	routeContext.URLParams.Add(key, value)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeContext))
+}
+
+func TestHandleCreateShareLink(t *testing.T) {
+	workoutStore := &shareWorkoutStore{owners: map[int64]int{1: 1, 2: 2}}
+	handler := NewShareLinkHandler(workoutStore, log.New(io.Discard, "", 0))
+	user := &store.User{ID: 1, Username: "gonzalo", Activated: true}
+
+	tests := []struct {
+		name       string
+		workoutID  string
+		body       string
+		wantStatus int
+	}{
+		{"own workout", "1", `{}`, http.StatusCreated},
+		{"with an expiry", "1", `{"expires_at": "2999-01-01T00:00:00Z"}`, http.StatusCreated},
+		{"expiry in the past", "1", `{"expires_at": "2020-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"someone else's workout", "2", `{}`, http.StatusForbidden},
+		{"missing workout", "3", `{}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withURLParam(requestAs(user, http.MethodPost, "/workouts/"+tt.workoutID+"/share-links", tt.body), "id", tt.workoutID)
+			rec := httptest.NewRecorder()
+
+			handler.HandleCreateShareLink(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestHandleGetSharedWorkout(t *testing.T) {
+	weight := 100.0
+	templateID, exerciseID := 5, 6
+	workoutStore := &shareWorkoutStore{links: map[string]*store.SharedWorkout{
+		"valid": {Username: "gonzalo", Workout: &store.Workout{ID: 1, UserID: 7, TemplateID: &templateID, Title: "Leg day", Entries: []store.WorkoutEntry{
+			{ID: 3, ExerciseID: &exerciseID, ExerciseName: "Squat", Sets: 3, Weight: &weight, LoggedSets: []store.WorkoutSet{{ID: 4, SetNumber: 1}}},
+		}}},
+	}}
+	handler := NewShareLinkHandler(workoutStore, log.New(io.Discard, "", 0))
+
+	// No user on the request: share links work without logging in
+	req := withURLParam(httptest.NewRequest(http.MethodGet, "/shared/valid?unit=lb", nil), "token", "valid")
+	rec := httptest.NewRecorder()
+	handler.HandleGetSharedWorkout(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	// Nothing that ties the workout to the owner's account gets out
+	for _, key := range []string{`"id"`, `"user_id"`, `"template_id"`, `"enrollment_id"`, `"exercise_id"`, `"visibility"`} {
+		assert.NotContains(t, rec.Body.String(), key)
+	}
+
+	var body struct {
+		Shared store.SharedWorkoutView `json:"shared"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "gonzalo", body.Shared.Username)
+	assert.Equal(t, "Leg day", body.Shared.Workout.Title)
+	require.Len(t, body.Shared.Workout.Entries, 1)
+	assert.Equal(t, 220.46, *body.Shared.Workout.Entries[0].Weight)
+	assert.Len(t, body.Shared.Workout.Entries[0].LoggedSets, 1)
+
+	req = withURLParam(httptest.NewRequest(http.MethodGet, "/shared/revoked", nil), "token", "revoked")
+	rec = httptest.NewRecorder()
+	handler.HandleGetSharedWorkout(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+// SocialHandler serves public profiles, follows and the feed of followed
+// users' shared workouts
+type SocialHandler struct {
+	followStore  store.FollowStore
+	workoutStore store.WorkoutStore
+	logger       *log.Logger
+}
+
+func NewSocialHandler(followStore store.FollowStore, workoutStore store.WorkoutStore, logger *log.Logger) *SocialHandler {
+	return &SocialHandler{
+		followStore:  followStore,
+		workoutStore: workoutStore,
+		logger:       logger,
+	}
+}
+
+// readProfile loads the profile of the user in the username param, as seen by
+// the current user. It writes the error response itself and returns nil when
+// the request shouldn't go any further.
+func (sh *SocialHandler) readProfile(w http.ResponseWriter, r *http.Request) *store.Profile {
+	viewer := middleware.GetUser(r)
+
+	profile, err := sh.followStore.GetProfile(chi.URLParam(r, "username"), viewer.ID)
+	if writeStoreError(w, err, "User") {
+		return nil
+	}
+	if err != nil {
+		sh.logger.Printf("[ERROR] GetProfile: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	return profile
+}
+
+// listShared loads a page of the workouts the filter selects, filling in the
+// viewer and the page from the request. It writes the error response itself
+// and reports false when the request shouldn't go any further.
+func (sh *SocialHandler) listShared(w http.ResponseWriter, r *http.Request, filter store.SharedFilter) ([]*store.SharedWorkout, *store.Cursor, bool) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	filter.Cursor, filter.Limit, err = readWorkoutPage(r.URL.Query())
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return nil, nil, false
+	}
+	filter.ViewerID = middleware.GetUser(r).ID
+
+	shared, next, err := sh.workoutStore.ListSharedWorkouts(filter)
+	if err != nil {
+		sh.logger.Printf("[ERROR] ListSharedWorkouts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil, nil, false
+	}
+
+	for _, item := range shared {
+		item.Workout.ConvertWeights(unit.FromKilograms)
+	}
+
+	return shared, next, true
+}
+
+// HandleGetProfile returns the user's public profile with a page of the
+// workouts the viewer can see. Anyone can look, logged in or not; anonymous
+// visitors only get public workouts.
+func (sh *SocialHandler) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
+	profile := sh.readProfile(w, r)
+	if profile == nil {
+		return
+	}
+
+	shared, next, ok := sh.listShared(w, r, store.SharedFilter{OwnerID: profile.ID})
+	if !ok {
+		return
+	}
+
+	workouts := make([]*store.Workout, len(shared))
+	for i, item := range shared {
+		workouts[i] = item.Workout
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"profile": profile, "workouts": workouts, "next_cursor": encodeCursor(next)})
+}
+
+// HandleGetFeed lists the workouts shared by everyone the user follows, most
+// recently performed first
+func (sh *SocialHandler) HandleGetFeed(w http.ResponseWriter, r *http.Request) {
+	shared, next, ok := sh.listShared(w, r, store.SharedFilter{})
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workouts": shared, "next_cursor": encodeCursor(next)})
+}
+
+// HandleFollow sends a follow request. The follower only sees followers-only
+// workouts once it's accepted, so the updated profile tells them which it is.
+func (sh *SocialHandler) HandleFollow(w http.ResponseWriter, r *http.Request) {
+	profile := sh.readProfile(w, r)
+	if profile == nil {
+		return
+	}
+
+	user := middleware.GetUser(r)
+	if profile.ID == user.ID {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "You cannot follow yourself"})
+		return
+	}
+
+	err := sh.followStore.Follow(user.ID, profile.ID)
+	if writeStoreError(w, err, "User") {
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("[ERROR] Follow: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	profile = sh.readProfile(w, r)
+	if profile == nil {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"profile": profile})
+}
+
+func (sh *SocialHandler) HandleUnfollow(w http.ResponseWriter, r *http.Request) {
+	profile := sh.readProfile(w, r)
+	if profile == nil {
+		return
+	}
+
+	err := sh.followStore.Unfollow(middleware.GetUser(r).ID, profile.ID)
+	if err != nil {
+		sh.logger.Printf("[ERROR] Unfollow: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleListFollowRequests lists who is waiting to follow the current user
+func (sh *SocialHandler) HandleListFollowRequests(w http.ResponseWriter, r *http.Request) {
+	requests, err := sh.followStore.ListFollowRequests(middleware.GetUser(r).ID)
+	if err != nil {
+		sh.logger.Printf("[ERROR] ListFollowRequests: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"follow_requests": requests})
+}
+
+// HandleAcceptFollower accepts the follow request from the user in the
+// username param
+func (sh *SocialHandler) HandleAcceptFollower(w http.ResponseWriter, r *http.Request) {
+	follower := sh.readProfile(w, r)
+	if follower == nil {
+		return
+	}
+
+	err := sh.followStore.AcceptFollower(middleware.GetUser(r).ID, follower.ID)
+	if writeStoreError(w, err, "Follow request") {
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("[ERROR] AcceptFollower: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleRemoveFollower removes the user in the username param from the
+// current user's followers, or declines their request
+func (sh *SocialHandler) HandleRemoveFollower(w http.ResponseWriter, r *http.Request) {
+	follower := sh.readProfile(w, r)
+	if follower == nil {
+		return
+	}
+
+	err := sh.followStore.RemoveFollower(middleware.GetUser(r).ID, follower.ID)
+	if writeStoreError(w, err, "Follower") {
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("[ERROR] RemoveFollower: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
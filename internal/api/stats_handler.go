@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gonstoll/workouts/internal/analytics"
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+type StatsHandler struct {
+	statsStore analytics.StatsStore
+	logger     *log.Logger
+}
+
+func NewStatsHandler(statsStore analytics.StatsStore, logger *log.Logger) *StatsHandler {
+	return &StatsHandler{statsStore: statsStore, logger: logger}
+}
+
+func (sh *StatsHandler) readFilter(r *http.Request) (analytics.Filter, error) {
+	query := r.URL.Query()
+	filter := analytics.Filter{
+		UserID:       middleware.GetUser(r).ID,
+		ExerciseName: query.Get("exercise"),
+	}
+
+	var err error
+	filter.From, filter.To, err = readDateRange(query)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.ExerciseID, err = parseIntParam(query.Get("exercise_id"))
+	if err != nil {
+		return filter, errors.New("Invalid exercise_id")
+	}
+
+	return filter, nil
+}
+
+func (sh *StatsHandler) readGrouping(r *http.Request) (analytics.Grouping, error) {
+	group := analytics.Grouping(r.URL.Query().Get("group"))
+	if group == "" {
+		return analytics.GroupWeek, nil
+	}
+
+	if !group.Valid() {
+		return "", errors.New("Group must be one of day, week, month")
+	}
+
+	return group, nil
+}
+
+func (sh *StatsHandler) HandleGetVolume(w http.ResponseWriter, r *http.Request) {
+	filter, err := sh.readFilter(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	group, err := sh.readGrouping(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	volume, err := sh.statsStore.Volume(filter, group)
+	if err != nil {
+		sh.logger.Printf("[ERROR] Volume: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	for i := range volume {
+		volume[i].Tonnage = unit.FromKilograms(volume[i].Tonnage)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"group": group, "volume": volume})
+}
+
+func (sh *StatsHandler) HandleGetFrequency(w http.ResponseWriter, r *http.Request) {
+	filter, err := sh.readFilter(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	group, err := sh.readGrouping(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	frequency, err := sh.statsStore.Frequency(filter, group)
+	if err != nil {
+		sh.logger.Printf("[ERROR] Frequency: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"group": group, "frequency": frequency})
+}
+
+func (sh *StatsHandler) HandleGetSummary(w http.ResponseWriter, r *http.Request) {
+	filter, err := sh.readFilter(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	summary, err := sh.statsStore.Summary(filter)
+	if err != nil {
+		sh.logger.Printf("[ERROR] Summary: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	summary.Tonnage = unit.FromKilograms(summary.Tonnage)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"summary": summary})
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+type TemplateHandler struct {
+	templateStore store.TemplateStore
+	workoutStore  store.WorkoutStore
+	logger        *log.Logger
+}
+
+type templateRequest struct {
+	Title       string                `json:"title"`
+	Description string                `json:"description"`
+	Entries     []store.TemplateEntry `json:"entries"`
+}
+
+func NewTemplateHandler(templateStore store.TemplateStore, workoutStore store.WorkoutStore, logger *log.Logger) *TemplateHandler {
+	return &TemplateHandler{
+		templateStore: templateStore,
+		workoutStore:  workoutStore,
+		logger:        logger,
+	}
+}
+
+func (th *TemplateHandler) validateTemplateRequest(req *templateRequest) error {
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" {
+		return errors.New("Title is required")
+	}
+
+	if len(req.Title) > 255 {
+		return errors.New("Title cannot be greater than 255 characters")
+	}
+
+	for _, entry := range req.Entries {
+		if entry.TargetSets < 1 || entry.TargetSets > maxSetsPerEntry {
+			return fmt.Errorf("Target sets must be between 1 and %d", maxSetsPerEntry)
+		}
+
+		if (entry.TargetRepsMin == nil) == (entry.TargetDurationSeconds == nil) {
+			return errors.New("Every entry needs either target_reps_min or target_duration_seconds")
+		}
+
+		if entry.TargetRepsMax != nil && (entry.TargetRepsMin == nil || *entry.TargetRepsMax < *entry.TargetRepsMin) {
+			return errors.New("target_reps_max cannot be lower than target_reps_min")
+		}
+	}
+
+	return nil
+}
+
+func (th *TemplateHandler) readTemplate(w http.ResponseWriter, r *http.Request) *store.WorkoutTemplate {
+	templateID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid template id"})
+		return nil
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	template, err := th.templateStore.GetTemplateByID(templateID, currentUser.ID)
+	if writeStoreError(w, err, "Template") {
+		return nil
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] GetTemplateByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	return template
+}
+
+func (th *TemplateHandler) HandleListTemplates(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	templates, err := th.templateStore.ListTemplates(currentUser.ID)
+	if err != nil {
+		th.logger.Printf("[ERROR] ListTemplates: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	for _, template := range templates {
+		template.ConvertWeights(unit.FromKilograms)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"templates": templates})
+}
+
+func (th *TemplateHandler) HandleGetTemplateByID(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	template := th.readTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	template.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"template": template})
+}
+
+func (th *TemplateHandler) HandleCreateTemplate(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	var req templateRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		th.logger.Printf("[ERROR] Decoding on HandleCreateTemplate: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	err = th.validateTemplateRequest(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	template := &store.WorkoutTemplate{
+		UserID:      currentUser.ID,
+		Title:       req.Title,
+		Description: req.Description,
+		Entries:     req.Entries,
+	}
+	template.ConvertWeights(unit.ToKilograms)
+
+	err = th.templateStore.CreateTemplate(template)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Every entry needs a known exercise_id or an exercise_name"})
+		return
+	}
+	if writeStoreError(w, err, "Template") {
+		return
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] CreateTemplate: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Failed to create template"})
+		return
+	}
+
+	template.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"template": template})
+}
+
+func (th *TemplateHandler) HandleUpdateTemplateByID(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	template := th.readTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	var req templateRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		th.logger.Printf("[ERROR] Decoding on HandleUpdateTemplateByID: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	err = th.validateTemplateRequest(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	template.Title = req.Title
+	template.Description = req.Description
+	template.Entries = req.Entries
+	template.ConvertWeights(unit.ToKilograms)
+
+	err = th.templateStore.UpdateTemplate(template)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Every entry needs a known exercise_id or an exercise_name"})
+		return
+	}
+	if writeStoreError(w, err, "Template") {
+		return
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] UpdateTemplate: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	template.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"template": template})
+}
+
+func (th *TemplateHandler) HandleDeleteTemplateByID(w http.ResponseWriter, r *http.Request) {
+	templateID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid template id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	err = th.templateStore.DeleteTemplate(templateID, currentUser.ID)
+	if errors.Is(err, store.ErrTemplateInUse) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "Template is used by a program"})
+		return
+	}
+	if writeStoreError(w, err, "Template") {
+		return
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] DeleteTemplate: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleStartWorkout logs a new workout from the template, prefilled with the
+// template's targets and the last performance of each exercise. Clients then
+// adjust it with PUT /workouts/{id} as the session goes.
+func (th *TemplateHandler) HandleStartWorkout(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	template := th.readTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	exerciseIDs := []int{}
+	for _, entry := range template.Entries {
+		exerciseIDs = append(exerciseIDs, *entry.ExerciseID)
+	}
+
+	last, err := th.workoutStore.GetLastEntries(template.UserID, exerciseIDs)
+	if err != nil {
+		th.logger.Printf("[ERROR] GetLastEntries: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	workout, err := th.workoutStore.CreateWorkout(template.NewWorkout(last))
+	if err != nil {
+		th.logger.Printf("[ERROR] CreateWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Failed to create workout"})
+		return
+	}
+
+	workout.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"workout": workout})
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTemplateRequestTargetSets(t *testing.T) {
+	handler := NewTemplateHandler(nil, nil, log.New(io.Discard, "", 0))
+
+	for _, tt := range []struct {
+		sets  int
+		valid bool
+	}{
+		{sets: 0, valid: false},
+		{sets: -1, valid: false},
+		{sets: maxSetsPerEntry + 1, valid: false},
+		{sets: 2000000000, valid: false},
+		{sets: 1, valid: true},
+		{sets: maxSetsPerEntry, valid: true},
+	} {
+		req := &templateRequest{
+			Title:   "Push day",
+			Entries: []store.TemplateEntry{{TargetSets: tt.sets, TargetRepsMin: testutil.IntPtr(8)}},
+		}
+		err := handler.validateTemplateRequest(req)
+		if tt.valid {
+			assert.NoError(t, err, "sets %d", tt.sets)
+		} else {
+			assert.Error(t, err, "sets %d", tt.sets)
+		}
+	}
+}
@@ -2,32 +2,136 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gonstoll/workouts/internal/middleware"
 	"github.com/gonstoll/workouts/internal/store"
 	"github.com/gonstoll/workouts/internal/tokens"
 	"github.com/gonstoll/workouts/internal/utils"
 )
 
 type TokenHandler struct {
-	tokenStore store.TokenStore
-	userStore  store.UserStore
-	logger     *log.Logger
+	sessionIssuer
+	userStore     store.UserStore
+	loginAttempts store.LoginAttemptStore
 }
 
+var (
+	// usernameLoginPolicy slows down guessing one account's password
+	usernameLoginPolicy = store.LoginPolicy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+	// ipLoginPolicy slows down one client spraying many accounts. It's looser
+	// since several people can share an address.
+	ipLoginPolicy = store.LoginPolicy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+	// twoFactorLoginPolicy slows down guessing codes once the password is known
+	twoFactorLoginPolicy = store.LoginPolicy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
 type createTokenRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
-func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, logger *log.Logger) *TokenHandler {
+// NewTokenHandler takes a signer when access tokens should be signed JWTs, and
+// nil to keep them opaque
+func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, twoFactorStore store.TwoFactorStore, loginAttempts store.LoginAttemptStore, signer *tokens.Signer, logger *log.Logger) *TokenHandler {
 	return &TokenHandler{
-		tokenStore: tokenStore,
-		userStore:  userStore,
-		logger:     logger,
+		sessionIssuer: sessionIssuer{
+			tokenStore:     tokenStore,
+			twoFactorStore: twoFactorStore,
+			signer:         signer,
+			logger:         logger,
+		},
+		userStore:     userStore,
+		loginAttempts: loginAttempts,
+	}
+}
+
+func usernameLoginKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// loginKeys returns the attempt keys for a login: one for the username and one
+// for the client's IP, with the policy for each
+func loginKeys(r *http.Request, username string) map[string]store.LoginPolicy {
+	return map[string]store.LoginPolicy{
+		usernameLoginKey(username): usernameLoginPolicy,
+		"ip:" + clientIP(r):        ipLoginPolicy,
+	}
+}
+
+// writeRetryAfter sets Retry-After to the whole seconds left until lockedUntil
+func writeRetryAfter(w http.ResponseWriter, lockedUntil time.Time) {
+	seconds := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
+
+// checkLockout writes a 429 and reports false when any of the keys is locked out
+func (th *TokenHandler) checkLockout(w http.ResponseWriter, keys map[string]store.LoginPolicy) bool {
+	for key := range keys {
+		lockedUntil, err := th.loginAttempts.LockedUntil(key)
+		if err != nil {
+			th.logger.Printf("[ERROR] LockedUntil: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return false
+		}
+		if !lockedUntil.IsZero() {
+			writeRetryAfter(w, lockedUntil)
+			utils.WriteJSON(w, http.StatusTooManyRequests, utils.Envelope{"error": "Too many failed login attempts, try again later"})
+			return false
+		}
 	}
+	return true
+}
+
+// recordLoginFailure counts the failure against every key and writes the 401,
+// with a Retry-After if this failure locked any of them
+func (th *TokenHandler) recordLoginFailure(w http.ResponseWriter, keys map[string]store.LoginPolicy, message string) {
+	var lockedUntil time.Time
+	for key, policy := range keys {
+		until, err := th.loginAttempts.RecordFailure(key, policy)
+		if err != nil {
+			th.logger.Printf("[ERROR] RecordFailure: %v", err)
+			continue
+		}
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+
+	if lockedUntil.After(time.Now()) {
+		writeRetryAfter(w, lockedUntil)
+	}
+	utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": message})
 }
 
 func (th *TokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
@@ -39,11 +143,23 @@ func (th *TokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Refuse locked out usernames and clients before doing any bcrypt work
+	keys := loginKeys(r, req.Username)
+	if !th.checkLockout(w, keys) {
+		return
+	}
+
 	// Get the user and match passwords
 	user, err := th.userStore.GetUserByUsername(req.Username)
-	if err != nil || user == nil {
+	if errors.Is(err, store.ErrNotFound) {
+		// Spend the same time as a wrong password so usernames can't be probed
+		store.SimulatePasswordCheck(req.Password)
+		th.recordLoginFailure(w, keys, "Invalid username or password")
+		return
+	}
+	if err != nil {
 		th.logger.Printf("[ERROR] GetUserByUsername: %v", err)
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Invalid username or password"})
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
 		return
 	}
 
@@ -55,16 +171,203 @@ func (th *TokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request
 	}
 
 	if !passwordsMatch {
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Invalid username or password"})
+		th.recordLoginFailure(w, keys, "Invalid username or password")
+		return
+	}
+
+	// Only the username starts over, otherwise one valid account would let a
+	// client keep spraying others from the same address
+	err = th.loginAttempts.ResetAttempts(usernameLoginKey(req.Username))
+	if err != nil {
+		th.logger.Printf("[ERROR] ResetAttempts: %v", err)
+	}
+
+	th.writeLogin(w, user)
+}
+
+// HandleVerifyTwoFactor finishes a 2FA login by trading the challenge token and
+// a TOTP or recovery code for the real tokens
+func (th *TokenHandler) HandleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.ChallengeToken == "" || req.Code == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "challenge_token and code are required"})
+		return
+	}
+
+	user, err := th.userStore.GetUserToken(tokens.ScopeTwoFactor, req.ChallengeToken)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Challenge token expired or invalid, please log in again"})
+		return
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] GetUserToken: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	keys := map[string]store.LoginPolicy{"2fa:" + strconv.Itoa(user.ID): twoFactorLoginPolicy}
+	if !th.checkLockout(w, keys) {
 		return
 	}
 
-	token, err := th.tokenStore.CreateNewToken(user.ID, 24*time.Hour, tokens.ScopeAuth)
+	secret, err := th.twoFactorStore.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		th.logger.Printf("[ERROR] GetTOTP: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+	if !secret.Enabled() {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Challenge token expired or invalid, please log in again"})
+		return
+	}
+
+	ok, err := checkSecondFactor(th.twoFactorStore, secret, req.Code)
+	if err != nil {
+		th.logger.Printf("[ERROR] checkSecondFactor: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+	if !ok {
+		th.recordLoginFailure(w, keys, "Invalid code")
+		return
+	}
+
+	for key := range keys {
+		err = th.loginAttempts.ResetAttempts(key)
+		if err != nil {
+			th.logger.Printf("[ERROR] ResetAttempts: %v", err)
+		}
+	}
+
+	err = th.tokenStore.DeleteToken(req.ChallengeToken, tokens.ScopeTwoFactor)
+	if err != nil {
+		th.logger.Printf("[ERROR] DeleteToken: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	th.writeTokenPair(w, user)
+}
+
+func (th *TokenHandler) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "refresh_token is required"})
+		return
+	}
+
+	pair, err := th.tokenStore.RotateRefreshToken(req.RefreshToken, th.accessTTL(), refreshTokenTTL)
+	if errors.Is(err, store.ErrTokenReused) {
+		th.logger.Printf("[ERROR] RotateRefreshToken: %v", err)
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Refresh token was already used, please log in again"})
+		return
+	}
+	if errors.Is(err, store.ErrInvalidToken) {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Refresh token expired or invalid"})
+		return
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] RotateRefreshToken: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	// A signed token carries the user's current details, so they're loaded
+	// fresh on every refresh
+	var user *store.User
+	if th.signer != nil {
+		user, err = th.userStore.GetUserByID(int64(pair.Access.UserID))
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			th.logger.Printf("[ERROR] GetUserByID: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return
+		}
+		if user == nil || user.IsDisabled() {
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Refresh token expired or invalid"})
+			return
+		}
+	}
+
+	th.writePair(w, pair, user)
+}
+
+// HandleDeleteToken logs out by revoking the token the request was made with.
+// A signed token can't be revoked itself, so its session's rows go instead and
+// it stops working once it expires.
+func (th *TokenHandler) HandleDeleteToken(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if claims := middleware.GetAccessClaims(r); claims != nil {
+		err = th.tokenStore.DeleteTokenByID(int64(claims.TokenID), claims.UserID, tokens.ScopeAuth)
+		if errors.Is(err, store.ErrNotFound) {
+			err = nil
+		}
+	} else {
+		err = th.tokenStore.DeleteToken(middleware.GetToken(r), tokens.ScopeAuth)
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] DeleteToken: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleDeleteAllTokens logs the user out everywhere, including this request's
+// own session
+func (th *TokenHandler) HandleDeleteAllTokens(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh} {
+		err := th.tokenStore.DeleteAllTokensForUser(currentUser.ID, scope)
+		if err != nil {
+			th.logger.Printf("[ERROR] DeleteAllTokensForUser: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+func (th *TokenHandler) HandleDeleteTokenByID(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid token id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	err = th.tokenStore.DeleteTokenByID(tokenID, currentUser.ID, tokens.ScopeAuth)
+	if writeStoreError(w, err, "Token") {
+		return
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] DeleteTokenByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+func (th *TokenHandler) HandleListTokens(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	list, err := th.tokenStore.ListTokens(currentUser.ID, tokens.ScopeAuth)
 	if err != nil {
-		th.logger.Printf("[ERROR] CreateNewToken: %v", err)
+		th.logger.Printf("[ERROR] ListTokens: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": token})
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"tokens": list})
 }
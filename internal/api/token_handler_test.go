@@ -0,0 +1,223 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/gonstoll/workouts/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type loginUserStore struct {
+	store.UserStore
+	user      *store.User
+	challenge string
+}
+
+func (s *loginUserStore) GetUserToken(scope, plaintext string) (*store.User, error) {
+	if scope == tokens.ScopeTwoFactor && plaintext == s.challenge {
+		return s.user, nil
+	}
+	return nil, store.ErrNotFound
+}
+
+type loginTokenStore struct {
+	fakeTokenStore
+	deleted []string
+}
+
+func (s *loginTokenStore) CreateTokenPair(userID int, accessTTL, refreshTTL time.Duration) (*store.TokenPair, error) {
+	access, _ := tokens.GenerateToken(userID, accessTTL, tokens.ScopeAuth)
+	refresh, _ := tokens.GenerateToken(userID, refreshTTL, tokens.ScopeRefresh)
+	return &store.TokenPair{Access: access, Refresh: refresh}, nil
+}
+
+func (s *loginTokenStore) DeleteToken(plaintext, scope string) error {
+	s.deleted = append(s.deleted, plaintext)
+	return nil
+}
+
+type fakeTwoFactorStore struct {
+	store.TwoFactorStore
+	secret   *store.TOTP
+	lastStep int64
+}
+
+func (s *fakeTwoFactorStore) GetTOTP(userID int) (*store.TOTP, error) {
+	if s.secret == nil {
+		return nil, store.ErrNotFound
+	}
+	return s.secret, nil
+}
+
+func (s *fakeTwoFactorStore) UseTOTPStep(userID int, step int64) (bool, error) {
+	if step <= s.lastStep {
+		return false, nil
+	}
+	s.lastStep = step
+	return true, nil
+}
+
+func (s *fakeTwoFactorStore) UseRecoveryCode(userID int, code string) (bool, error) {
+	return false, nil
+}
+
+func (s *loginUserStore) GetUserByUsername(username string) (*store.User, error) {
+	if s.user != nil && s.user.Username == username {
+		return s.user, nil
+	}
+	return nil, store.ErrNotFound
+}
+
+func login(handler *TokenHandler, username, password string) *httptest.ResponseRecorder {
+	body := `{"username": "` + username + `", "password": "` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/token/authentication", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.HandleCreateToken(rec, req)
+	return rec
+}
+
+func TestHandleCreateTokenLocksOut(t *testing.T) {
+	user := &store.User{ID: 1, Username: "gonzalo"}
+	err := user.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	handler := NewTokenHandler(nil, &loginUserStore{user: user}, nil, store.NewMemoryLoginAttemptStore(), nil, log.New(io.Discard, "", 0))
+
+	for range usernameLoginPolicy.FreeAttempts {
+		rec := login(handler, "gonzalo", "wrong")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+	}
+
+	rec := login(handler, "gonzalo", "wrong")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// Even the right password is refused while locked out
+	rec = login(handler, "gonzalo", "securepassword")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	seconds, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Positive(t, seconds)
+
+	// Usernames are matched case-insensitively
+	rec = login(handler, "GONZALO", "securepassword")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestHandleCreateTokenUnknownUser(t *testing.T) {
+	handler := NewTokenHandler(nil, &loginUserStore{}, nil, store.NewMemoryLoginAttemptStore(), nil, log.New(io.Discard, "", 0))
+
+	rec := login(handler, "nobody", "whatever")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Invalid username or password")
+}
+
+func TestHandleCreateTokenWithTwoFactor(t *testing.T) {
+	user := &store.User{ID: 1, Username: "gonzalo"}
+	err := user.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	confirmedAt := time.Now()
+
+	userStore := &loginUserStore{user: user}
+	tokenStore := &loginTokenStore{}
+	twoFactorStore := &fakeTwoFactorStore{secret: &store.TOTP{UserID: 1, Secret: secret, ConfirmedAt: &confirmedAt}}
+	handler := NewTokenHandler(tokenStore, userStore, twoFactorStore, store.NewMemoryLoginAttemptStore(), nil, log.New(io.Discard, "", 0))
+
+	rec := login(handler, "gonzalo", "securepassword")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "auth_token")
+	require.Len(t, tokenStore.issued, 1)
+	assert.Equal(t, tokens.ScopeTwoFactor, tokenStore.issued[0].Scope)
+	userStore.challenge = tokenStore.issued[0].Plaintext
+
+	verify := func(challenge, code string) *httptest.ResponseRecorder {
+		body := `{"challenge_token": "` + challenge + `", "code": "` + code + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/token/2fa", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.HandleVerifyTwoFactor(rec, req)
+		return rec
+	}
+
+	rec = verify(userStore.challenge, "abcdef")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Invalid code")
+
+	rec = verify("not-the-challenge", "123456")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+
+	rec = verify(userStore.challenge, code)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), "auth_token")
+	assert.Equal(t, []string{userStore.challenge}, tokenStore.deleted)
+
+	// The same code can't be used twice
+	rec = verify(userStore.challenge, code)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestSignedAccessTokens(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := tokens.NewSigner("workouts", tokens.SigningKey{ID: "test", PrivateKey: private})
+	require.NoError(t, err)
+
+	user := &store.User{ID: 1, Username: "gonzalo", Role: store.RoleAdmin, Activated: true, PreferredUnit: store.UnitPounds}
+	err = user.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	handler := NewTokenHandler(&loginTokenStore{}, &loginUserStore{user: user}, &fakeTwoFactorStore{}, store.NewMemoryLoginAttemptStore(), signer, log.New(io.Discard, "", 0))
+
+	rec := login(handler, "gonzalo", "securepassword")
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var res struct {
+		AuthToken tokens.Token `json:"auth_token"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&res)
+	require.NoError(t, err)
+	require.True(t, tokens.IsJWT(res.AuthToken.Plaintext))
+
+	// The middleware trusts the token without a user store to ask
+	um := middleware.UserMiddleware{Signer: signer}
+	var seen *store.User
+	protected := um.Authenticate(um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.GetUser(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/workouts", nil)
+	req.Header.Set("Authorization", "Bearer "+res.AuthToken.Plaintext)
+	rec = httptest.NewRecorder()
+	protected.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, seen)
+	assert.Equal(t, 1, seen.ID)
+	assert.Equal(t, store.RoleAdmin, seen.Role)
+	assert.Equal(t, store.UnitPounds, seen.PreferredUnit)
+
+	req.Header.Set("Authorization", "Bearer "+res.AuthToken.Plaintext+"x")
+	rec = httptest.NewRecorder()
+	protected.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/totp"
+	"github.com/gonstoll/workouts/internal/utils"
+)
+
+const (
+	totpIssuer        = "Workouts"
+	recoveryCodeCount = 10
+)
+
+type TwoFactorHandler struct {
+	twoFactorStore store.TwoFactorStore
+	logger         *log.Logger
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func NewTwoFactorHandler(twoFactorStore store.TwoFactorStore, logger *log.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorStore: twoFactorStore,
+		logger:         logger,
+	}
+}
+
+// checkSecondFactor accepts either a current TOTP code or one of the user's
+// unused recovery codes, spending whichever it was
+func checkSecondFactor(twoFactorStore store.TwoFactorStore, secret *store.TOTP, code string) (bool, error) {
+	step, ok := totp.Validate(secret.Secret, code, time.Now())
+	if ok {
+		return twoFactorStore.UseTOTPStep(secret.UserID, step)
+	}
+
+	return twoFactorStore.UseRecoveryCode(secret.UserID, totp.NormalizeRecoveryCode(code))
+}
+
+// generateRecoveryCodes returns fresh codes to show the user along with their
+// normalized form to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = totp.NormalizeRecoveryCode(code)
+	}
+
+	return codes, normalized, nil
+}
+
+// readTOTP loads the current user's secret. It writes the error response
+// itself and returns nil when the request shouldn't go any further.
+func (th *TwoFactorHandler) readTOTP(w http.ResponseWriter, r *http.Request) *store.TOTP {
+	secret, err := th.twoFactorStore.GetTOTP(middleware.GetUser(r).ID)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Two-factor authentication is not set up"})
+		return nil
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] GetTOTP: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return nil
+	}
+
+	return secret
+}
+
+// HandleEnrollTOTP starts enrollment with a fresh secret. Logins aren't
+// affected until the secret is confirmed.
+func (th *TwoFactorHandler) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	existing, err := th.twoFactorStore.GetTOTP(currentUser.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		th.logger.Printf("[ERROR] GetTOTP: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	if existing.Enabled() {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		th.logger.Printf("[ERROR] GenerateSecret: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = th.twoFactorStore.SetupTOTP(currentUser.ID, secret)
+	if err != nil {
+		th.logger.Printf("[ERROR] SetupTOTP: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, currentUser.Username, secret),
+	})
+}
+
+// HandleConfirmTOTP turns 2FA on once the user proves their app has the secret,
+// and hands out the recovery codes. They're only shown this once.
+func (th *TwoFactorHandler) HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "code is required"})
+		return
+	}
+
+	secret := th.readTOTP(w, r)
+	if secret == nil {
+		return
+	}
+
+	if secret.Enabled() {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	step, ok := totp.Validate(secret.Secret, req.Code, time.Now())
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": "Invalid code"})
+		return
+	}
+
+	codes, normalized, err := generateRecoveryCodes()
+	if err != nil {
+		th.logger.Printf("[ERROR] GenerateRecoveryCodes: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = th.twoFactorStore.ConfirmTOTP(secret.UserID, step, normalized)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if err != nil {
+		th.logger.Printf("[ERROR] ConfirmTOTP: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"recovery_codes": codes})
+}
+
+// HandleRegenerateRecoveryCodes replaces every recovery code. It takes a
+// current code so a stolen session alone can't read out new ones.
+func (th *TwoFactorHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "code is required"})
+		return
+	}
+
+	secret := th.readTOTP(w, r)
+	if secret == nil {
+		return
+	}
+
+	if !secret.Enabled() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Two-factor authentication is not set up"})
+		return
+	}
+
+	ok, err := checkSecondFactor(th.twoFactorStore, secret, req.Code)
+	if err != nil {
+		th.logger.Printf("[ERROR] checkSecondFactor: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": "Invalid code"})
+		return
+	}
+
+	codes, normalized, err := generateRecoveryCodes()
+	if err != nil {
+		th.logger.Printf("[ERROR] GenerateRecoveryCodes: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = th.twoFactorStore.ReplaceRecoveryCodes(secret.UserID, normalized)
+	if err != nil {
+		th.logger.Printf("[ERROR] ReplaceRecoveryCodes: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"recovery_codes": codes})
+}
+
+// HandleDisableTOTP turns 2FA off. It asks for the password again, so a stolen
+// session alone can't strip the second factor.
+func (th *TwoFactorHandler) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Password == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "password is required"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	passwordsMatch, err := currentUser.PasswordHash.Matches(req.Password)
+	if err != nil {
+		th.logger.Printf("[ERROR] PasswordHash.Matches: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+	if !passwordsMatch {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Invalid password"})
+		return
+	}
+
+	err = th.twoFactorStore.DisableTOTP(currentUser.ID)
+	if err != nil {
+		th.logger.Printf("[ERROR] DisableTOTP: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
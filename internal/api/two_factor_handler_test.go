@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// confirmingTwoFactorStore fails any call besides GetTOTP and ConfirmTOTP, so
+// confirming can't write anything outside the one transaction
+type confirmingTwoFactorStore struct {
+	store.TwoFactorStore
+	secret    *store.TOTP
+	step      int64
+	codes     []string
+	confirmed int
+}
+
+func (s *confirmingTwoFactorStore) GetTOTP(userID int) (*store.TOTP, error) {
+	return s.secret, nil
+}
+
+func (s *confirmingTwoFactorStore) ConfirmTOTP(userID int, step int64, recoveryCodes []string) error {
+	s.confirmed++
+	s.step, s.codes = step, recoveryCodes
+	return nil
+}
+
+func TestHandleConfirmTOTP(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+
+	user := &store.User{ID: 1, Username: "gonzalo"}
+	twoFactorStore := &confirmingTwoFactorStore{secret: &store.TOTP{UserID: user.ID, Secret: secret}}
+	handler := NewTwoFactorHandler(twoFactorStore, log.New(io.Discard, "", 0))
+
+	rec := httptest.NewRecorder()
+	handler.HandleConfirmTOTP(rec, requestAs(user, http.MethodPost, "/users/me/2fa/confirm", `{"code": "000000x"}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Zero(t, twoFactorStore.confirmed)
+
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	require.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	handler.HandleConfirmTOTP(rec, requestAs(user, http.MethodPost, "/users/me/2fa/confirm", `{"code": "`+code+`"}`))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, twoFactorStore.confirmed)
+	assert.Equal(t, step, twoFactorStore.step)
+	require.Len(t, body.RecoveryCodes, recoveryCodeCount)
+	require.Len(t, twoFactorStore.codes, recoveryCodeCount)
+	assert.Equal(t, totp.NormalizeRecoveryCode(body.RecoveryCodes[0]), twoFactorStore.codes[0])
+}
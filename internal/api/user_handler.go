@@ -3,11 +3,18 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/gonstoll/workouts/internal/mailer"
+	"github.com/gonstoll/workouts/internal/middleware"
 	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
 	"github.com/gonstoll/workouts/internal/utils"
 )
 
@@ -17,45 +24,91 @@ type password struct {
 }
 
 type registerUserRequest struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Bio      string `json:"bio"`
+	Username      string           `json:"username"`
+	Email         string           `json:"email"`
+	Password      string           `json:"password"`
+	Bio           string           `json:"bio"`
+	PreferredUnit store.WeightUnit `json:"preferred_unit"`
 }
 
 type UserHandler struct {
-	userStore store.UserStore
-	logger    *log.Logger
+	userStore  store.UserStore
+	tokenStore store.TokenStore
+	attempts   store.LoginAttemptStore
+	mailer     mailer.Mailer
+	logger     *log.Logger
+	// wg tracks the work started with background
+	wg sync.WaitGroup
 }
 
-func NewUserHandler(userStore store.UserStore, logger *log.Logger) *UserHandler {
+func NewUserHandler(userStore store.UserStore, tokenStore store.TokenStore, attempts store.LoginAttemptStore, mailer mailer.Mailer, logger *log.Logger) *UserHandler {
 	return &UserHandler{
-		userStore: userStore,
-		logger:    logger,
+		userStore:  userStore,
+		tokenStore: tokenStore,
+		attempts:   attempts,
+		mailer:     mailer,
+		logger:     logger,
 	}
 }
 
+// background runs fn without holding up the response, so how long it takes
+// can't tell the client anything
+func (uh *UserHandler) background(fn func()) {
+	uh.wg.Add(1)
+	go func() {
+		defer uh.wg.Done()
+		fn()
+	}()
+}
+
 func (uh *UserHandler) validateRegisterRequest(req *registerUserRequest) error {
-	if req.Username == "" {
+	err := validateProfile(req.Username, req.Email, req.PreferredUnit)
+	if err != nil {
+		return err
+	}
+
+	if req.Password == "" {
+		return errors.New("Password is required")
+	}
+
+	return nil
+}
+
+// reservedUsernames are the static paths under /users. A profile with one of
+// these names would be shadowed by the route.
+var reservedUsernames = map[string]bool{"me": true, "activate": true}
+
+func isReservedUsername(username string) bool {
+	return reservedUsernames[strings.ToLower(username)]
+}
+
+// validateProfile checks the fields a user picks at registration and can change
+// later with PATCH /users/me
+func validateProfile(username, email string, unit store.WeightUnit) error {
+	if username == "" {
 		return errors.New("Username is required")
 	}
 
-	if len(req.Username) > 50 {
+	if len(username) > 50 {
 		return errors.New("Username cannot be greater than 50 characters")
 	}
 
-	if req.Email == "" {
+	if isReservedUsername(username) {
+		return errors.New("That username is reserved")
+	}
+
+	if email == "" {
 		return errors.New("Email is required")
 	}
 
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 
-	if !emailRegex.MatchString(req.Email) {
+	if !emailRegex.MatchString(email) {
 		return errors.New("Invalid email format")
 	}
 
-	if req.Password == "" {
-		return errors.New("Password is required")
+	if unit != "" && !unit.Valid() {
+		return errors.New("Preferred unit must be kg or lb")
 	}
 
 	return nil
@@ -77,8 +130,9 @@ func (uh *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request
 	}
 
 	user := &store.User{
-		Username: req.Username,
-		Email:    req.Email,
+		Username:      req.Username,
+		Email:         req.Email,
+		PreferredUnit: req.PreferredUnit,
 	}
 
 	if req.Bio != "" {
@@ -93,11 +147,501 @@ func (uh *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request
 	}
 
 	err = uh.userStore.CreateUser(user)
+	if writeStoreError(w, err, "User") {
+		return
+	}
 	if err != nil {
 		uh.logger.Printf("[ERROR] Registering user: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
 		return
 	}
 
+	// The account exists at this point, so a failed email is only logged
+	// rather than failing the registration
+	err = uh.sendActivationEmail(user)
+	if err != nil {
+		uh.logger.Printf("[ERROR] Sending activation email: %v", err)
+	}
+
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"user": user})
 }
+
+const activationTTL = 3 * 24 * time.Hour
+
+func (uh *UserHandler) sendActivationEmail(user *store.User) error {
+	token, err := uh.tokenStore.CreateNewToken(user.ID, activationTTL, tokens.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nWelcome! Activate your account by sending this token to PUT /users/activate:\n\n{\"token\": \"%s\"}\n\nIt expires in %d days.\n",
+		user.Username, token.Plaintext, int(activationTTL.Hours()/24))
+	return uh.mailer.Send(user.Email, "Activate your account", body)
+}
+
+var (
+	// resendEmailPolicy limits how many activation emails one address gets
+	resendEmailPolicy = store.LoginPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+	// resendIPPolicy limits one client asking for many addresses
+	resendIPPolicy = store.LoginPolicy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+)
+
+// HandleResendActivation emails a new activation token to the account with
+// that email if it isn't activated yet. It answers the same way, and just as
+// fast, whether or not there is such an account.
+func (uh *UserHandler) HandleResendActivation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Email is required"})
+		return
+	}
+
+	// Counted by the address asked for rather than the account, so the limit
+	// doesn't depend on whether the account exists either
+	keys := map[string]store.LoginPolicy{
+		"activation:" + strings.ToLower(req.Email): resendEmailPolicy,
+		"activation-ip:" + clientIP(r):             resendIPPolicy,
+	}
+	for key := range keys {
+		lockedUntil, err := uh.attempts.LockedUntil(key)
+		if err != nil {
+			uh.logger.Printf("[ERROR] LockedUntil: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return
+		}
+		if !lockedUntil.IsZero() {
+			writeRetryAfter(w, lockedUntil)
+			utils.WriteJSON(w, http.StatusTooManyRequests, utils.Envelope{"error": "Too many activation emails requested, try again later"})
+			return
+		}
+	}
+	for key, policy := range keys {
+		_, err := uh.attempts.RecordFailure(key, policy)
+		if err != nil {
+			uh.logger.Printf("[ERROR] RecordFailure: %v", err)
+		}
+	}
+
+	uh.background(func() {
+		user, err := uh.userStore.GetUserByEmail(req.Email)
+		if errors.Is(err, store.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			uh.logger.Printf("[ERROR] GetUserByEmail: %v", err)
+			return
+		}
+		if user.Activated {
+			return
+		}
+
+		// Only the latest activation email works
+		err = uh.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopeActivation)
+		if err != nil {
+			uh.logger.Printf("[ERROR] DeleteAllTokensForUser: %v", err)
+			return
+		}
+
+		err = uh.sendActivationEmail(user)
+		if err != nil {
+			uh.logger.Printf("[ERROR] Sending activation email: %v", err)
+		}
+	})
+
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"message": "If that email is waiting for activation, a new token is on its way"})
+}
+
+func (uh *UserHandler) HandleActivateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Token == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "token is required"})
+		return
+	}
+
+	user, err := uh.userStore.GetUserToken(tokens.ScopeActivation, req.Token)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Activation token expired or invalid"})
+		return
+	}
+	if err != nil {
+		uh.logger.Printf("[ERROR] GetUserToken: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	user.Activated = true
+	err = uh.userStore.UpdateUser(user)
+	if err != nil {
+		uh.logger.Printf("[ERROR] UpdateUser: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = uh.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopeActivation)
+	if err != nil {
+		uh.logger.Printf("[ERROR] DeleteAllTokensForUser: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+func (uh *UserHandler) HandleUpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PreferredUnit store.WeightUnit `json:"preferred_unit"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		uh.logger.Printf("[ERROR] Decoding on HandleUpdatePreferences: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	if !req.PreferredUnit.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Preferred unit must be kg or lb"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	user.PreferredUnit = req.PreferredUnit
+
+	err = uh.userStore.UpdateUser(user)
+	if err != nil {
+		uh.logger.Printf("[ERROR] UpdateUser: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+const passwordResetTTL = 30 * time.Minute
+
+// HandleRequestPasswordReset emails a reset token to the account with that
+// email. It answers the same way, and just as fast, whether or not the account
+// exists so it can't be used to find out who is registered.
+func (uh *UserHandler) HandleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Email is required"})
+		return
+	}
+
+	uh.background(func() {
+		err := uh.sendPasswordResetEmail(req.Email)
+		if err != nil {
+			uh.logger.Printf("[ERROR] Sending password reset email: %v", err)
+		}
+	})
+
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"message": "If that email is registered, a reset token is on its way"})
+}
+
+func (uh *UserHandler) sendPasswordResetEmail(email string) error {
+	user, err := uh.userStore.GetUserByEmail(email)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only the latest reset email works
+	err = uh.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	token, err := uh.tokenStore.CreateNewToken(user.ID, passwordResetTTL, tokens.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nUse this token to choose a new password:\n\n%s\n\nIt expires in %d minutes. If you didn't ask for a reset you can ignore this email.\n",
+		user.Username, token.Plaintext, int(passwordResetTTL.Minutes()))
+	return uh.mailer.Send(user.Email, "Reset your password", body)
+}
+
+// HandleResetPassword sets the new password and logs the user out everywhere
+func (uh *UserHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		uh.logger.Printf("[ERROR] Decoding on HandleResetPassword: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	if req.Password == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Password is required"})
+		return
+	}
+
+	user, err := uh.userStore.GetUserToken(tokens.ScopePasswordReset, req.Token)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Reset token expired or invalid"})
+		return
+	}
+	if err != nil {
+		uh.logger.Printf("[ERROR] GetUserToken: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = user.PasswordHash.Set(req.Password)
+	if err != nil {
+		uh.logger.Printf("[ERROR] Hashing password: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = uh.userStore.UpdatePassword(user)
+	if err != nil {
+		uh.logger.Printf("[ERROR] UpdatePassword: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	for _, scope := range []string{tokens.ScopePasswordReset, tokens.ScopeAuth, tokens.ScopeRefresh} {
+		err = uh.tokenStore.DeleteAllTokensForUser(user.ID, scope)
+		if err != nil {
+			uh.logger.Printf("[ERROR] DeleteAllTokensForUser: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Your password was reset, please log in again"})
+}
+
+func (uh *UserHandler) HandleGetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": middleware.GetUser(r)})
+}
+
+// HandleUpdateCurrentUser changes only the fields sent. A new email address
+// has to be activated again before the account can write, and needs the
+// current password since it's where password reset links go.
+func (uh *UserHandler) HandleUpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username        *string           `json:"username"`
+		Email           *string           `json:"email"`
+		Bio             *string           `json:"bio"`
+		PreferredUnit   *store.WeightUnit `json:"preferred_unit"`
+		CurrentPassword string            `json:"current_password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		uh.logger.Printf("[ERROR] Decoding on HandleUpdateCurrentUser: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	// validateProfile lets an empty unit through for registration, where it
+	// means the default. Here it would blank out the stored one.
+	if req.PreferredUnit != nil && !req.PreferredUnit.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Preferred unit must be kg or lb"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	usernameChanged := req.Username != nil && *req.Username != user.Username
+	emailChanged := req.Email != nil && !strings.EqualFold(*req.Email, user.Email)
+
+	if req.Username != nil {
+		user.Username = *req.Username
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.Bio != nil {
+		user.Bio = *req.Bio
+	}
+	if req.PreferredUnit != nil {
+		user.PreferredUnit = *req.PreferredUnit
+	}
+
+	err = validateProfile(user.Username, user.Email, user.PreferredUnit)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if emailChanged {
+		passwordsMatch, err := user.PasswordHash.Matches(req.CurrentPassword)
+		if err != nil {
+			uh.logger.Printf("[ERROR] PasswordHash.Matches: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+			return
+		}
+		if !passwordsMatch {
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Current password is incorrect"})
+			return
+		}
+	}
+
+	if usernameChanged && !uh.checkAvailable(w, user.ID, uh.userStore.GetUserByUsername, user.Username, "username") {
+		return
+	}
+	if emailChanged && !uh.checkAvailable(w, user.ID, uh.userStore.GetUserByEmail, user.Email, "email") {
+		return
+	}
+
+	if emailChanged {
+		user.Activated = false
+
+		// Activation and reset links sent to the old address must not work
+		// for the new one
+		for _, scope := range []string{tokens.ScopeActivation, tokens.ScopePasswordReset} {
+			err = uh.tokenStore.DeleteAllTokensForUser(user.ID, scope)
+			if err != nil {
+				uh.logger.Printf("[ERROR] DeleteAllTokensForUser: %v", err)
+				utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+				return
+			}
+		}
+	}
+
+	err = uh.userStore.UpdateUser(user)
+	if writeStoreError(w, err, "User") {
+		return
+	}
+	if err != nil {
+		uh.logger.Printf("[ERROR] UpdateUser: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	if emailChanged {
+		err = uh.sendActivationEmail(user)
+		if err != nil {
+			uh.logger.Printf("[ERROR] Sending activation email: %v", err)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+// checkAvailable writes a 409 and reports false when an account other than
+// userID already uses value
+func (uh *UserHandler) checkAvailable(w http.ResponseWriter, userID int, lookup func(string) (*store.User, error), value, field string) bool {
+	existing, err := lookup(value)
+	if errors.Is(err, store.ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		uh.logger.Printf("[ERROR] Looking up %s: %v", field, err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return false
+	}
+
+	if existing.ID != userID {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "That " + field + " is already taken"})
+		return false
+	}
+
+	return true
+}
+
+// HandleChangePassword sets a new password once the current one checks out,
+// then logs the user out of every other session
+func (uh *UserHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		uh.logger.Printf("[ERROR] Decoding on HandleChangePassword: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
+		return
+	}
+
+	if req.NewPassword == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "New password is required"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	passwordsMatch, err := user.PasswordHash.Matches(req.CurrentPassword)
+	if err != nil {
+		uh.logger.Printf("[ERROR] PasswordHash.Matches: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+	if !passwordsMatch {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Current password is incorrect"})
+		return
+	}
+
+	familyID, err := uh.currentFamily(r)
+	if err != nil {
+		uh.logger.Printf("[ERROR] GetTokenFamily: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = user.PasswordHash.Set(req.NewPassword)
+	if err != nil {
+		uh.logger.Printf("[ERROR] Hashing password: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = uh.userStore.UpdatePassword(user)
+	if err != nil {
+		uh.logger.Printf("[ERROR] UpdatePassword: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	// Outstanding reset links were meant for the old password
+	err = uh.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopePasswordReset)
+	if err != nil {
+		uh.logger.Printf("[ERROR] DeleteAllTokensForUser: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	err = uh.tokenStore.DeleteOtherSessions(user.ID, familyID)
+	if err != nil {
+		uh.logger.Printf("[ERROR] DeleteOtherSessions: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "Your password was changed and your other sessions were logged out"})
+}
+
+// currentFamily returns the token family of the session the request was made
+// with
+func (uh *UserHandler) currentFamily(r *http.Request) (string, error) {
+	if claims := middleware.GetAccessClaims(r); claims != nil {
+		return claims.FamilyID, nil
+	}
+
+	return uh.tokenStore.GetTokenFamily(middleware.GetToken(r), tokens.ScopeAuth)
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sentMail struct {
+	recipient, subject, body string
+}
+
+type recordingMailer struct {
+	sent []sentMail
+}
+
+func (m *recordingMailer) Send(recipient, subject, body string) error {
+	m.sent = append(m.sent, sentMail{recipient, subject, body})
+	return nil
+}
+
+// The embedded interfaces are left nil, so calling anything the test doesn't
+// override panics
+type fakeUserStore struct {
+	store.UserStore
+	created *store.User
+	err     error
+}
+
+func (s *fakeUserStore) CreateUser(user *store.User) error {
+	if s.err != nil {
+		return s.err
+	}
+	user.ID = 1
+	s.created = user
+	return nil
+}
+
+type fakeTokenStore struct {
+	store.TokenStore
+	issued        []*tokens.Token
+	deletedScopes []string
+}
+
+func (s *fakeTokenStore) DeleteAllTokensForUser(userID int, scope string) error {
+	s.deletedScopes = append(s.deletedScopes, scope)
+	return nil
+}
+
+func (s *fakeTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	s.issued = append(s.issued, token)
+	return token, nil
+}
+
+func TestHandleRegisterUserSendsActivation(t *testing.T) {
+	userStore := &fakeUserStore{}
+	tokenStore := &fakeTokenStore{}
+	mail := &recordingMailer{}
+	handler := NewUserHandler(userStore, tokenStore, store.NewMemoryLoginAttemptStore(), mail, log.New(io.Discard, "", 0))
+
+	body := `{"username": "gonzalo", "email": "gonzalo@example.com", "password": "securepassword"}`
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	handler.HandleRegisterUser(rec, req)
+
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NotNil(t, userStore.created)
+	assert.False(t, userStore.created.Activated)
+
+	require.Len(t, tokenStore.issued, 1)
+	assert.Equal(t, tokens.ScopeActivation, tokenStore.issued[0].Scope)
+
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "gonzalo@example.com", mail.sent[0].recipient)
+	assert.Contains(t, mail.sent[0].body, tokenStore.issued[0].Plaintext)
+}
+
+func TestHandleRegisterUserTakenUsername(t *testing.T) {
+	userStore := &fakeUserStore{err: &store.ErrConflict{Field: "username"}}
+	mail := &recordingMailer{}
+	handler := NewUserHandler(userStore, &fakeTokenStore{}, store.NewMemoryLoginAttemptStore(), mail, log.New(io.Discard, "", 0))
+
+	body := `{"username": "gonzalo", "email": "gonzalo@example.com", "password": "securepassword"}`
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	handler.HandleRegisterUser(rec, req)
+
+	require.Equal(t, http.StatusConflict, rec.Code)
+	assert.JSONEq(t, `{"error": "username is already taken", "field": "username"}`, rec.Body.String())
+	assert.Empty(t, mail.sent)
+}
+
+func TestHandleResendActivation(t *testing.T) {
+	pending := &store.User{ID: 1, Username: "gonzalo", Email: "gonzalo@example.com"}
+	active := &store.User{ID: 2, Username: "maria", Email: "maria@example.com", Activated: true}
+	userStore := &profileUserStore{users: []*store.User{pending, active}}
+	tokenStore := &sessionTokenStore{}
+	mail := &recordingMailer{}
+	handler := NewUserHandler(userStore, tokenStore, store.NewMemoryLoginAttemptStore(), mail, log.New(io.Discard, "", 0))
+
+	resend := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users/activate/resend", strings.NewReader(`{"email": "`+email+`"}`))
+		rec := httptest.NewRecorder()
+		handler.HandleResendActivation(rec, req)
+		handler.wg.Wait()
+		return rec
+	}
+
+	rec := resend("Gonzalo@Example.com")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "gonzalo@example.com", mail.sent[0].recipient)
+	require.Len(t, tokenStore.issued, 1)
+	assert.Contains(t, mail.sent[0].body, tokenStore.issued[0].Plaintext)
+
+	// Activated and unknown accounts get the same answer and no email
+	for _, email := range []string{"maria@example.com", "nobody@example.com"} {
+		other := resend(email)
+		assert.Equal(t, http.StatusAccepted, other.Code)
+		assert.Equal(t, rec.Body.String(), other.Body.String())
+	}
+	assert.Len(t, mail.sent, 1)
+
+	for range 3 {
+		assert.Equal(t, http.StatusAccepted, resend("nobody@example.com").Code)
+	}
+	rec = resend("nobody@example.com")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestHandleRequestPasswordReset(t *testing.T) {
+	user := &store.User{ID: 1, Username: "gonzalo", Email: "gonzalo@example.com", Activated: true}
+	userStore := &profileUserStore{users: []*store.User{user}}
+	tokenStore := &sessionTokenStore{}
+	mail := &recordingMailer{}
+	handler := NewUserHandler(userStore, tokenStore, store.NewMemoryLoginAttemptStore(), mail, log.New(io.Discard, "", 0))
+
+	request := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/password-reset", strings.NewReader(`{"email": "`+email+`"}`))
+		rec := httptest.NewRecorder()
+		handler.HandleRequestPasswordReset(rec, req)
+		handler.wg.Wait()
+		return rec
+	}
+
+	known := request("gonzalo@example.com")
+	unknown := request("nobody@example.com")
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, known.Code, unknown.Code)
+	assert.Equal(t, known.Body.String(), unknown.Body.String())
+
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "gonzalo@example.com", mail.sent[0].recipient)
+	require.Len(t, tokenStore.issued, 1)
+	assert.Equal(t, tokens.ScopePasswordReset, tokenStore.issued[0].Scope)
+	assert.Contains(t, mail.sent[0].body, tokenStore.issued[0].Plaintext)
+}
@@ -1,11 +1,14 @@
 package api
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gonstoll/workouts/internal/middleware"
 	"github.com/gonstoll/workouts/internal/store"
@@ -22,6 +25,12 @@ func NewWorkoutHandler(workoutStore store.WorkoutStore, logger *log.Logger) *Wor
 }
 
 func (wh *WorkoutHandler) HandleGetWorkoutByID(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
 	workoutId, err := utils.ReadIDParam(r)
 	if err != nil {
 		wh.logger.Printf("[ERROR] ReadIDParam: %v", err)
@@ -31,31 +40,241 @@ func (wh *WorkoutHandler) HandleGetWorkoutByID(w http.ResponseWriter, r *http.Re
 
 	user := middleware.GetUser(r)
 
-	workout, err := wh.workoutStore.GetWorkoutByID(workoutId, user.ID)
+	// Other users' workouts are found too when they were shared with this one
+	workout, err := wh.workoutStore.GetVisibleWorkout(workoutId, user.ID)
+	if writeStoreError(w, err, "Workout") {
+		return
+	}
 	if err != nil {
 		wh.logger.Printf("[ERROR] GetWorkoutByID: %v", err)
-		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Workout not found"})
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	workout.ConvertWeights(unit.FromKilograms)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
+}
+
+const (
+	defaultWorkoutPageSize = 20
+	maxWorkoutPageSize     = 100
+)
+
+func readWorkoutFilter(r *http.Request) (store.WorkoutFilter, error) {
+	query := r.URL.Query()
+	filter := store.WorkoutFilter{
+		Title:        query.Get("title"),
+		ExerciseName: query.Get("exercise"),
+		Sort:         store.WorkoutSort(query.Get("sort")),
+	}
+
+	if filter.Sort != "" && !filter.Sort.Valid() {
+		return filter, errors.New("Sort must be one of performed_at, -performed_at, created_at, -created_at")
+	}
+
+	var err error
+	filter.From, filter.To, err = readDateRange(query)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.ExerciseID, err = parseIntParam(query.Get("exercise_id"))
+	if err != nil {
+		return filter, errors.New("Invalid exercise_id")
+	}
+
+	filter.MinDuration, err = parseIntParam(query.Get("min_duration"))
+	if err != nil {
+		return filter, errors.New("Invalid min_duration")
+	}
+	filter.MaxDuration, err = parseIntParam(query.Get("max_duration"))
+	if err != nil {
+		return filter, errors.New("Invalid max_duration")
+	}
+
+	filter.Cursor, filter.Limit, err = readWorkoutPage(query)
+	return filter, err
+}
+
+// readWorkoutPage reads the cursor and limit params of a paginated list of
+// workouts
+func readWorkoutPage(query url.Values) (*store.Cursor, int, error) {
+	pageSize := defaultWorkoutPageSize
+	limit, err := parseIntParam(query.Get("limit"))
+	if err != nil || (limit != nil && (*limit < 1 || *limit > maxWorkoutPageSize)) {
+		return nil, 0, errors.New("Limit must be between 1 and 100")
+	}
+	if limit != nil {
+		pageSize = *limit
+	}
+
+	var cursor *store.Cursor
+	if encoded := query.Get("cursor"); encoded != "" {
+		cursor, err = store.DecodeCursor(encoded)
+		if err != nil {
+			return nil, 0, errors.New("Invalid cursor")
+		}
+	}
+
+	return cursor, pageSize, nil
+}
+
+func (wh *WorkoutHandler) HandleListWorkouts(w http.ResponseWriter, r *http.Request) {
+	filter, err := readWorkoutFilter(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
 		return
 	}
 
-	if workout == nil {
-		wh.logger.Printf("[ERROR] GetWorkoutByID - no workout: %v", err)
-		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Workout not found"})
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
+	currentUser := middleware.GetUser(r)
+	filter.UserID = currentUser.ID
+
+	workouts, next, err := wh.workoutStore.ListWorkouts(filter)
+	if err != nil {
+		wh.logger.Printf("[ERROR] ListWorkouts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	writeWorkoutPage(w, workouts, next, unit)
+}
+
+func writeWorkoutPage(w http.ResponseWriter, workouts []*store.Workout, next *store.Cursor, unit store.WeightUnit) {
+	for _, workout := range workouts {
+		workout.ConvertWeights(unit.FromKilograms)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workouts": workouts, "next_cursor": encodeCursor(next)})
+}
+
+// encodeCursor returns what goes in next_cursor: the encoded cursor, or nil
+// on the last page
+func encodeCursor(next *store.Cursor) *string {
+	if next == nil {
+		return nil
+	}
+
+	encoded := next.Encode()
+	return &encoded
+}
+
+func (wh *WorkoutHandler) HandleSearchWorkouts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	search := strings.TrimSpace(query.Get("q"))
+	if search == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Search query q is required"})
+		return
+	}
+
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	limit, offset := defaultWorkoutPageSize, 0
+	value, err := parseIntParam(query.Get("limit"))
+	if err != nil || (value != nil && (*value < 1 || *value > maxWorkoutPageSize)) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Limit must be between 1 and 100"})
+		return
+	}
+	if value != nil {
+		limit = *value
+	}
+
+	value, err = parseIntParam(query.Get("offset"))
+	if err != nil || (value != nil && *value < 0) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Offset must be zero or greater"})
+		return
+	}
+	if value != nil {
+		offset = *value
+	}
+
+	currentUser := middleware.GetUser(r)
+
+	results, err := wh.workoutStore.SearchWorkouts(currentUser.ID, search, limit, offset)
+	if err != nil {
+		wh.logger.Printf("[ERROR] SearchWorkouts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+		return
+	}
+
+	for _, result := range results {
+		result.Workout.ConvertWeights(unit.FromKilograms)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"results": results})
+}
+
+// writeWorkoutError responds with a 400 for store errors caused by an invalid
+// workout and reports whether it did
+func (wh *WorkoutHandler) writeWorkoutError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, store.ErrUnknownExercise):
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Every entry needs a known exercise_id or an exercise_name"})
+	case errors.Is(err, store.ErrInvalidSets):
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Every set needs either reps or duration_seconds, consistently within an entry, a valid set_type and an rpe between 1 and 10"})
+	case errors.Is(err, store.ErrInvalidEnrollment):
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Enrollment not found or already completed"})
+	default:
+		return false
+	}
+	return true
+}
+
+const visibilityError = "Visibility must be private, followers or public"
+
+// maxSetsPerEntry caps how many sets an entry can have, since entries without
+// a set log get one row per set
+const maxSetsPerEntry = 100
+
+func validateWorkoutEntries(entries []store.WorkoutEntry) error {
+	for _, entry := range entries {
+		if len(entry.LoggedSets) > maxSetsPerEntry {
+			return fmt.Errorf("Entries cannot have more than %d logged sets", maxSetsPerEntry)
+		}
+
+		if len(entry.LoggedSets) == 0 && (entry.Sets < 1 || entry.Sets > maxSetsPerEntry) {
+			return fmt.Errorf("Sets must be between 1 and %d", maxSetsPerEntry)
+		}
+	}
+
+	return nil
 }
 
 func (wh *WorkoutHandler) HandleCreateWorkout(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
 	var workout store.Workout
-	err := json.NewDecoder(r.Body).Decode(&workout)
+	err = json.NewDecoder(r.Body).Decode(&workout)
 	if err != nil {
 		wh.logger.Printf("[ERROR] Decoding on HandleCreateWorkout: %v", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Invalid request"})
 		return
 	}
 
+	if workout.Visibility != "" && !workout.Visibility.Valid() {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": visibilityError})
+		return
+	}
+
+	err = validateWorkoutEntries(workout.Entries)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
 	currentUser := middleware.GetUser(r)
 	if currentUser == nil || currentUser == store.AnonymousUser {
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "You must be logged in"})
@@ -63,18 +282,29 @@ func (wh *WorkoutHandler) HandleCreateWorkout(w http.ResponseWriter, r *http.Req
 	}
 
 	workout.UserID = currentUser.ID
+	workout.ConvertWeights(unit.ToKilograms)
 
 	createdWorkotut, err := wh.workoutStore.CreateWorkout(&workout)
+	if wh.writeWorkoutError(w, err) || writeStoreError(w, err, "Workout") {
+		return
+	}
 	if err != nil {
 		wh.logger.Printf("[ERROR] CreateWorkout: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Failed to create workout"})
 		return
 	}
 
+	createdWorkotut.ConvertWeights(unit.FromKilograms)
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"workout": createdWorkotut})
 }
 
 func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http.Request) {
+	unit, err := readUnit(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
 	workoutId, err := utils.ReadIDParam(r)
 	if err != nil {
 		wh.logger.Printf("[ERROR] ReadIDParam %v", err)
@@ -85,18 +315,15 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 	user := middleware.GetUser(r)
 
 	existingWorkout, err := wh.workoutStore.GetWorkoutByID(workoutId, user.ID)
+	if writeStoreError(w, err, "Workout") {
+		return
+	}
 	if err != nil {
 		wh.logger.Printf("[ERROR] GetWorkoutByID: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
 		return
 	}
 
-	if existingWorkout == nil {
-		wh.logger.Printf("[ERROR] GetWorkoutByID - no workout: %v", err)
-		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Workout not found"})
-		return
-	}
-
 	// NOTE: Struct to decode the request into and perform validation before encoding to
 	// the main sturct.
 	// Using pointers as the zero value of poitners is `nil` and we can check against that
@@ -105,6 +332,8 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 		Description     *string              `json:"description"`
 		DurationMinutes *int                 `json:"duration_minutes"`
 		CaloriesBurned  *int                 `json:"calories_burned"`
+		PerformedAt     *time.Time           `json:"performed_at"`
+		Visibility      *store.Visibility    `json:"visibility"`
 		Entries         []store.WorkoutEntry `json:"entries"`
 	}
 
@@ -128,8 +357,24 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 	if updateWorkoutRequest.CaloriesBurned != nil {
 		existingWorkout.CaloriesBurned = *updateWorkoutRequest.CaloriesBurned
 	}
+	if updateWorkoutRequest.PerformedAt != nil {
+		existingWorkout.PerformedAt = *updateWorkoutRequest.PerformedAt
+	}
+	if updateWorkoutRequest.Visibility != nil {
+		if !updateWorkoutRequest.Visibility.Valid() {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": visibilityError})
+			return
+		}
+		existingWorkout.Visibility = *updateWorkoutRequest.Visibility
+	}
 	if updateWorkoutRequest.Entries != nil {
+		err = validateWorkoutEntries(updateWorkoutRequest.Entries)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+			return
+		}
 		existingWorkout.Entries = updateWorkoutRequest.Entries
+		existingWorkout.ConvertWeights(unit.ToKilograms)
 	}
 
 	currentUser := middleware.GetUser(r)
@@ -139,12 +384,11 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 	}
 
 	workoutOwner, err := wh.workoutStore.GetWorkoutOwner(workoutId)
-
+	if writeStoreError(w, err, "Workout") {
+		return
+	}
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Workout does not exist"})
-			return
-		}
+		wh.logger.Printf("[ERROR] GetWorkoutOwner: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
 		return
 	}
@@ -155,12 +399,16 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 	}
 
 	err = wh.workoutStore.UpdateWorkout(existingWorkout)
+	if wh.writeWorkoutError(w, err) || writeStoreError(w, err, "Workout") {
+		return
+	}
 	if err != nil {
 		wh.logger.Printf("[ERROR] UpdateWorkout: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
 		return
 	}
 
+	existingWorkout.ConvertWeights(unit.FromKilograms)
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": existingWorkout})
 }
 
@@ -179,12 +427,11 @@ func (wh *WorkoutHandler) HandleDeleteWorkoutByID(w http.ResponseWriter, r *http
 	}
 
 	workoutOwner, err := wh.workoutStore.GetWorkoutOwner(workoutId)
-
+	if writeStoreError(w, err, "Workout") {
+		return
+	}
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Workout does not exist"})
-			return
-		}
+		wh.logger.Printf("[ERROR] GetWorkoutOwner: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
 		return
 	}
@@ -195,9 +442,7 @@ func (wh *WorkoutHandler) HandleDeleteWorkoutByID(w http.ResponseWriter, r *http
 	}
 
 	err = wh.workoutStore.DeleteWorkout(workoutId)
-	if err == sql.ErrNoRows {
-		wh.logger.Printf("[ERROR] DeleteWorkout NoRows %v", err)
-		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "Workout not found"})
+	if writeStoreError(w, err, "Workout") {
 		return
 	}
 	if err != nil {
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingWorkoutStore only records what reaches it, so tests can check
+// invalid requests are turned away before the store runs
+type recordingWorkoutStore struct {
+	store.WorkoutStore
+	created []*store.Workout
+}
+
+func (s *recordingWorkoutStore) CreateWorkout(workout *store.Workout) (*store.Workout, error) {
+	s.created = append(s.created, workout)
+	workout.ID = len(s.created)
+	return workout, nil
+}
+
+func TestHandleCreateWorkoutValidatesSets(t *testing.T) {
+	user := &store.User{ID: 1, Username: "gonzalo", Activated: true}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "Negative sets",
+			body:       `{"title": "Legs", "entries": [{"exercise_name": "Squat", "sets": -1, "reps": 5, "order_index": 1}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Oversized sets",
+			body:       `{"title": "Legs", "entries": [{"exercise_name": "Squat", "sets": 2000000000, "reps": 5, "order_index": 1}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Valid sets",
+			body:       `{"title": "Legs", "entries": [{"exercise_name": "Squat", "sets": 5, "reps": 5, "order_index": 1}]}`,
+			wantStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workoutStore := &recordingWorkoutStore{}
+			handler := NewWorkoutHandler(workoutStore, log.New(io.Discard, "", 0))
+			rec := httptest.NewRecorder()
+
+			handler.HandleCreateWorkout(rec, requestAs(user, http.MethodPost, "/workouts", tt.body))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusCreated {
+				assert.Empty(t, workoutStore.created)
+			}
+		})
+	}
+}
+
+// sharedWeightWorkoutStore expands entries without a set log into sets that
+// all point at the entry's weight, so tests can check a shared weight is
+// only converted once
+type sharedWeightWorkoutStore struct {
+	store.WorkoutStore
+}
+
+func (s *sharedWeightWorkoutStore) CreateWorkout(workout *store.Workout) (*store.Workout, error) {
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		for n := range entry.Sets {
+			entry.LoggedSets = append(entry.LoggedSets, store.WorkoutSet{SetNumber: n + 1, Reps: entry.Reps, Weight: entry.Weight})
+		}
+	}
+	return workout, nil
+}
+
+func TestHandleCreateWorkoutInPounds(t *testing.T) {
+	user := &store.User{ID: 1, Username: "gonzalo", Activated: true, PreferredUnit: store.UnitPounds}
+	handler := NewWorkoutHandler(&sharedWeightWorkoutStore{}, log.New(io.Discard, "", 0))
+	rec := httptest.NewRecorder()
+
+	body := `{"title": "Legs", "entries": [{"exercise_name": "Squat", "sets": 3, "reps": 5, "weight": 100, "order_index": 1}]}`
+	handler.HandleCreateWorkout(rec, requestAs(user, http.MethodPost, "/workouts", body))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var response struct {
+		Workout store.Workout `json:"workout"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	require.Len(t, response.Workout.Entries, 1)
+
+	entry := response.Workout.Entries[0]
+	assert.Equal(t, 100.0, *entry.Weight)
+	require.Len(t, entry.LoggedSets, 3)
+	for _, set := range entry.LoggedSets {
+		assert.Equal(t, 100.0, *set.Weight)
+	}
+}
@@ -1,24 +1,49 @@
 package app
 
 import (
+	"crypto/ed25519"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/gonstoll/workouts/internal/analytics"
 	"github.com/gonstoll/workouts/internal/api"
+	"github.com/gonstoll/workouts/internal/mailer"
 	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/oidc"
 	"github.com/gonstoll/workouts/internal/store"
+	"github.com/gonstoll/workouts/internal/tokens"
 	"github.com/gonstoll/workouts/migrations"
 )
 
 type Application struct {
-	Logger         *log.Logger
-	WorkoutHandler *api.WorkoutHandler
-	UserHandler    *api.UserHandler
-	TokenHandler   *api.TokenHandler
-	Middleware     middleware.UserMiddleware
+	Logger           *log.Logger
+	WorkoutHandler   *api.WorkoutHandler
+	UserHandler      *api.UserHandler
+	TokenHandler     *api.TokenHandler
+	ExerciseHandler  *api.ExerciseHandler
+	RecordHandler    *api.RecordHandler
+	StatsHandler     *api.StatsHandler
+	TemplateHandler  *api.TemplateHandler
+	ProgramHandler   *api.ProgramHandler
+	APIKeyHandler    *api.APIKeyHandler
+	AdminHandler     *api.AdminHandler
+	TwoFactorHandler *api.TwoFactorHandler
+	JWKSHandler      *api.JWKSHandler
+	OIDCHandler      *api.OIDCHandler
+	AccountHandler   *api.AccountHandler
+	SocialHandler    *api.SocialHandler
+	ShareLinkHandler *api.ShareLinkHandler
+	Middleware       middleware.UserMiddleware
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For is believed
+	TrustedProxies []netip.Prefix
 	DB             *sql.DB
 }
 
@@ -35,30 +60,226 @@ func NewApplication() (*Application, error) {
 
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 
+	secretBox, err := newSecretBox()
+	if err != nil {
+		return nil, err
+	}
+
 	// Stores
 	workoutStore := store.NewPostgresWorkoutStore(pgDB)
 	userStore := store.NewPostgresUserStore(pgDB)
 	tokenStore := store.NewPostgresTokenStore(pgDB)
+	exerciseStore := store.NewPostgresExerciseStore(pgDB)
+	recordStore := store.NewPostgresRecordStore(pgDB)
+	statsStore := analytics.NewPostgresStatsStore(pgDB)
+	templateStore := store.NewPostgresTemplateStore(pgDB)
+	programStore := store.NewPostgresProgramStore(pgDB)
+	apiKeyStore := store.NewPostgresAPIKeyStore(pgDB)
+	loginAttemptStore := store.NewPostgresLoginAttemptStore(pgDB)
+	twoFactorStore := store.NewPostgresTwoFactorStore(pgDB, secretBox)
+	identityStore := store.NewPostgresIdentityStore(pgDB)
+	followStore := store.NewPostgresFollowStore(pgDB)
+
+	sealed, err := twoFactorStore.SealPlaintextSecrets()
+	if err != nil {
+		return nil, fmt.Errorf("sealing TOTP secrets: %w", err)
+	}
+	if sealed > 0 {
+		logger.Printf("Sealed %d TOTP secrets stored in plaintext", sealed)
+	}
+
+	signer, err := newSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := newTrustedProxies()
+	if err != nil {
+		return nil, err
+	}
 
 	// Handlers
 	workoutHander := api.NewWorkoutHandler(workoutStore, logger)
-	userHandler := api.NewUserHandler(userStore, logger)
-	tokenHandler := api.NewTokenHandler(tokenStore, userStore, logger)
+	userHandler := api.NewUserHandler(userStore, tokenStore, loginAttemptStore, newMailer(), logger)
+	tokenHandler := api.NewTokenHandler(tokenStore, userStore, twoFactorStore, loginAttemptStore, signer, logger)
+	exerciseHandler := api.NewExerciseHandler(exerciseStore, logger)
+	recordHandler := api.NewRecordHandler(recordStore, logger)
+	statsHandler := api.NewStatsHandler(statsStore, logger)
+	templateHandler := api.NewTemplateHandler(templateStore, workoutStore, logger)
+	programHandler := api.NewProgramHandler(programStore, templateStore, workoutStore, recordStore, logger)
+	apiKeyHandler := api.NewAPIKeyHandler(apiKeyStore, logger)
+	adminHandler := api.NewAdminHandler(userStore, tokenStore, workoutStore, logger)
+	twoFactorHandler := api.NewTwoFactorHandler(twoFactorStore, logger)
+	jwksHandler := api.NewJWKSHandler(signer)
+	oidcHandler := api.NewOIDCHandler(newOIDCProviders(), identityStore, userStore, tokenStore, twoFactorStore, signer, logger)
+	accountHandler := api.NewAccountHandler(userStore, workoutStore, tokenStore, logger)
+	socialHandler := api.NewSocialHandler(followStore, workoutStore, logger)
+	shareLinkHandler := api.NewShareLinkHandler(workoutStore, logger)
 
-	middlewareHandler := middleware.UserMiddleware{UserStore: userStore}
+	middlewareHandler := middleware.UserMiddleware{UserStore: userStore, APIKeyStore: apiKeyStore, Signer: signer}
 
 	app := &Application{
-		Logger:         logger,
-		WorkoutHandler: workoutHander,
-		UserHandler:    userHandler,
-		TokenHandler:   tokenHandler,
-		Middleware:     middlewareHandler,
-		DB:             pgDB,
+		Logger:           logger,
+		WorkoutHandler:   workoutHander,
+		UserHandler:      userHandler,
+		TokenHandler:     tokenHandler,
+		ExerciseHandler:  exerciseHandler,
+		RecordHandler:    recordHandler,
+		StatsHandler:     statsHandler,
+		TemplateHandler:  templateHandler,
+		ProgramHandler:   programHandler,
+		APIKeyHandler:    apiKeyHandler,
+		AdminHandler:     adminHandler,
+		TwoFactorHandler: twoFactorHandler,
+		JWKSHandler:      jwksHandler,
+		OIDCHandler:      oidcHandler,
+		AccountHandler:   accountHandler,
+		SocialHandler:    socialHandler,
+		ShareLinkHandler: shareLinkHandler,
+		Middleware:       middlewareHandler,
+		TrustedProxies:   trustedProxies,
+		DB:               pgDB,
 	}
 
 	return app, nil
 }
 
+// newMailer sends through SMTP when SMTP_HOST is set and writes emails to
+// stdout otherwise
+func newMailer() mailer.Mailer {
+	sender := os.Getenv("MAIL_SENDER")
+	if sender == "" {
+		sender = "Workouts <no-reply@workouts.local>"
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return mailer.NewLogMailer(os.Stdout, sender)
+	}
+
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		port = 587
+	}
+
+	return mailer.NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), sender)
+}
+
+// newSigner returns the signer for JWT access tokens when ACCESS_TOKEN_FORMAT is
+// jwt, and nil to keep access tokens opaque. JWT_SIGNING_KEYS lists kid:seed
+// pairs, with each seed a base64 Ed25519 seed. The first key signs and the
+// rest are only accepted, which is how keys are rotated.
+func newSigner() (*tokens.Signer, error) {
+	if os.Getenv("ACCESS_TOKEN_FORMAT") != "jwt" {
+		return nil, nil
+	}
+
+	keys := []tokens.SigningKey{}
+	for _, pair := range strings.Split(os.Getenv("JWT_SIGNING_KEYS"), ",") {
+		kid, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("JWT_SIGNING_KEYS: expected kid:seed, got %q", pair)
+		}
+
+		seed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("JWT_SIGNING_KEYS: key %q is not a base64 Ed25519 seed", kid)
+		}
+
+		keys = append(keys, tokens.SigningKey{ID: kid, PrivateKey: ed25519.NewKeyFromSeed(seed)})
+	}
+
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "workouts"
+	}
+
+	return tokens.NewSigner(issuer, keys...)
+}
+
+// newSecretBox reads the keys TOTP secrets are sealed with. TOTP_SECRET_KEYS
+// lists kid:key pairs, with each key 32 random bytes in base64, e.g. from
+// openssl rand -base64 32. The first key seals and the rest only open, which is
+// how keys are rotated.
+func newSecretBox() (*store.SecretBox, error) {
+	value := os.Getenv("TOTP_SECRET_KEYS")
+	if value == "" {
+		return nil, errors.New("TOTP_SECRET_KEYS is required")
+	}
+
+	keys := []store.SecretKey{}
+	for _, pair := range strings.Split(value, ",") {
+		kid, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("TOTP_SECRET_KEYS: expected kid:key, got %q", pair)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("TOTP_SECRET_KEYS: key %q is not 32 bytes of base64", kid)
+		}
+
+		keys = append(keys, store.SecretKey{ID: kid, Key: key})
+	}
+
+	return store.NewSecretBox(keys...)
+}
+
+// newTrustedProxies reads TRUSTED_PROXIES, the comma separated addresses or
+// CIDR ranges of the reverse proxies in front of the app. Client IPs are only
+// taken from X-Forwarded-For for requests coming from them.
+func newTrustedProxies() ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, value := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an address or CIDR range", value)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an address or CIDR range", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// newOIDCProviders reads the identity providers to offer from the environment.
+// OIDC_PROVIDERS lists their names, and each one is configured with
+// OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and _REDIRECT_URL.
+func newOIDCProviders() []*oidc.Provider {
+	providers := []*oidc.Provider{}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}, nil))
+	}
+
+	return providers
+}
+
 func (a *Application) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "Status is available\n")
 }
@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailer delivers plain text emails to a single recipient
+type Mailer interface {
+	Send(recipient, subject, body string) error
+}
+
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+func NewSMTPMailer(host string, port int, username, password, sender string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr:   net.JoinHostPort(host, strconv.Itoa(port)),
+		auth:   auth,
+		sender: sender,
+	}
+}
+
+func (m *SMTPMailer) Send(recipient, subject, body string) error {
+	msg := buildMessage(m.sender, recipient, subject, body)
+	return smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, msg)
+}
+
+// LogMailer writes every email to w instead of sending it. Point it at a file
+// or stdout for local development and tests.
+type LogMailer struct {
+	logger *log.Logger
+	sender string
+}
+
+func NewLogMailer(w io.Writer, sender string) *LogMailer {
+	return &LogMailer{
+		logger: log.New(w, "", log.Ldate|log.Ltime),
+		sender: sender,
+	}
+}
+
+func (m *LogMailer) Send(recipient, subject, body string) error {
+	m.logger.Printf("[MAIL]\n%s", buildMessage(m.sender, recipient, subject, body))
+	return nil
+}
+
+func buildMessage(sender, recipient, subject, body string) []byte {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", sender)
+	fmt.Fprintf(&msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return []byte(msg.String())
+}
@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf, "no-reply@example.com")
+
+	err := m.Send("gonzalo@example.com", "Reset your password", "Your token:\nABC123")
+	require.NoError(t, err)
+
+	assert.Contains(t, buf.String(), "To: gonzalo@example.com\r\n")
+	assert.Contains(t, buf.String(), "Subject: Reset your password\r\n")
+	assert.Contains(t, buf.String(), "Your token:\r\nABC123")
+}
+
+// fakeSMTPServer accepts a single message and sends its DATA section back on
+// the returned channel
+func fakeSMTPServer(t *testing.T) (string, int, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ready")
+
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+
+			switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				inData = true
+				reply("354 go ahead")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return host, portNumber, received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	host, port, received := fakeSMTPServer(t)
+	m := NewSMTPMailer(host, port, "", "", "no-reply@example.com")
+
+	err := m.Send("gonzalo@example.com", "Reset your password", "Your token: ABC123")
+	require.NoError(t, err)
+
+	msg := <-received
+	assert.Contains(t, msg, "From: no-reply@example.com\r\n")
+	assert.Contains(t, msg, "Your token: ABC123")
+}
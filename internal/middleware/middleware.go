@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -11,12 +12,20 @@ import (
 )
 
 type UserMiddleware struct {
-	UserStore store.UserStore
+	UserStore   store.UserStore
+	APIKeyStore store.APIKeyStore
+	// Signer verifies signed access tokens. It's nil unless they're enabled.
+	Signer *tokens.Signer
 }
 
 type contextKey string
 
-const UserContextKey = contextKey("user")
+const (
+	UserContextKey   = contextKey("user")
+	TokenContextKey  = contextKey("token")
+	APIKeyContextKey = contextKey("api_key")
+	ClaimsContextKey = contextKey("claims")
+)
 
 func SetUser(r *http.Request, user *store.User) *http.Request {
 	ctx := context.WithValue(r.Context(), UserContextKey, user)
@@ -31,6 +40,27 @@ func GetUser(r *http.Request) *store.User {
 	return user
 }
 
+// GetToken returns the bearer token the request was authenticated with, or an
+// empty string for anonymous requests
+func GetToken(r *http.Request) string {
+	token, _ := r.Context().Value(TokenContextKey).(string)
+	return token
+}
+
+// GetAPIKey returns the API key the request was authenticated with, or nil when
+// it came with a session token
+func GetAPIKey(r *http.Request) *store.APIKey {
+	apiKey, _ := r.Context().Value(APIKeyContextKey).(*store.APIKey)
+	return apiKey
+}
+
+// GetAccessClaims returns the claims of the signed access token the request was
+// authenticated with, or nil for any other kind of credential
+func GetAccessClaims(r *http.Request) *tokens.AccessClaims {
+	claims, _ := r.Context().Value(ClaimsContextKey).(*tokens.AccessClaims)
+	return claims
+}
+
 func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -49,23 +79,89 @@ func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 		}
 
 		token := headerParts[1]
+		if strings.HasPrefix(token, tokens.APIKeyPrefix) {
+			um.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		if um.Signer != nil && tokens.IsJWT(token) {
+			um.authenticateJWT(w, r, next, token)
+			return
+		}
+
 		user, err := um.UserStore.GetUserToken(tokens.ScopeAuth, token)
+		if errors.Is(err, store.ErrNotFound) {
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Token expired or invalid"})
+			return
+		}
 		if err != nil {
 			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Invalid token"})
 			return
 		}
 
-		if user == nil {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Token expired or invalid"})
+		if user.IsDisabled() {
+			writeDisabled(w)
 			return
 		}
 
 		r = SetUser(r, user)
+		r = r.WithContext(context.WithValue(r.Context(), TokenContextKey, token))
 		next.ServeHTTP(w, r)
 		return
 	})
 }
 
+func (um *UserMiddleware) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	user, apiKey, err := um.APIKeyStore.GetUserByAPIKey(key)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "API key expired or invalid"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Invalid API key"})
+		return
+	}
+
+	if user.IsDisabled() {
+		writeDisabled(w)
+		return
+	}
+
+	r = SetUser(r, user)
+	r = r.WithContext(context.WithValue(r.Context(), APIKeyContextKey, apiKey))
+	next.ServeHTTP(w, r)
+}
+
+// authenticateJWT trusts the token's claims without a database round trip. The
+// user it sets only has the fields the claims carry, RequireSession loads the
+// rest for routes that need it. That also means a user disabled after the token
+// was signed keeps access until it expires, which is why those tokens are
+// short-lived.
+func (um *UserMiddleware) authenticateJWT(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	claims, err := um.Signer.Verify(token)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Token expired or invalid"})
+		return
+	}
+
+	user := &store.User{
+		ID:            claims.UserID,
+		Username:      claims.Username,
+		Role:          store.Role(claims.Role),
+		Activated:     claims.Activated,
+		PreferredUnit: store.WeightUnit(claims.Unit),
+	}
+
+	r = SetUser(r, user)
+	ctx := context.WithValue(r.Context(), TokenContextKey, token)
+	ctx = context.WithValue(ctx, ClaimsContextKey, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func writeDisabled(w http.ResponseWriter) {
+	utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "This account has been disabled"})
+}
+
 func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := GetUser(r)
@@ -78,3 +174,79 @@ func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireActivatedUser is RequireUser for routes that also need a verified
+// email address
+func (um *UserMiddleware) RequireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+
+		if !user.Activated {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "Your account must be activated to access this route"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission rejects requests made with an API key that wasn't granted
+// permission. Session tokens can do anything their user can, so they pass.
+func (um *UserMiddleware) RequirePermission(permission store.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := GetAPIKey(r)
+
+			if apiKey != nil && !apiKey.Can(permission) {
+				utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "This API key is missing the " + string(permission) + " permission"})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession is RequireUser for routes API keys must never reach, such as
+// managing the keys themselves. These routes work on the account itself, so a
+// user from a signed token is swapped for the full, current record.
+func (um *UserMiddleware) RequireSession(next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		if GetAPIKey(r) != nil {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "API keys cannot access this route"})
+			return
+		}
+
+		if GetAccessClaims(r) != nil {
+			user, err := um.UserStore.GetUserByID(int64(GetUser(r).ID))
+			if errors.Is(err, store.ErrNotFound) {
+				utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "Token expired or invalid"})
+				return
+			}
+			if err != nil {
+				utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Internal server error"})
+				return
+			}
+			if user.IsDisabled() {
+				writeDisabled(w)
+				return
+			}
+			r = SetUser(r, user)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (um *UserMiddleware) RequireRole(role store.Role, next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+
+		if user.Role != role {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "You are not allowed to access this route"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
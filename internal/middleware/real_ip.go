@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// RealIP sets RemoteAddr to the client's address from X-Forwarded-For when the
+// request comes from one of the trusted proxies, so throttling by client IP
+// works behind a reverse proxy. Every proxy appends the address it got the
+// request from, so the client is the last entry that isn't a trusted proxy.
+// Requests from anywhere else keep their RemoteAddr, since a client can put
+// anything in the header.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		return slices.ContainsFunc(trusted, func(prefix netip.Prefix) bool {
+			return prefix.Contains(addr)
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, err := netip.ParseAddrPort(r.RemoteAddr)
+			if err != nil || !isTrusted(peer.Addr().Unmap()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := peer.Addr().Unmap()
+			forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(forwarded) - 1; i >= 0; i-- {
+				addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+				if err != nil {
+					break
+				}
+				client = addr.Unmap()
+				if !isTrusted(client) {
+					break
+				}
+			}
+
+			r.RemoteAddr = netip.AddrPortFrom(client, peer.Port()).String()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct client keeps its address", "203.0.113.7:4000", nil, "203.0.113.7:4000"},
+		{"untrusted peer can't spoof the header", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7:4000"},
+		{"trusted proxy forwards the client", "10.0.0.2:4000", []string{"198.51.100.1"}, "198.51.100.1:4000"},
+		{"proxies in the chain are skipped", "10.0.0.2:4000", []string{"198.51.100.1, 10.0.0.3"}, "198.51.100.1:4000"},
+		{"spoofed entries left of the client are ignored", "10.0.0.2:4000", []string{"192.0.2.9, 198.51.100.1"}, "198.51.100.1:4000"},
+		{"repeated headers are one list", "10.0.0.2:4000", []string{"192.0.2.9", "198.51.100.1"}, "198.51.100.1:4000"},
+		{"garbage stops the walk", "10.0.0.2:4000", []string{"198.51.100.1, nonsense"}, "10.0.0.2:4000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			var got string
+			RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
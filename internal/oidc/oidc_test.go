@@ -0,0 +1,82 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/oidc"
+	"github.com/gonstoll/workouts/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func login(t *testing.T, idp *oidctest.Server, provider *oidc.Provider) (*oidc.Claims, error) {
+	t.Helper()
+
+	state, err := oidc.NewLoginState()
+	require.NoError(t, err)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), state)
+	require.NoError(t, err)
+
+	code, returnedState, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+	require.Equal(t, state.State, returnedState)
+
+	return provider.Exchange(context.Background(), code, state)
+}
+
+func TestExchange(t *testing.T) {
+	idp := oidctest.NewServer("workouts", "secret")
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "abc123", Email: "gonzalo@example.com", EmailVerified: true})
+
+	provider := oidc.NewProvider(idp.Config("test", "http://localhost/callback"), nil)
+
+	claims, err := login(t, idp, provider)
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", claims.Subject)
+	assert.Equal(t, "gonzalo@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+}
+
+func TestExchangeRejectsBadTokens(t *testing.T) {
+	idp := oidctest.NewServer("workouts", "secret")
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "abc123"})
+
+	provider := oidc.NewProvider(idp.Config("test", "http://localhost/callback"), nil)
+
+	idp.Audience = "someone-else"
+	_, err := login(t, idp, provider)
+	assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken), err)
+	idp.Audience = ""
+
+	// Signed by a key that isn't in the JWKS
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp.Key = key
+	_, err = login(t, idp, provider)
+	assert.True(t, errors.Is(err, oidc.ErrInvalidIDToken), err)
+}
+
+func TestExchangeRequiresVerifier(t *testing.T) {
+	idp := oidctest.NewServer("workouts", "secret")
+	defer idp.Close()
+
+	provider := oidc.NewProvider(idp.Config("test", "http://localhost/callback"), nil)
+
+	state, err := oidc.NewLoginState()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(context.Background(), state)
+	require.NoError(t, err)
+	code, _, err := idp.Authorize(authURL)
+	require.NoError(t, err)
+
+	state.Verifier = "not-the-verifier"
+	_, err = provider.Exchange(context.Background(), code, state)
+	assert.Error(t, err)
+}
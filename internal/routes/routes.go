@@ -1,31 +1,142 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/gonstoll/workouts/internal/app"
+	"github.com/gonstoll/workouts/internal/middleware"
+	"github.com/gonstoll/workouts/internal/store"
 )
 
 func SetupRoutes(app *app.Application) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(middleware.RealIP(app.TrustedProxies))
+
+	// can gates a route on an API key permission. Session tokens pass.
+	can := app.Middleware.RequirePermission
+
+	// admin routes are only reachable by admins signed in with a session token
+	admin := func(next http.HandlerFunc) http.HandlerFunc {
+		return app.Middleware.RequireSession(app.Middleware.RequireRole(store.RoleAdmin, next))
+	}
 
 	r.Group(func(r chi.Router) {
 		r.Use(app.Middleware.Authenticate)
 
 		// Workouts
-		r.Get("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleGetWorkoutByID))
-		r.Post("/workouts", app.Middleware.RequireUser(app.WorkoutHandler.HandleCreateWorkout))
-		r.Put("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleUpdateWorkoutByID))
-		r.Delete("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleDeleteWorkoutByID))
+		r.With(can(store.PermissionWorkoutsRead)).Get("/workouts", app.Middleware.RequireUser(app.WorkoutHandler.HandleListWorkouts))
+		r.With(can(store.PermissionWorkoutsRead)).Get("/workouts/search", app.Middleware.RequireUser(app.WorkoutHandler.HandleSearchWorkouts))
+		r.With(can(store.PermissionWorkoutsRead)).Get("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleGetWorkoutByID))
+		r.With(can(store.PermissionWorkoutsWrite)).Post("/workouts", app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleCreateWorkout))
+		r.With(can(store.PermissionWorkoutsWrite)).Put("/workouts/{id}", app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleUpdateWorkoutByID))
+		r.With(can(store.PermissionWorkoutsWrite)).Delete("/workouts/{id}", app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleDeleteWorkoutByID))
+
+		// Share links
+		r.With(can(store.PermissionWorkoutsRead)).Get("/workouts/{id}/share-links", app.Middleware.RequireUser(app.ShareLinkHandler.HandleListShareLinks))
+		r.With(can(store.PermissionWorkoutsWrite)).Post("/workouts/{id}/share-links", app.Middleware.RequireActivatedUser(app.ShareLinkHandler.HandleCreateShareLink))
+		r.With(can(store.PermissionWorkoutsWrite)).Delete("/share-links/{id}", app.Middleware.RequireActivatedUser(app.ShareLinkHandler.HandleDeleteShareLink))
+
+		// Exercises
+		r.With(can(store.PermissionExercisesRead)).Get("/exercises", app.Middleware.RequireUser(app.ExerciseHandler.HandleListExercises))
+		r.With(can(store.PermissionExercisesRead)).Get("/exercises/{id}", app.Middleware.RequireUser(app.ExerciseHandler.HandleGetExerciseByID))
+		r.With(can(store.PermissionExercisesWrite)).Post("/exercises", app.Middleware.RequireActivatedUser(app.ExerciseHandler.HandleCreateExercise))
+		r.With(can(store.PermissionExercisesWrite)).Put("/exercises/{id}", app.Middleware.RequireActivatedUser(app.ExerciseHandler.HandleUpdateExerciseByID))
+		r.With(can(store.PermissionExercisesWrite)).Delete("/exercises/{id}", app.Middleware.RequireActivatedUser(app.ExerciseHandler.HandleDeleteExerciseByID))
+
+		// Templates
+		r.With(can(store.PermissionTemplatesRead)).Get("/templates", app.Middleware.RequireUser(app.TemplateHandler.HandleListTemplates))
+		r.With(can(store.PermissionTemplatesRead)).Get("/templates/{id}", app.Middleware.RequireUser(app.TemplateHandler.HandleGetTemplateByID))
+		r.With(can(store.PermissionTemplatesWrite)).Post("/templates", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleCreateTemplate))
+		r.With(can(store.PermissionTemplatesWrite)).Put("/templates/{id}", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleUpdateTemplateByID))
+		r.With(can(store.PermissionTemplatesWrite)).Delete("/templates/{id}", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleDeleteTemplateByID))
+		r.With(can(store.PermissionWorkoutsWrite)).Post("/templates/{id}/start", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleStartWorkout))
+
+		// Programs
+		r.With(can(store.PermissionProgramsRead)).Get("/programs", app.Middleware.RequireUser(app.ProgramHandler.HandleListPrograms))
+		r.With(can(store.PermissionProgramsRead)).Get("/programs/{id}", app.Middleware.RequireUser(app.ProgramHandler.HandleGetProgramByID))
+		r.With(can(store.PermissionProgramsWrite)).Post("/programs", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleCreateProgram))
+		r.With(can(store.PermissionProgramsWrite)).Put("/programs/{id}", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleUpdateProgramByID))
+		r.With(can(store.PermissionProgramsWrite)).Delete("/programs/{id}", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleDeleteProgramByID))
+		r.With(can(store.PermissionProgramsWrite)).Post("/programs/{id}/enroll", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleEnroll))
+		r.With(can(store.PermissionProgramsRead)).Get("/enrollments", app.Middleware.RequireUser(app.ProgramHandler.HandleListEnrollments))
+		r.With(can(store.PermissionProgramsRead)).Get("/enrollments/{id}/today", app.Middleware.RequireUser(app.ProgramHandler.HandleGetToday))
+		r.With(can(store.PermissionProgramsWrite)).Delete("/enrollments/{id}", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleDeleteEnrollment))
+
+		// Users
+		r.Get("/users/me", app.Middleware.RequireSession(app.UserHandler.HandleGetCurrentUser))
+		r.Patch("/users/me", app.Middleware.RequireSession(app.UserHandler.HandleUpdateCurrentUser))
+		r.Put("/users/me/password", app.Middleware.RequireSession(app.UserHandler.HandleChangePassword))
+		r.Put("/users/me/preferences", app.Middleware.RequireSession(app.UserHandler.HandleUpdatePreferences))
+		r.Get("/users/me/export", app.Middleware.RequireSession(app.AccountHandler.HandleExportData))
+		r.Delete("/users/me", app.Middleware.RequireSession(app.AccountHandler.HandleDeleteAccount))
+
+		// Profiles and follows. Profiles are public, anonymous visitors see
+		// public workouts only. Follows are requests until the followee
+		// accepts them, and followees can remove followers.
+		r.With(can(store.PermissionWorkoutsRead)).Get("/users/{username}", app.SocialHandler.HandleGetProfile)
+		r.Post("/users/{username}/follow", app.Middleware.RequireSession(app.Middleware.RequireActivatedUser(app.SocialHandler.HandleFollow)))
+		r.Delete("/users/{username}/follow", app.Middleware.RequireSession(app.Middleware.RequireActivatedUser(app.SocialHandler.HandleUnfollow)))
+		r.Get("/users/me/follow-requests", app.Middleware.RequireSession(app.SocialHandler.HandleListFollowRequests))
+		r.Put("/users/me/followers/{username}", app.Middleware.RequireSession(app.SocialHandler.HandleAcceptFollower))
+		r.Delete("/users/me/followers/{username}", app.Middleware.RequireSession(app.SocialHandler.HandleRemoveFollower))
+		r.With(can(store.PermissionWorkoutsRead)).Get("/feed", app.Middleware.RequireUser(app.SocialHandler.HandleGetFeed))
+
+		// Two-factor authentication
+		r.Post("/users/me/2fa", app.Middleware.RequireSession(app.TwoFactorHandler.HandleEnrollTOTP))
+		r.Post("/users/me/2fa/confirm", app.Middleware.RequireSession(app.TwoFactorHandler.HandleConfirmTOTP))
+		r.Post("/users/me/2fa/recovery-codes", app.Middleware.RequireSession(app.TwoFactorHandler.HandleRegenerateRecoveryCodes))
+		r.Delete("/users/me/2fa", app.Middleware.RequireSession(app.TwoFactorHandler.HandleDisableTOTP))
+
+		// Tokens
+		r.Delete("/token/authentication", app.Middleware.RequireSession(app.TokenHandler.HandleDeleteToken))
+		r.Get("/tokens", app.Middleware.RequireSession(app.TokenHandler.HandleListTokens))
+		r.Delete("/tokens", app.Middleware.RequireSession(app.TokenHandler.HandleDeleteAllTokens))
+		r.Delete("/tokens/{id}", app.Middleware.RequireSession(app.TokenHandler.HandleDeleteTokenByID))
+
+		// API keys
+		r.Get("/api-keys", app.Middleware.RequireSession(app.APIKeyHandler.HandleListAPIKeys))
+		r.Post("/api-keys", app.Middleware.RequireSession(app.APIKeyHandler.HandleCreateAPIKey))
+		r.Delete("/api-keys/{id}", app.Middleware.RequireSession(app.APIKeyHandler.HandleDeleteAPIKey))
+
+		// Admin
+		r.Get("/admin/users", admin(app.AdminHandler.HandleListUsers))
+		r.Get("/admin/users/{id}", admin(app.AdminHandler.HandleGetUser))
+		r.Patch("/admin/users/{id}", admin(app.AdminHandler.HandleUpdateUser))
+		r.Delete("/admin/users/{id}/tokens", admin(app.AdminHandler.HandleRevokeUserTokens))
+		r.Get("/admin/users/{id}/workouts", admin(app.AdminHandler.HandleListUserWorkouts))
+
+		// Personal records
+		r.With(can(store.PermissionRecordsRead)).Get("/users/me/records", app.Middleware.RequireUser(app.RecordHandler.HandleListRecords))
+
+		// Stats
+		r.With(can(store.PermissionStatsRead)).Get("/stats/volume", app.Middleware.RequireUser(app.StatsHandler.HandleGetVolume))
+		r.With(can(store.PermissionStatsRead)).Get("/stats/frequency", app.Middleware.RequireUser(app.StatsHandler.HandleGetFrequency))
+		r.With(can(store.PermissionStatsRead)).Get("/stats/summary", app.Middleware.RequireUser(app.StatsHandler.HandleGetSummary))
 	})
 
 	// Health
 	r.Get("/health", app.HealthCheck)
+	r.Get("/.well-known/jwks.json", app.JWKSHandler.HandleGetJWKS)
+
+	// Shared workouts, the link's token is all it takes
+	r.Get("/shared/{token}", app.ShareLinkHandler.HandleGetSharedWorkout)
 
 	// Users
 	r.Post("/users", app.UserHandler.HandleRegisterUser)
+	r.Put("/users/activate", app.UserHandler.HandleActivateUser)
+	r.Post("/users/activate/resend", app.UserHandler.HandleResendActivation)
+	r.Post("/password-reset", app.UserHandler.HandleRequestPasswordReset)
+	r.Put("/password-reset", app.UserHandler.HandleResetPassword)
 
 	// Tokens
 	r.Post("/token/authentication", app.TokenHandler.HandleCreateToken)
+	r.Post("/token/refresh", app.TokenHandler.HandleRefreshToken)
+	r.Post("/token/2fa", app.TokenHandler.HandleVerifyTwoFactor)
+
+	// Single sign-on
+	r.Get("/auth/oidc/{provider}", app.OIDCHandler.HandleStartLogin)
+	r.Get("/auth/oidc/{provider}/callback", app.OIDCHandler.HandleCallback)
 
 	return r
 }
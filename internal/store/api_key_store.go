@@ -0,0 +1,241 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/jackc/pgtype"
+)
+
+type Permission string
+
+const (
+	PermissionWorkoutsRead   Permission = "workouts:read"
+	PermissionWorkoutsWrite  Permission = "workouts:write"
+	PermissionExercisesRead  Permission = "exercises:read"
+	PermissionExercisesWrite Permission = "exercises:write"
+	PermissionTemplatesRead  Permission = "templates:read"
+	PermissionTemplatesWrite Permission = "templates:write"
+	PermissionProgramsRead   Permission = "programs:read"
+	PermissionProgramsWrite  Permission = "programs:write"
+	PermissionRecordsRead    Permission = "records:read"
+	PermissionStatsRead      Permission = "stats:read"
+)
+
+var Permissions = []Permission{
+	PermissionWorkoutsRead,
+	PermissionWorkoutsWrite,
+	PermissionExercisesRead,
+	PermissionExercisesWrite,
+	PermissionTemplatesRead,
+	PermissionTemplatesWrite,
+	PermissionProgramsRead,
+	PermissionProgramsWrite,
+	PermissionRecordsRead,
+	PermissionStatsRead,
+}
+
+func (p Permission) Valid() bool {
+	for _, permission := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a long-lived credential for scripts. Only its hash is stored; Key
+// is filled in once, when the key is created.
+type APIKey struct {
+	ID          int          `json:"id"`
+	UserID      int          `json:"-"`
+	Name        string       `json:"name"`
+	Key         string       `json:"key,omitempty"`
+	Prefix      string       `json:"prefix"`
+	Permissions []Permission `json:"permissions"`
+	ExpiresAt   *time.Time   `json:"expires_at"`
+	LastUsedAt  *time.Time   `json:"last_used_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+func (k *APIKey) Can(permission Permission) bool {
+	for _, p := range k.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type PostgresAPIKeyStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAPIKeyStore(db *sql.DB) *PostgresAPIKeyStore {
+	return &PostgresAPIKeyStore{db: db}
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(*APIKey) error
+	ListAPIKeys(userID int) ([]*APIKey, error)
+	DeleteAPIKey(id int64, userID int) error
+	GetUserByAPIKey(key string) (*User, *APIKey, error)
+}
+
+// apiKeyPrefixLength is how much of the key is kept in the clear so users can
+// tell their keys apart
+const apiKeyPrefixLength = 10
+
+func (pg *PostgresAPIKeyStore) CreateAPIKey(apiKey *APIKey) error {
+	key, hash, err := tokens.GenerateAPIKey()
+	if err != nil {
+		return err
+	}
+	apiKey.Key = key
+	apiKey.Prefix = key[:apiKeyPrefixLength]
+
+	query := `
+	INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+
+	return pg.db.QueryRow(query, apiKey.UserID, apiKey.Name, apiKey.Prefix, hash, permissionsArray(apiKey.Permissions), apiKey.ExpiresAt).Scan(&apiKey.ID, &apiKey.CreatedAt)
+}
+
+func (pg *PostgresAPIKeyStore) ListAPIKeys(userID int) ([]*APIKey, error) {
+	query := `
+	SELECT id, user_id, name, prefix, permissions, expires_at, last_used_at, created_at
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (pg *PostgresAPIKeyStore) DeleteAPIKey(id int64, userID int) error {
+	result, err := pg.db.Exec(`DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// GetUserByAPIKey returns the key's owner and the key itself, or ErrNotFound
+// when the key is unknown or expired. It also records when the key was last used.
+func (pg *PostgresAPIKeyStore) GetUserByAPIKey(key string) (*User, *APIKey, error) {
+	hash := sha256.Sum256([]byte(key))
+
+	query := `
+	UPDATE api_keys k
+	SET last_used_at = CURRENT_TIMESTAMP
+	FROM users u
+	WHERE k.hash = $1 AND u.id = k.user_id AND (k.expires_at IS NULL OR k.expires_at > $2)
+	RETURNING k.id, k.user_id, k.name, k.prefix, k.permissions, k.expires_at, k.last_used_at, k.created_at, ` + userColumns + `
+	`
+
+	user := &User{
+		PasswordHash: password{},
+	}
+	apiKey := &APIKey{}
+	var permissions pgtype.TextArray
+
+	dest := []any{
+		&apiKey.ID,
+		&apiKey.UserID,
+		&apiKey.Name,
+		&apiKey.Prefix,
+		&permissions,
+		&apiKey.ExpiresAt,
+		&apiKey.LastUsedAt,
+		&apiKey.CreatedAt,
+	}
+	err := pg.db.QueryRow(query, hash[:], time.Now()).Scan(append(dest, userDest(user)...)...)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	apiKey.Permissions, err = assignPermissions(permissions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, apiKey, nil
+}
+
+func scanAPIKey(scan func(dest ...any) error) (*APIKey, error) {
+	key := &APIKey{}
+	var permissions pgtype.TextArray
+	err := scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&permissions,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Permissions, err = assignPermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func assignPermissions(array pgtype.TextArray) ([]Permission, error) {
+	values := []string{}
+	err := array.AssignTo(&values)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make([]Permission, len(values))
+	for i, value := range values {
+		permissions[i] = Permission(value)
+	}
+	return permissions, nil
+}
+
+func permissionsArray(permissions []Permission) []string {
+	values := make([]string, len(permissions))
+	for i, permission := range permissions {
+		values[i] = string(permission)
+	}
+	return values
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	apiKeyStore := NewPostgresAPIKeyStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	apiKey := &APIKey{
+		UserID:      testUser.ID,
+		Name:        "Garmin sync",
+		Permissions: []Permission{PermissionWorkoutsWrite, PermissionStatsRead},
+	}
+	err = apiKeyStore.CreateAPIKey(apiKey)
+	require.NoError(t, err)
+	require.NotEmpty(t, apiKey.Key)
+	assert.True(t, strings.HasPrefix(apiKey.Key, apiKey.Prefix))
+
+	user, found, err := apiKeyStore.GetUserByAPIKey(apiKey.Key)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, testUser.ID, user.ID)
+	assert.True(t, found.Can(PermissionStatsRead))
+	assert.False(t, found.Can(PermissionWorkoutsRead))
+	assert.NotNil(t, found.LastUsedAt)
+
+	keys, err := apiKeyStore.ListAPIKeys(testUser.ID)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Empty(t, keys[0].Key)
+
+	expired := time.Now().Add(-time.Minute)
+	expiredKey := &APIKey{UserID: testUser.ID, Name: "Old", Permissions: []Permission{PermissionStatsRead}, ExpiresAt: &expired}
+	err = apiKeyStore.CreateAPIKey(expiredKey)
+	require.NoError(t, err)
+	user, _, err = apiKeyStore.GetUserByAPIKey(expiredKey.Key)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = apiKeyStore.DeleteAPIKey(int64(apiKey.ID), testUser.ID+1)
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = apiKeyStore.DeleteAPIKey(int64(apiKey.ID), testUser.ID)
+	require.NoError(t, err)
+	user, _, err = apiKeyStore.GetUserByAPIKey(apiKey.Key)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
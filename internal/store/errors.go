@@ -0,0 +1,106 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgconn"
+)
+
+// ErrNotFound is returned when the row a method looks up, updates or deletes
+// doesn't exist, or doesn't belong to the user asking for it
+var ErrNotFound = errors.New("record not found")
+
+// ErrConflict is returned when a write would duplicate a value that has to be
+// unique. Field names the field that clashed, as clients know it.
+type ErrConflict struct {
+	Field string
+}
+
+func (e *ErrConflict) Error() string {
+	return e.Field + " is already taken"
+}
+
+// ErrConstraint is returned when a write breaks a check, foreign key or not
+// null constraint. Name is the constraint, or the column for not null.
+type ErrConstraint struct {
+	Name string
+}
+
+func (e *ErrConstraint) Error() string {
+	return "violates constraint " + e.Name
+}
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	codeNotNullViolation    = "23502"
+	codeForeignKeyViolation = "23503"
+	codeUniqueViolation     = "23505"
+	codeCheckViolation      = "23514"
+)
+
+// conflictFields names the field for unique constraints whose columns don't
+// say it by themselves
+var conflictFields = map[string]string{
+	"idx_exercises_owner_name": "name",
+	"idx_users_email_lower":    "email",
+}
+
+// uniqueKeyDetail matches the detail of a unique violation, which lists the
+// constraint's columns: Key (user_id, program_id)=(1, 2) already exists.
+var uniqueKeyDetail = regexp.MustCompile(`^Key \(([^)]*)\)=`)
+
+// translateError turns the database errors a caller can act on into the
+// errors above and passes anything else through
+func translateError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case codeUniqueViolation:
+		return &ErrConflict{Field: conflictField(pgErr)}
+	case codeCheckViolation, codeForeignKeyViolation:
+		return &ErrConstraint{Name: pgErr.ConstraintName}
+	case codeNotNullViolation:
+		return &ErrConstraint{Name: pgErr.ColumnName}
+	}
+
+	return err
+}
+
+// conflictField picks the field a unique violation is about. For constraints
+// spanning several columns, the last one is the one the user chose; the rest
+// scope it, usually to the user.
+func conflictField(pgErr *pgconn.PgError) string {
+	if field, ok := conflictFields[pgErr.ConstraintName]; ok {
+		return field
+	}
+
+	match := uniqueKeyDetail.FindStringSubmatch(pgErr.Detail)
+	if match == nil {
+		return pgErr.ConstraintName
+	}
+
+	columns := strings.Split(match[1], ", ")
+	return columns[len(columns)-1]
+}
+
+// isCode reports whether err is a Postgres error with the given code
+func isCode(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+// translate is translateError for a deferred call on a named error result,
+// for methods that can fail in many places
+func translate(err *error) {
+	*err = translateError(*err)
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateError(t *testing.T) {
+	other := errors.New("connection reset")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "No error",
+			err:  nil,
+			want: nil,
+		},
+		{
+			name: "No rows",
+			err:  sql.ErrNoRows,
+			want: ErrNotFound,
+		},
+		{
+			name: "Unique violation names the last column",
+			err: &pgconn.PgError{
+				Code:           codeUniqueViolation,
+				ConstraintName: "users_email_key",
+				Detail:         "Key (email)=(gonzalo@example.com) already exists.",
+			},
+			want: &ErrConflict{Field: "email"},
+		},
+		{
+			name: "Unique violation scoped to the user",
+			err: &pgconn.PgError{
+				Code:           codeUniqueViolation,
+				ConstraintName: "idx_program_enrollments_active",
+				Detail:         "Key (user_id, program_id)=(1, 2) already exists.",
+			},
+			want: &ErrConflict{Field: "program_id"},
+		},
+		{
+			name: "Unique violation on an expression index",
+			err: &pgconn.PgError{
+				Code:           codeUniqueViolation,
+				ConstraintName: "idx_exercises_owner_name",
+				Detail:         "Key (COALESCE(user_id, 0::bigint), lower(name::text))=(1, squat) already exists.",
+			},
+			want: &ErrConflict{Field: "name"},
+		},
+		{
+			name: "Unique violation on the case-insensitive email",
+			err: &pgconn.PgError{
+				Code:           codeUniqueViolation,
+				ConstraintName: "idx_users_email_lower",
+				Detail:         "Key (lower(email::text))=(gonzalo@example.com) already exists.",
+			},
+			want: &ErrConflict{Field: "email"},
+		},
+		{
+			name: "Check violation",
+			err:  &pgconn.PgError{Code: codeCheckViolation, ConstraintName: "valid_workout_entry"},
+			want: &ErrConstraint{Name: "valid_workout_entry"},
+		},
+		{
+			name: "Not null violation names the column",
+			err:  &pgconn.PgError{Code: codeNotNullViolation, ColumnName: "title"},
+			want: &ErrConstraint{Name: "title"},
+		},
+		{
+			name: "Other errors pass through",
+			err:  other,
+			want: other,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, translateError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+var (
+	ErrUnknownExercise = errors.New("unknown exercise")
+	ErrExerciseInUse   = errors.New("exercise is used by workouts, templates or programs")
+)
+
+type Exercise struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"user_id"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsGlobal reports whether the exercise belongs to the shared catalog rather
+// than to a single user
+func (e *Exercise) IsGlobal() bool {
+	return e.UserID == nil
+}
+
+type PostgresExerciseStore struct {
+	db *sql.DB
+}
+
+func NewPostgresExerciseStore(db *sql.DB) *PostgresExerciseStore {
+	return &PostgresExerciseStore{db: db}
+}
+
+type ExerciseStore interface {
+	CreateExercise(*Exercise) error
+	GetExerciseByID(id int64, userID int) (*Exercise, error)
+	ListExercises(userID int, search string) ([]*Exercise, error)
+	UpdateExercise(*Exercise) error
+	DeleteExercise(id int64) error
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx so lookups can run inside
+// another store's transaction
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+const exerciseColumns = `
+	e.id, e.user_id, e.name, e.created_at, e.updated_at,
+	COALESCE((SELECT array_agg(a.alias ORDER BY a.alias) FROM exercise_aliases a WHERE a.exercise_id = e.id), '{}')
+`
+
+func scanExercise(scan func(dest ...any) error) (*Exercise, error) {
+	exercise := &Exercise{}
+	var userID sql.NullInt64
+	var aliases pgtype.TextArray
+	err := scan(
+		&exercise.ID,
+		&userID,
+		&exercise.Name,
+		&exercise.CreatedAt,
+		&exercise.UpdatedAt,
+		&aliases,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID.Valid {
+		id := int(userID.Int64)
+		exercise.UserID = &id
+	}
+
+	exercise.Aliases = []string{}
+	err = aliases.AssignTo(&exercise.Aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	return exercise, nil
+}
+
+func (pg *PostgresExerciseStore) CreateExercise(exercise *Exercise) (err error) {
+	defer translate(&err)
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO exercises (user_id, name)
+	VALUES ($1, $2)
+	RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(query, exercise.UserID, exercise.Name).Scan(&exercise.ID, &exercise.CreatedAt, &exercise.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertAliases(tx, exercise)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetExerciseByID returns the exercise if it's either global or owned by the
+// user, and ErrNotFound otherwise
+func (pg *PostgresExerciseStore) GetExerciseByID(id int64, userID int) (*Exercise, error) {
+	query := `
+	SELECT` + exerciseColumns + `
+	FROM exercises e
+	WHERE e.id = $1 AND (e.user_id IS NULL OR e.user_id = $2)
+	`
+
+	exercise, err := scanExercise(pg.db.QueryRow(query, id, userID).Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return exercise, nil
+}
+
+// ListExercises returns the global catalog plus the user's custom exercises,
+// optionally narrowed down to names or aliases containing search
+func (pg *PostgresExerciseStore) ListExercises(userID int, search string) ([]*Exercise, error) {
+	query := `
+	SELECT` + exerciseColumns + `
+	FROM exercises e
+	WHERE (e.user_id IS NULL OR e.user_id = $1)
+	AND (
+		$2 = ''
+		OR e.name ILIKE '%' || $2 || '%' ESCAPE '\'
+		OR EXISTS (SELECT 1 FROM exercise_aliases a WHERE a.exercise_id = e.id AND a.alias ILIKE '%' || $2 || '%' ESCAPE '\')
+	)
+	ORDER BY lower(e.name), e.id
+	`
+
+	rows, err := pg.db.Query(query, userID, escapeLike(search))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exercises := []*Exercise{}
+	for rows.Next() {
+		exercise, err := scanExercise(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		exercises = append(exercises, exercise)
+	}
+
+	return exercises, rows.Err()
+}
+
+func (pg *PostgresExerciseStore) UpdateExercise(exercise *Exercise) (err error) {
+	defer translate(&err)
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE exercises
+	SET name = $1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $2
+	RETURNING updated_at
+	`
+	err = tx.QueryRow(query, exercise.Name, exercise.ID).Scan(&exercise.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	// Keep the denormalized name on existing entries in sync
+	_, err = tx.Exec(`UPDATE workout_entries SET exercise_name = $1 WHERE exercise_id = $2`, exercise.Name, exercise.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE template_entries SET exercise_name = $1 WHERE exercise_id = $2`, exercise.Name, exercise.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM exercise_aliases WHERE exercise_id = $1`, exercise.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertAliases(tx, exercise)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExercise returns ErrExerciseInUse when workouts, templates or program
+// rules still point at the exercise. The foreign keys decide, so an entry
+// added while the delete runs can't be left without its exercise.
+func (pg *PostgresExerciseStore) DeleteExercise(id int64) error {
+	result, err := pg.db.Exec(`DELETE FROM exercises WHERE id = $1`, id)
+	if isCode(err, codeForeignKeyViolation) {
+		return ErrExerciseInUse
+	}
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func insertAliases(q queryer, exercise *Exercise) error {
+	seen := map[string]bool{}
+	aliases := []string{}
+	for _, alias := range exercise.Aliases {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || seen[strings.ToLower(alias)] {
+			continue
+		}
+		seen[strings.ToLower(alias)] = true
+		aliases = append(aliases, alias)
+
+		_, err := q.Exec(`INSERT INTO exercise_aliases (exercise_id, alias) VALUES ($1, $2)`, exercise.ID, alias)
+		if err != nil {
+			return err
+		}
+	}
+
+	exercise.Aliases = aliases
+	return nil
+}
+
+// resolveExercise finds the catalog exercise an entry refers to and returns
+// its ID and canonical name. An explicit exerciseID must be visible to the
+// user; otherwise the name is matched against names and aliases, preferring
+// exact names and the user's own exercises. Names that don't match anything
+// become a custom exercise.
+func resolveExercise(q queryer, userID int, exerciseID *int, name string) (int, string, error) {
+	var id int
+	if exerciseID != nil {
+		query := `
+		SELECT id, name
+		FROM exercises
+		WHERE id = $1 AND (user_id IS NULL OR user_id = $2)
+		`
+		err := q.QueryRow(query, *exerciseID, userID).Scan(&id, &name)
+		if err == sql.ErrNoRows {
+			return 0, "", ErrUnknownExercise
+		}
+		return id, name, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, "", ErrUnknownExercise
+	}
+
+	query := `
+	SELECT e.id, e.name
+	FROM exercises e
+	LEFT JOIN exercise_aliases a ON a.exercise_id = e.id
+	WHERE (e.user_id IS NULL OR e.user_id = $1)
+	AND (lower(e.name) = lower($2) OR lower(a.alias) = lower($2))
+	ORDER BY lower(e.name) = lower($2) DESC, e.user_id NULLS LAST
+	LIMIT 1
+	`
+	err := q.QueryRow(query, userID, name).Scan(&id, &name)
+	if err == sql.ErrNoRows {
+		query = `
+		INSERT INTO exercises (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id, name
+		`
+		err = q.QueryRow(query, userID, name).Scan(&id, &name)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, name, nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveExercise(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	workoutStore := NewPostgresWorkoutStore(db)
+	exerciseStore := NewPostgresExerciseStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	bench := &Exercise{
+		UserID:  &testUser.ID,
+		Name:    "Bench press",
+		Aliases: []string{"BB Bench", "bench"},
+	}
+	err = exerciseStore.CreateExercise(bench)
+	require.NoError(t, err)
+
+	workout, err := workoutStore.CreateWorkout(&Workout{
+		UserID:          testUser.ID,
+		Title:           "Push day",
+		DurationMinutes: 60,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "bb bench", Sets: 3, Reps: testutil.IntPtr(10), OrderIndex: 1},
+			{ExerciseID: &bench.ID, Sets: 3, Reps: testutil.IntPtr(8), OrderIndex: 2},
+			{ExerciseName: "Sled push", Sets: 4, DurationSeconds: testutil.IntPtr(30), OrderIndex: 3},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("Aliases resolve to the canonical exercise", func(t *testing.T) {
+		require.NotNil(t, workout.Entries[0].ExerciseID)
+		assert.Equal(t, bench.ID, *workout.Entries[0].ExerciseID)
+		assert.Equal(t, "Bench press", workout.Entries[0].ExerciseName)
+		assert.Equal(t, "Bench press", workout.Entries[1].ExerciseName)
+	})
+
+	t.Run("Unknown names become custom exercises", func(t *testing.T) {
+		require.NotNil(t, workout.Entries[2].ExerciseID)
+		sled, err := exerciseStore.GetExerciseByID(int64(*workout.Entries[2].ExerciseID), testUser.ID)
+		require.NoError(t, err)
+		require.NotNil(t, sled)
+		assert.Equal(t, "Sled push", sled.Name)
+		assert.Equal(t, testUser.ID, *sled.UserID)
+	})
+
+	t.Run("Exercises in use cannot be deleted", func(t *testing.T) {
+		err := exerciseStore.DeleteExercise(int64(bench.ID))
+		assert.ErrorIs(t, err, ErrExerciseInUse)
+	})
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Profile is what anyone can see about a user on their public page
+type Profile struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	Bio       string `json:"bio"`
+	Followers int    `json:"followers"`
+	Following int    `json:"following"`
+	// FollowedByViewer tells whoever is looking whether this user accepted
+	// them as a follower, and FollowRequested whether they're still waiting
+	FollowedByViewer bool      `json:"followed_by_viewer"`
+	FollowRequested  bool      `json:"follow_requested"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// FollowRequest is someone asking to see a user's followers-only workouts
+type FollowRequest struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PostgresFollowStore struct {
+	db *sql.DB
+}
+
+func NewPostgresFollowStore(db *sql.DB) *PostgresFollowStore {
+	return &PostgresFollowStore{db: db}
+}
+
+type FollowStore interface {
+	GetProfile(username string, viewerID int) (*Profile, error)
+	Follow(followerID, followeeID int) error
+	Unfollow(followerID, followeeID int) error
+	ListFollowRequests(followeeID int) ([]*FollowRequest, error)
+	AcceptFollower(followeeID, followerID int) error
+	RemoveFollower(followeeID, followerID int) error
+}
+
+// GetProfile returns ErrNotFound when there's no such user or they've been
+// disabled. Only accepted follows are counted.
+func (pg *PostgresFollowStore) GetProfile(username string, viewerID int) (*Profile, error) {
+	query := `
+	SELECT u.id, u.username, COALESCE(u.bio, ''), u.created_at,
+		(SELECT COUNT(*) FROM follows WHERE followee_id = u.id AND accepted_at IS NOT NULL),
+		(SELECT COUNT(*) FROM follows WHERE follower_id = u.id AND accepted_at IS NOT NULL),
+		COALESCE((SELECT accepted_at IS NOT NULL FROM follows WHERE follower_id = $2 AND followee_id = u.id), false),
+		EXISTS (SELECT 1 FROM follows WHERE follower_id = $2 AND followee_id = u.id AND accepted_at IS NULL)
+	FROM users u
+	WHERE u.username = $1 AND u.disabled_at IS NULL
+	`
+
+	var profile Profile
+	err := pg.db.QueryRow(query, username, viewerID).Scan(
+		&profile.ID,
+		&profile.Username,
+		&profile.Bio,
+		&profile.CreatedAt,
+		&profile.Followers,
+		&profile.Following,
+		&profile.FollowedByViewer,
+		&profile.FollowRequested,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+// Follow asks to follow a user. The follow only counts once the followee
+// accepts it. It's a no-op when the request or follow already exists.
+func (pg *PostgresFollowStore) Follow(followerID, followeeID int) error {
+	query := `
+	INSERT INTO follows (follower_id, followee_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`
+
+	_, err := pg.db.Exec(query, followerID, followeeID)
+	return translateError(err)
+}
+
+// Unfollow withdraws a follow or a pending request. It's a no-op when there's
+// nothing to undo.
+func (pg *PostgresFollowStore) Unfollow(followerID, followeeID int) error {
+	query := `
+	DELETE FROM follows
+	WHERE follower_id = $1 AND followee_id = $2
+	`
+
+	_, err := pg.db.Exec(query, followerID, followeeID)
+	return err
+}
+
+// ListFollowRequests returns the requests waiting on the user, oldest first.
+// Requests from disabled users are left out.
+func (pg *PostgresFollowStore) ListFollowRequests(followeeID int) ([]*FollowRequest, error) {
+	query := `
+	SELECT u.username, f.created_at
+	FROM follows f
+	JOIN users u ON u.id = f.follower_id
+	WHERE f.followee_id = $1 AND f.accepted_at IS NULL AND u.disabled_at IS NULL
+	ORDER BY f.created_at, u.id
+	`
+
+	rows, err := pg.db.Query(query, followeeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*FollowRequest{}
+	for rows.Next() {
+		var request FollowRequest
+		err = rows.Scan(&request.Username, &request.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, &request)
+	}
+
+	return requests, rows.Err()
+}
+
+// AcceptFollower lets the follower see the user's followers-only workouts. It
+// returns ErrNotFound when there's no pending request from them.
+func (pg *PostgresFollowStore) AcceptFollower(followeeID, followerID int) error {
+	query := `
+	UPDATE follows
+	SET accepted_at = CURRENT_TIMESTAMP
+	WHERE followee_id = $1 AND follower_id = $2 AND accepted_at IS NULL
+	`
+
+	return pg.execOne(query, followeeID, followerID)
+}
+
+// RemoveFollower removes a follower or declines their request. It returns
+// ErrNotFound when they neither follow the user nor asked to.
+func (pg *PostgresFollowStore) RemoveFollower(followeeID, followerID int) error {
+	query := `
+	DELETE FROM follows
+	WHERE followee_id = $1 AND follower_id = $2
+	`
+
+	return pg.execOne(query, followeeID, followerID)
+}
+
+// execOne runs a statement that should change exactly one follow
+func (pg *PostgresFollowStore) execOne(query string, args ...any) error {
+	result, err := pg.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
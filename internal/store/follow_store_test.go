@@ -0,0 +1,170 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowsAndFeed(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+	workoutStore := NewPostgresWorkoutStore(db)
+	followStore := NewPostgresFollowStore(db)
+
+	users := map[string]*User{}
+	for _, username := range []string{"gonzalo", "maria", "pedro"} {
+		user := &User{Username: username, Email: username + "@example.com"}
+		err := user.PasswordHash.Set("securepassword")
+		require.NoError(t, err)
+		err = userStore.CreateUser(user)
+		require.NoError(t, err)
+		users[username] = user
+	}
+	gonzalo, maria, pedro := users["gonzalo"], users["maria"], users["pedro"]
+
+	workouts := map[Visibility]*Workout{}
+	for i, visibility := range []Visibility{VisibilityPrivate, VisibilityFollowers, VisibilityPublic} {
+		workout, err := workoutStore.CreateWorkout(&Workout{
+			UserID:          maria.ID,
+			Title:           string(visibility),
+			DurationMinutes: 30,
+			Visibility:      visibility,
+			PerformedAt:     time.Date(2025, 1, 1+i, 18, 0, 0, 0, time.UTC),
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Squat", Sets: 1, Reps: testutil.IntPtr(5), OrderIndex: 1},
+			},
+		})
+		require.NoError(t, err)
+		workouts[visibility] = workout
+	}
+
+	// Workouts default to private
+	own, err := workoutStore.CreateWorkout(&Workout{UserID: gonzalo.ID, Title: "Mine", DurationMinutes: 30})
+	require.NoError(t, err)
+	assert.Equal(t, VisibilityPrivate, own.Visibility)
+
+	canSee := func(viewerID int, visibility Visibility) bool {
+		_, err := workoutStore.GetVisibleWorkout(int64(workouts[visibility].ID), viewerID)
+		if errors.Is(err, ErrNotFound) {
+			return false
+		}
+		require.NoError(t, err)
+		return true
+	}
+
+	// Before following, only the public workout is visible, to users and
+	// anonymous visitors alike
+	for _, viewerID := range []int{gonzalo.ID, AnonymousUser.ID} {
+		assert.False(t, canSee(viewerID, VisibilityPrivate))
+		assert.False(t, canSee(viewerID, VisibilityFollowers))
+		assert.True(t, canSee(viewerID, VisibilityPublic))
+	}
+
+	err = followStore.Follow(gonzalo.ID, maria.ID)
+	require.NoError(t, err)
+	// Following twice is fine
+	err = followStore.Follow(gonzalo.ID, maria.ID)
+	require.NoError(t, err)
+
+	// A follow is only a request until it's accepted
+	assert.False(t, canSee(gonzalo.ID, VisibilityFollowers))
+	profile, err := followStore.GetProfile("maria", gonzalo.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, profile.Followers)
+	assert.False(t, profile.FollowedByViewer)
+	assert.True(t, profile.FollowRequested)
+
+	requests, err := followStore.ListFollowRequests(maria.ID)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "gonzalo", requests[0].Username)
+
+	err = followStore.AcceptFollower(maria.ID, pedro.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = followStore.AcceptFollower(maria.ID, gonzalo.ID)
+	require.NoError(t, err)
+	err = followStore.AcceptFollower(maria.ID, gonzalo.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	requests, err = followStore.ListFollowRequests(maria.ID)
+	require.NoError(t, err)
+	assert.Empty(t, requests)
+
+	assert.False(t, canSee(gonzalo.ID, VisibilityPrivate))
+	assert.True(t, canSee(gonzalo.ID, VisibilityFollowers))
+	assert.True(t, canSee(maria.ID, VisibilityPrivate))
+
+	profile, err = followStore.GetProfile("maria", gonzalo.ID)
+	require.NoError(t, err)
+	require.NotNil(t, profile)
+	assert.Equal(t, 1, profile.Followers)
+	assert.Equal(t, 0, profile.Following)
+	assert.True(t, profile.FollowedByViewer)
+	assert.False(t, profile.FollowRequested)
+
+	profile, err = followStore.GetProfile("maria", pedro.ID)
+	require.NoError(t, err)
+	assert.False(t, profile.FollowedByViewer)
+
+	// The feed pages through the shared workouts, newest first
+	feed, next, err := workoutStore.ListSharedWorkouts(SharedFilter{ViewerID: gonzalo.ID, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, feed, 1)
+	require.NotNil(t, next)
+	assert.Equal(t, "maria", feed[0].Username)
+	assert.Equal(t, workouts[VisibilityPublic].ID, feed[0].Workout.ID)
+	assert.Len(t, feed[0].Workout.Entries, 1)
+
+	feed, next, err = workoutStore.ListSharedWorkouts(SharedFilter{ViewerID: gonzalo.ID, Cursor: next, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, feed, 1)
+	assert.Equal(t, workouts[VisibilityFollowers].ID, feed[0].Workout.ID)
+
+	feed, next, err = workoutStore.ListSharedWorkouts(SharedFilter{ViewerID: gonzalo.ID, Cursor: next, Limit: 1})
+	require.NoError(t, err)
+	assert.Empty(t, feed)
+	assert.Nil(t, next)
+
+	// Someone who doesn't follow anyone has an empty feed, but still sees
+	// public workouts on a profile
+	feed, _, err = workoutStore.ListSharedWorkouts(SharedFilter{ViewerID: pedro.ID, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, feed)
+
+	feed, _, err = workoutStore.ListSharedWorkouts(SharedFilter{ViewerID: pedro.ID, OwnerID: maria.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, feed, 1)
+	assert.Equal(t, workouts[VisibilityPublic].ID, feed[0].Workout.ID)
+
+	// The followee can remove a follower, or they can unfollow themselves
+	err = followStore.RemoveFollower(maria.ID, gonzalo.ID)
+	require.NoError(t, err)
+	assert.False(t, canSee(gonzalo.ID, VisibilityFollowers))
+	err = followStore.RemoveFollower(maria.ID, gonzalo.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = followStore.Follow(gonzalo.ID, maria.ID)
+	require.NoError(t, err)
+	err = followStore.AcceptFollower(maria.ID, gonzalo.ID)
+	require.NoError(t, err)
+	err = followStore.Unfollow(gonzalo.ID, maria.ID)
+	require.NoError(t, err)
+	assert.False(t, canSee(gonzalo.ID, VisibilityFollowers))
+
+	// Disabled users disappear along with their workouts
+	now := time.Now()
+	maria.DisabledAt = &now
+	err = userStore.UpdateUser(maria)
+	require.NoError(t, err)
+
+	profile, err = followStore.GetProfile("maria", gonzalo.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, canSee(gonzalo.ID, VisibilityPublic))
+}
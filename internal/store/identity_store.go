@@ -0,0 +1,148 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"time"
+)
+
+// UserIdentity links a user to their account at an external identity provider
+type UserIdentity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"-"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLoginState is kept from the redirect to the provider until its callback
+type OIDCLoginState struct {
+	State    string
+	Provider string
+	Nonce    string
+	Verifier string
+	Expiry   time.Time
+}
+
+type PostgresIdentityStore struct {
+	db *sql.DB
+}
+
+func NewPostgresIdentityStore(db *sql.DB) *PostgresIdentityStore {
+	return &PostgresIdentityStore{
+		db: db,
+	}
+}
+
+type IdentityStore interface {
+	GetUserByIdentity(provider, subject string) (*User, error)
+	CreateIdentity(*UserIdentity) error
+	CreateUserWithIdentity(*User, *UserIdentity) error
+	CreateLoginState(*OIDCLoginState) error
+	ConsumeLoginState(state, provider string) (*OIDCLoginState, error)
+}
+
+func (pg *PostgresIdentityStore) GetUserByIdentity(provider, subject string) (*User, error) {
+	query := `
+	SELECT ` + userColumns + `
+	FROM users u
+	INNER JOIN user_identities i ON i.user_id = u.id
+	WHERE i.provider = $1 AND i.subject = $2
+	`
+
+	user := &User{PasswordHash: password{}}
+	err := pg.db.QueryRow(query, provider, subject).Scan(userDest(user)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (pg *PostgresIdentityStore) CreateIdentity(identity *UserIdentity) error {
+	return translateError(insertIdentity(pg.db, identity))
+}
+
+func insertIdentity(q queryer, identity *UserIdentity) error {
+	query := `
+	INSERT INTO user_identities (user_id, provider, subject, email)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at
+	`
+
+	return q.QueryRow(query, identity.UserID, identity.Provider, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+// CreateUserWithIdentity signs up a user who first arrived through a provider
+func (pg *PostgresIdentityStore) CreateUserWithIdentity(user *User, identity *UserIdentity) (err error) {
+	defer translate(&err)
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(tx, user)
+	if err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+	err = insertIdentity(tx, identity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateLoginState keeps the state's hash, the nonce and the PKCE verifier
+// until the callback comes back. Logins that were abandoned and have expired
+// are cleared out on the way, since anyone can start one.
+func (pg *PostgresIdentityStore) CreateLoginState(login *OIDCLoginState) error {
+	hash := sha256.Sum256([]byte(login.State))
+
+	query := `
+	WITH expired AS (
+		DELETE FROM oidc_login_states WHERE expiry <= $6
+	)
+	INSERT INTO oidc_login_states (state_hash, provider, nonce, verifier, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := pg.db.Exec(query, hash[:], login.Provider, login.Nonce, login.Verifier, login.Expiry, time.Now())
+	return err
+}
+
+// ConsumeLoginState returns the login started with state and deletes it, so a
+// callback can't be replayed. It returns ErrNotFound when there's no such
+// unexpired login for the provider.
+func (pg *PostgresIdentityStore) ConsumeLoginState(state, provider string) (*OIDCLoginState, error) {
+	hash := sha256.Sum256([]byte(state))
+
+	query := `
+	DELETE FROM oidc_login_states
+	WHERE state_hash = $1
+	RETURNING provider, nonce, verifier, expiry
+	`
+
+	login := &OIDCLoginState{State: state}
+	err := pg.db.QueryRow(query, hash[:]).Scan(&login.Provider, &login.Nonce, &login.Verifier, &login.Expiry)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if login.Provider != provider || !login.Expiry.After(time.Now()) {
+		return nil, ErrNotFound
+	}
+
+	return login, nil
+}
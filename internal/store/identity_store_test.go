@@ -0,0 +1,81 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentities(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	identityStore := NewPostgresIdentityStore(db)
+
+	user := &User{Username: "gonzalo", Email: "gonzalo@example.com", Activated: true}
+	err := user.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	identity := &UserIdentity{Provider: "okta", Subject: "abc123", Email: "gonzalo@example.com"}
+	err = identityStore.CreateUserWithIdentity(user, identity)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, identity.UserID)
+
+	found, err := identityStore.GetUserByIdentity("okta", "abc123")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, user.ID, found.ID)
+
+	found, err = identityStore.GetUserByIdentity("google", "abc123")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = identityStore.CreateIdentity(&UserIdentity{UserID: user.ID, Provider: "google", Subject: "xyz"})
+	require.NoError(t, err)
+	found, err = identityStore.GetUserByIdentity("google", "xyz")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, user.ID, found.ID)
+}
+
+func TestLoginStates(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	identityStore := NewPostgresIdentityStore(db)
+
+	login := &OIDCLoginState{State: "state", Provider: "okta", Nonce: "nonce", Verifier: "verifier", Expiry: time.Now().Add(time.Minute)}
+	err := identityStore.CreateLoginState(login)
+	require.NoError(t, err)
+
+	found, err := identityStore.ConsumeLoginState("state", "okta")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "nonce", found.Nonce)
+	assert.Equal(t, "verifier", found.Verifier)
+
+	// States only work once
+	found, err = identityStore.ConsumeLoginState("state", "okta")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	login.State = "other"
+	err = identityStore.CreateLoginState(login)
+	require.NoError(t, err)
+	found, err = identityStore.ConsumeLoginState("other", "google")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Abandoned logins are cleared out once they expire
+	abandoned := &OIDCLoginState{State: "abandoned", Provider: "okta", Nonce: "nonce", Verifier: "verifier", Expiry: time.Now().Add(-time.Minute)}
+	err = identityStore.CreateLoginState(abandoned)
+	require.NoError(t, err)
+	login.State = "fresh"
+	err = identityStore.CreateLoginState(login)
+	require.NoError(t, err)
+
+	var remaining int
+	err = db.QueryRow(`SELECT COUNT(*) FROM oidc_login_states`).Scan(&remaining)
+	require.NoError(t, err)
+	assert.Equal(t, 1, remaining)
+}
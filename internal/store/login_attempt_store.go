@@ -0,0 +1,207 @@
+package store
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// LoginPolicy decides how long a key is locked out after repeated failed logins.
+// The first FreeAttempts failures cost nothing, after that the lockout starts
+// at BaseDelay and doubles with every failure up to MaxDelay.
+type LoginPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// Window is how long a key has to go without failures before its count
+	// starts over
+	Window time.Duration
+}
+
+func (p LoginPolicy) Lockout(failures int) time.Duration {
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < over && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// LoginAttemptStore tracks failed logins per key. Keys are opaque to the store,
+// the token handler uses one per username and one per client IP. The user
+// handler also counts activation resends with it, under their own keys.
+type LoginAttemptStore interface {
+	// LockedUntil returns when the key's lockout ends, or the zero time when it
+	// isn't locked
+	LockedUntil(key string) (time.Time, error)
+	// RecordFailure counts a failed login and returns the resulting lockout end
+	RecordFailure(key string, policy LoginPolicy) (time.Time, error)
+	ResetAttempts(key string) error
+}
+
+type loginAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+// maxMemoryAttempts bounds the in-memory store, so a flood of random usernames
+// can't grow it forever. Attempts past it push out the oldest ones.
+const maxMemoryAttempts = 10000
+
+// MemoryLoginAttemptStore keeps attempts in process. It's fine for a single
+// instance and for tests, but lockouts are lost on restart and aren't shared
+// between instances.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*loginAttempt
+	now      func() time.Time
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		attempts: map[string]*loginAttempt{},
+		now:      time.Now,
+	}
+}
+
+func (m *MemoryLoginAttemptStore) LockedUntil(key string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.attempts[key]
+	if !ok || !attempt.lockedUntil.After(m.now()) {
+		return time.Time{}, nil
+	}
+	return attempt.lockedUntil, nil
+}
+
+func (m *MemoryLoginAttemptStore) RecordFailure(key string, policy LoginPolicy) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	attempt, ok := m.attempts[key]
+	if !ok || now.Sub(attempt.lastFailureAt) > policy.Window {
+		if !ok && len(m.attempts) >= maxMemoryAttempts {
+			m.sweep(now, policy.Window)
+		}
+		if !ok && len(m.attempts) >= maxMemoryAttempts {
+			m.evictOldest()
+		}
+		attempt = &loginAttempt{}
+		m.attempts[key] = attempt
+	}
+
+	attempt.failures++
+	attempt.lastFailureAt = now
+	attempt.lockedUntil = now.Add(policy.Lockout(attempt.failures))
+
+	return attempt.lockedUntil, nil
+}
+
+// sweep drops attempts that are outside the window and no longer locked
+func (m *MemoryLoginAttemptStore) sweep(now time.Time, window time.Duration) {
+	for key, attempt := range m.attempts {
+		if now.Sub(attempt.lastFailureAt) > window && !attempt.lockedUntil.After(now) {
+			delete(m.attempts, key)
+		}
+	}
+}
+
+// evictOldest makes room when sweep couldn't, by dropping the attempt whose
+// last failure is the oldest. A flood of fresh keys can then only push out
+// keys that have gone quiet the longest, instead of growing the map.
+func (m *MemoryLoginAttemptStore) evictOldest() {
+	var oldestKey string
+	var oldest *loginAttempt
+	for key, attempt := range m.attempts {
+		if oldest == nil || attempt.lastFailureAt.Before(oldest.lastFailureAt) {
+			oldestKey, oldest = key, attempt
+		}
+	}
+	delete(m.attempts, oldestKey)
+}
+
+func (m *MemoryLoginAttemptStore) ResetAttempts(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+type PostgresLoginAttemptStore struct {
+	db *sql.DB
+}
+
+func NewPostgresLoginAttemptStore(db *sql.DB) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{
+		db: db,
+	}
+}
+
+func (pg *PostgresLoginAttemptStore) LockedUntil(key string) (time.Time, error) {
+	query := `
+	SELECT locked_until
+	FROM login_attempts
+	WHERE key = $1 AND locked_until > $2
+	`
+
+	var lockedUntil time.Time
+	err := pg.db.QueryRow(query, key, time.Now()).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil, nil
+}
+
+func (pg *PostgresLoginAttemptStore) RecordFailure(key string, policy LoginPolicy) (time.Time, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	// The upsert takes the row lock, so concurrent failures for the same key
+	// each see the other's count
+	query := `
+	INSERT INTO login_attempts (key, failures, last_failure_at)
+	VALUES ($1, 1, $2)
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+		last_failure_at = EXCLUDED.last_failure_at
+	RETURNING failures
+	`
+
+	var failures int
+	err = tx.QueryRow(query, key, now, now.Add(-policy.Window)).Scan(&failures)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lockedUntil := now.Add(policy.Lockout(failures))
+
+	_, err = tx.Exec(`UPDATE login_attempts SET locked_until = $1 WHERE key = $2`, lockedUntil, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil, tx.Commit()
+}
+
+func (pg *PostgresLoginAttemptStore) ResetAttempts(key string) error {
+	_, err := pg.db.Exec(`DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
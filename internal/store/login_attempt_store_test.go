@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLoginPolicy = LoginPolicy{
+	FreeAttempts: 2,
+	BaseDelay:    time.Second,
+	MaxDelay:     10 * time.Second,
+	Window:       time.Hour,
+}
+
+func TestLoginPolicyLockout(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, testLoginPolicy.Lockout(tt.failures), "failures: %d", tt.failures)
+	}
+}
+
+func TestMemoryLoginAttemptStore(t *testing.T) {
+	now := time.Now()
+	attempts := NewMemoryLoginAttemptStore()
+	attempts.now = func() time.Time { return now }
+
+	for range 2 {
+		lockedUntil, err := attempts.RecordFailure("user:gonzalo", testLoginPolicy)
+		require.NoError(t, err)
+		assert.False(t, lockedUntil.After(now))
+	}
+
+	lockedUntil, err := attempts.LockedUntil("user:gonzalo")
+	require.NoError(t, err)
+	assert.True(t, lockedUntil.IsZero())
+
+	lockedUntil, err = attempts.RecordFailure("user:gonzalo", testLoginPolicy)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Second), lockedUntil)
+
+	lockedUntil, err = attempts.LockedUntil("user:gonzalo")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Second), lockedUntil)
+
+	// Other keys aren't affected
+	lockedUntil, err = attempts.LockedUntil("user:maria")
+	require.NoError(t, err)
+	assert.True(t, lockedUntil.IsZero())
+
+	// The lockout runs out on its own
+	now = now.Add(2 * time.Second)
+	lockedUntil, err = attempts.LockedUntil("user:gonzalo")
+	require.NoError(t, err)
+	assert.True(t, lockedUntil.IsZero())
+
+	// but the count carries on until the window passes
+	lockedUntil, err = attempts.RecordFailure("user:gonzalo", testLoginPolicy)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Second), lockedUntil)
+
+	now = now.Add(2 * time.Hour)
+	lockedUntil, err = attempts.RecordFailure("user:gonzalo", testLoginPolicy)
+	require.NoError(t, err)
+	assert.False(t, lockedUntil.After(now))
+
+	err = attempts.ResetAttempts("user:gonzalo")
+	require.NoError(t, err)
+	assert.Empty(t, attempts.attempts)
+}
+
+func TestMemoryLoginAttemptStoreBound(t *testing.T) {
+	now := time.Now()
+	attempts := NewMemoryLoginAttemptStore()
+	attempts.now = func() time.Time { return now }
+
+	_, err := attempts.RecordFailure("user:first", testLoginPolicy)
+	require.NoError(t, err)
+
+	// Every key is still inside the window, so sweeping frees nothing
+	for i := range maxMemoryAttempts {
+		now = now.Add(time.Millisecond)
+		_, err = attempts.RecordFailure(fmt.Sprintf("user:%d", i), testLoginPolicy)
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, attempts.attempts, maxMemoryAttempts)
+	assert.NotContains(t, attempts.attempts, "user:first")
+}
+
+func TestPostgresLoginAttemptStore(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	attempts := NewPostgresLoginAttemptStore(db)
+
+	for range 2 {
+		_, err := attempts.RecordFailure("ip:10.0.0.1", testLoginPolicy)
+		require.NoError(t, err)
+	}
+
+	lockedUntil, err := attempts.LockedUntil("ip:10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, lockedUntil.IsZero())
+
+	recorded, err := attempts.RecordFailure("ip:10.0.0.1", testLoginPolicy)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Second), recorded, time.Second)
+
+	lockedUntil, err = attempts.LockedUntil("ip:10.0.0.1")
+	require.NoError(t, err)
+	assert.WithinDuration(t, recorded, lockedUntil, time.Millisecond)
+
+	err = attempts.ResetAttempts("ip:10.0.0.1")
+	require.NoError(t, err)
+
+	lockedUntil, err = attempts.LockedUntil("ip:10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, lockedUntil.IsZero())
+}
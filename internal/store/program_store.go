@@ -0,0 +1,825 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"slices"
+	"time"
+)
+
+var (
+	ErrUnknownTemplate   = errors.New("unknown template")
+	ErrTemplateInUse     = errors.New("template is used by a program")
+	ErrEmptyProgram      = errors.New("program has no days")
+	ErrAlreadyEnrolled   = errors.New("already enrolled in program")
+	ErrInvalidEnrollment = errors.New("invalid enrollment")
+)
+
+type RuleType string
+
+const (
+	// RuleLinear adds Increment to the working weight every time all the
+	// prescribed sets of the exercise are completed
+	RuleLinear RuleType = "linear"
+	// RulePercentOneRepMax prescribes Percent of the user's best estimated 1RM
+	RulePercentOneRepMax RuleType = "percent_1rm"
+)
+
+type Program struct {
+	ID            int               `json:"id"`
+	UserID        int               `json:"user_id"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	DeloadPercent float64           `json:"deload_percent"`
+	Weeks         []ProgramWeek     `json:"weeks"`
+	Rules         []ProgressionRule `json:"rules"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+type ProgramWeek struct {
+	ID         int          `json:"id"`
+	WeekNumber int          `json:"week_number"`
+	Deload     bool         `json:"deload"`
+	Days       []ProgramDay `json:"days"`
+}
+
+type ProgramDay struct {
+	ID         int `json:"id"`
+	DayNumber  int `json:"day_number"`
+	TemplateID int `json:"template_id"`
+}
+
+type ProgressionRule struct {
+	ID           int      `json:"id"`
+	ExerciseID   *int     `json:"exercise_id"`
+	ExerciseName string   `json:"exercise_name"`
+	RuleType     RuleType `json:"rule_type"`
+	Increment    *float64 `json:"increment"`
+	Percent      *float64 `json:"percent"`
+}
+
+type Enrollment struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	ProgramID   int        `json:"program_id"`
+	CurrentWeek int        `json:"current_week"`
+	CurrentDay  int        `json:"current_day"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	// Working weights by exercise ID for exercises under a linear rule
+	Weights map[int]float64 `json:"weights"`
+}
+
+func (e *Enrollment) IsCompleted() bool {
+	return e.CompletedAt != nil
+}
+
+type PostgresProgramStore struct {
+	db *sql.DB
+}
+
+func NewPostgresProgramStore(db *sql.DB) *PostgresProgramStore {
+	return &PostgresProgramStore{db: db}
+}
+
+type ProgramStore interface {
+	CreateProgram(*Program) error
+	GetProgramByID(id int64, userID int) (*Program, error)
+	ListPrograms(userID int) ([]*Program, error)
+	UpdateProgram(*Program) error
+	DeleteProgram(id int64, userID int) error
+	Enroll(programID int64, userID int) (*Enrollment, error)
+	GetEnrollment(id int64, userID int) (*Enrollment, error)
+	ListEnrollments(userID int) ([]*Enrollment, error)
+	DeleteEnrollment(id int64, userID int) error
+}
+
+func (pg *PostgresProgramStore) CreateProgram(program *Program) (err error) {
+	defer translate(&err)
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO programs (user_id, name, description, deload_percent)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(query, program.UserID, program.Name, program.Description, program.DeloadPercent).Scan(&program.ID, &program.CreatedAt, &program.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertProgramDetails(tx, program)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PostgresProgramStore) GetProgramByID(id int64, userID int) (*Program, error) {
+	program := &Program{}
+	var description sql.NullString
+	query := `
+	SELECT id, user_id, name, description, deload_percent, created_at, updated_at
+	FROM programs
+	WHERE id = $1 AND user_id = $2
+	`
+	err := pg.db.QueryRow(query, id, userID).Scan(
+		&program.ID,
+		&program.UserID,
+		&program.Name,
+		&description,
+		&program.DeloadPercent,
+		&program.CreatedAt,
+		&program.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	program.Description = description.String
+
+	err = loadProgramDetails(pg.db, []*Program{program})
+	if err != nil {
+		return nil, err
+	}
+
+	return program, nil
+}
+
+func (pg *PostgresProgramStore) ListPrograms(userID int) ([]*Program, error) {
+	query := `
+	SELECT id, user_id, name, description, deload_percent, created_at, updated_at
+	FROM programs
+	WHERE user_id = $1
+	ORDER BY lower(name), id
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	programs := []*Program{}
+	for rows.Next() {
+		var program Program
+		var description sql.NullString
+		err = rows.Scan(
+			&program.ID,
+			&program.UserID,
+			&program.Name,
+			&description,
+			&program.DeloadPercent,
+			&program.CreatedAt,
+			&program.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		program.Description = description.String
+		programs = append(programs, &program)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = loadProgramDetails(pg.db, programs)
+	if err != nil {
+		return nil, err
+	}
+
+	return programs, nil
+}
+
+// UpdateProgram replaces the program's weeks and rules. Enrollments keep their
+// position, so shrinking a program can finish them early on their next
+// workout.
+func (pg *PostgresProgramStore) UpdateProgram(program *Program) (err error) {
+	defer translate(&err)
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE programs
+	SET name = $1, description = $2, deload_percent = $3, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $4
+	RETURNING updated_at
+	`
+	err = tx.QueryRow(query, program.Name, program.Description, program.DeloadPercent, program.ID).Scan(&program.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM program_weeks WHERE program_id = $1`, program.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM program_rules WHERE program_id = $1`, program.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertProgramDetails(tx, program)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PostgresProgramStore) DeleteProgram(id int64, userID int) error {
+	result, err := pg.db.Exec(`DELETE FROM programs WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Enroll starts the user on the first day of the program's first week
+func (pg *PostgresProgramStore) Enroll(programID int64, userID int) (*Enrollment, error) {
+	program, err := pg.GetProgramByID(programID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	week, day, ok := program.first()
+	if !ok {
+		return nil, ErrEmptyProgram
+	}
+
+	var enrolled bool
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM program_enrollments
+		WHERE user_id = $1 AND program_id = $2 AND completed_at IS NULL
+	)
+	`
+	err = pg.db.QueryRow(query, userID, programID).Scan(&enrolled)
+	if err != nil {
+		return nil, err
+	}
+	if enrolled {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	enrollment := &Enrollment{
+		UserID:      userID,
+		ProgramID:   program.ID,
+		CurrentWeek: week,
+		CurrentDay:  day,
+		Weights:     map[int]float64{},
+	}
+
+	query = `
+	INSERT INTO program_enrollments (user_id, program_id, current_week, current_day)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, started_at
+	`
+	err = pg.db.QueryRow(query, userID, program.ID, week, day).Scan(&enrollment.ID, &enrollment.StartedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return enrollment, nil
+}
+
+func (pg *PostgresProgramStore) GetEnrollment(id int64, userID int) (*Enrollment, error) {
+	enrollment, err := getEnrollment(pg.db, id, userID, false)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return enrollment, nil
+}
+
+func (pg *PostgresProgramStore) ListEnrollments(userID int) ([]*Enrollment, error) {
+	query := `
+	SELECT id
+	FROM program_enrollments
+	WHERE user_id = $1
+	ORDER BY completed_at IS NOT NULL, started_at DESC, id DESC
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	enrollments := make([]*Enrollment, 0, len(ids))
+	for _, id := range ids {
+		enrollment, err := getEnrollment(pg.db, id, userID, false)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, enrollment)
+	}
+
+	return enrollments, nil
+}
+
+func (pg *PostgresProgramStore) DeleteEnrollment(id int64, userID int) error {
+	result, err := pg.db.Exec(`DELETE FROM program_enrollments WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func getEnrollment(q queryer, id int64, userID int, forUpdate bool) (*Enrollment, error) {
+	enrollment := &Enrollment{Weights: map[int]float64{}}
+	var completedAt sql.NullTime
+	query := `
+	SELECT id, user_id, program_id, current_week, current_day, started_at, completed_at
+	FROM program_enrollments
+	WHERE id = $1 AND user_id = $2
+	`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	err := q.QueryRow(query, id, userID).Scan(
+		&enrollment.ID,
+		&enrollment.UserID,
+		&enrollment.ProgramID,
+		&enrollment.CurrentWeek,
+		&enrollment.CurrentDay,
+		&enrollment.StartedAt,
+		&completedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if completedAt.Valid {
+		enrollment.CompletedAt = &completedAt.Time
+	}
+
+	rows, err := q.Query(`SELECT exercise_id, weight FROM enrollment_weights WHERE enrollment_id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var exerciseID int
+		var weight float64
+		err = rows.Scan(&exerciseID, &weight)
+		if err != nil {
+			return nil, err
+		}
+		enrollment.Weights[exerciseID] = weight
+	}
+
+	return enrollment, rows.Err()
+}
+
+func insertProgramDetails(tx *sql.Tx, program *Program) error {
+	for i := range program.Weeks {
+		week := &program.Weeks[i]
+		if week.WeekNumber == 0 {
+			week.WeekNumber = i + 1
+		}
+
+		query := `
+		INSERT INTO program_weeks (program_id, week_number, deload)
+		VALUES ($1, $2, $3)
+		RETURNING id
+		`
+		err := tx.QueryRow(query, program.ID, week.WeekNumber, week.Deload).Scan(&week.ID)
+		if err != nil {
+			return err
+		}
+
+		for j := range week.Days {
+			day := &week.Days[j]
+			if day.DayNumber == 0 {
+				day.DayNumber = j + 1
+			}
+
+			// Days can only point at the program owner's templates
+			query := `
+			INSERT INTO program_days (program_week_id, day_number, template_id)
+			SELECT $1, $2, id FROM workout_templates WHERE id = $3 AND user_id = $4
+			RETURNING id
+			`
+			err := tx.QueryRow(query, week.ID, day.DayNumber, day.TemplateID, program.UserID).Scan(&day.ID)
+			if err == sql.ErrNoRows {
+				return ErrUnknownTemplate
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := range program.Rules {
+		rule := &program.Rules[i]
+		exerciseID, name, err := resolveExercise(tx, program.UserID, rule.ExerciseID, rule.ExerciseName)
+		if err != nil {
+			return err
+		}
+		rule.ExerciseID, rule.ExerciseName = &exerciseID, name
+
+		query := `
+		INSERT INTO program_rules (program_id, exercise_id, rule_type, increment, percent)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+		`
+		err = tx.QueryRow(query, program.ID, rule.ExerciseID, string(rule.RuleType), rule.Increment, rule.Percent).Scan(&rule.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadProgramDetails fills in weeks, days and rules for a batch of programs
+func loadProgramDetails(q queryer, programs []*Program) error {
+	if len(programs) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(programs))
+	byID := make(map[int]*Program, len(programs))
+	for i, program := range programs {
+		ids[i] = int64(program.ID)
+		byID[program.ID] = program
+		program.Weeks = []ProgramWeek{}
+		program.Rules = []ProgressionRule{}
+	}
+
+	query := `
+	SELECT pw.program_id, pw.id, pw.week_number, pw.deload, pd.id, pd.day_number, pd.template_id
+	FROM program_weeks pw
+	LEFT JOIN program_days pd ON pd.program_week_id = pw.id
+	WHERE pw.program_id = ANY($1)
+	ORDER BY pw.program_id, pw.week_number, pd.day_number
+	`
+
+	rows, err := q.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var programID int
+		var week ProgramWeek
+		var dayID, dayNumber, templateID sql.NullInt64
+		err = rows.Scan(&programID, &week.ID, &week.WeekNumber, &week.Deload, &dayID, &dayNumber, &templateID)
+		if err != nil {
+			return err
+		}
+
+		program := byID[programID]
+		if len(program.Weeks) == 0 || program.Weeks[len(program.Weeks)-1].ID != week.ID {
+			week.Days = []ProgramDay{}
+			program.Weeks = append(program.Weeks, week)
+		}
+		if dayID.Valid {
+			current := &program.Weeks[len(program.Weeks)-1]
+			current.Days = append(current.Days, ProgramDay{
+				ID:         int(dayID.Int64),
+				DayNumber:  int(dayNumber.Int64),
+				TemplateID: int(templateID.Int64),
+			})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	query = `
+	SELECT pr.program_id, pr.id, pr.exercise_id, e.name, pr.rule_type, pr.increment, pr.percent
+	FROM program_rules pr
+	JOIN exercises e ON e.id = pr.exercise_id
+	WHERE pr.program_id = ANY($1)
+	ORDER BY pr.program_id, pr.id
+	`
+
+	rules, err := q.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rules.Close()
+
+	for rules.Next() {
+		var programID int
+		var rule ProgressionRule
+		err = rules.Scan(&programID, &rule.ID, &rule.ExerciseID, &rule.ExerciseName, &rule.RuleType, &rule.Increment, &rule.Percent)
+		if err != nil {
+			return err
+		}
+		program := byID[programID]
+		program.Rules = append(program.Rules, rule)
+	}
+
+	return rules.Err()
+}
+
+// first returns the position of the program's first day
+func (p *Program) first() (int, int, bool) {
+	for _, week := range p.Weeks {
+		if len(week.Days) > 0 {
+			return week.WeekNumber, week.Days[0].DayNumber, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Day looks up a position in the program
+func (p *Program) Day(weekNumber, dayNumber int) (*ProgramWeek, *ProgramDay) {
+	for i := range p.Weeks {
+		week := &p.Weeks[i]
+		if week.WeekNumber != weekNumber {
+			continue
+		}
+		for j := range week.Days {
+			if week.Days[j].DayNumber == dayNumber {
+				return week, &week.Days[j]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// next returns the position after the given one, and false once the program
+// is over
+func (p *Program) next(weekNumber, dayNumber int) (int, int, bool) {
+	type position struct{ week, day int }
+	positions := []position{}
+	for _, week := range p.Weeks {
+		for _, day := range week.Days {
+			positions = append(positions, position{week.WeekNumber, day.DayNumber})
+		}
+	}
+	slices.SortFunc(positions, func(a, b position) int {
+		if a.week != b.week {
+			return a.week - b.week
+		}
+		return a.day - b.day
+	})
+
+	for _, pos := range positions {
+		if pos.week > weekNumber || (pos.week == weekNumber && pos.day > dayNumber) {
+			return pos.week, pos.day, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (p *Program) rule(exerciseID int) *ProgressionRule {
+	for i := range p.Rules {
+		if p.Rules[i].ExerciseID != nil && *p.Rules[i].ExerciseID == exerciseID {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// roundWeight rounds prescriptions to the nearest 2.5, the smallest jump most
+// gyms can load
+func roundWeight(weight float64) float64 {
+	return math.Round(weight/2.5) * 2.5
+}
+
+// Prescribe builds the workout for the enrollment's current day from that
+// day's template. On top of the template's own prefill, linear rules set the
+// working weight, percent_1rm rules take a share of the best estimated 1RM
+// and deload weeks scale everything down.
+func (p *Program) Prescribe(enrollment *Enrollment, template *WorkoutTemplate, last map[int]WorkoutEntry, oneRepMaxes map[int]float64) *Workout {
+	workout := template.NewWorkout(last)
+	enrollmentID := enrollment.ID
+	workout.EnrollmentID = &enrollmentID
+
+	week, _ := p.Day(enrollment.CurrentWeek, enrollment.CurrentDay)
+	deload := week != nil && week.Deload
+
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		if entry.ExerciseID == nil {
+			continue
+		}
+
+		var weight *float64
+		if rule := p.rule(*entry.ExerciseID); rule != nil {
+			switch rule.RuleType {
+			case RuleLinear:
+				if w, ok := enrollment.Weights[*entry.ExerciseID]; ok {
+					weight = &w
+				}
+			case RulePercentOneRepMax:
+				if oneRepMax, ok := oneRepMaxes[*entry.ExerciseID]; ok {
+					w := roundWeight(oneRepMax * *rule.Percent / 100)
+					weight = &w
+				}
+			}
+		}
+
+		for j := range entry.LoggedSets {
+			set := &entry.LoggedSets[j]
+			if weight != nil {
+				w := *weight
+				set.Weight = &w
+			}
+			if deload && set.Weight != nil {
+				w := roundWeight(*set.Weight * p.DeloadPercent / 100)
+				set.Weight = &w
+			}
+		}
+	}
+
+	return workout
+}
+
+// progress works out the new working weights after a logged workout. Only
+// exercises under a linear rule move: they go up by the increment when every
+// prescribed set was completed, and otherwise stay at what was lifted. Deload
+// weeks never move them.
+func (p *Program) progress(deload bool, targets []TemplateEntry, workout *Workout, weights map[int]float64) map[int]float64 {
+	updated := map[int]float64{}
+	for _, target := range targets {
+		rule := p.rule(*target.ExerciseID)
+		if rule == nil || rule.RuleType != RuleLinear || deload {
+			continue
+		}
+
+		completed := 0
+		lifted := 0.0
+		for _, entry := range workout.Entries {
+			if entry.ExerciseID == nil || *entry.ExerciseID != *target.ExerciseID {
+				continue
+			}
+			for _, set := range entry.LoggedSets {
+				if set.SetType == SetTypeWarmup {
+					continue
+				}
+				if set.Weight != nil {
+					lifted = max(lifted, *set.Weight)
+				}
+				switch {
+				case target.TargetRepsMin != nil && set.Reps != nil && *set.Reps >= *target.TargetRepsMin:
+					completed++
+				case target.TargetDurationSeconds != nil && set.DurationSeconds != nil && *set.DurationSeconds >= *target.TargetDurationSeconds:
+					completed++
+				}
+			}
+		}
+
+		if lifted == 0 {
+			if current, ok := weights[*target.ExerciseID]; ok {
+				lifted = current
+			} else {
+				continue
+			}
+		}
+
+		if completed >= target.TargetSets {
+			lifted += *rule.Increment
+		}
+		updated[*target.ExerciseID] = lifted
+	}
+
+	return updated
+}
+
+// advanceEnrollment moves the workout's enrollment past its current day and
+// applies the program's progression rules. It runs in the transaction that
+// created the workout, so a bad enrollment rolls the workout back too.
+func advanceEnrollment(tx *sql.Tx, workout *Workout) (*Enrollment, error) {
+	enrollment, err := getEnrollment(tx, int64(*workout.EnrollmentID), workout.UserID, true)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidEnrollment
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enrollment.IsCompleted() {
+		return nil, ErrInvalidEnrollment
+	}
+
+	program := &Program{}
+	query := `SELECT id, deload_percent FROM programs WHERE id = $1`
+	err = tx.QueryRow(query, enrollment.ProgramID).Scan(&program.ID, &program.DeloadPercent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadProgramDetails(tx, []*Program{program})
+	if err != nil {
+		return nil, err
+	}
+
+	week, day := program.Day(enrollment.CurrentWeek, enrollment.CurrentDay)
+	if day != nil {
+		targets, err := getTemplateTargets(tx, day.TemplateID)
+		if err != nil {
+			return nil, err
+		}
+
+		for exerciseID, weight := range program.progress(week.Deload, targets, workout, enrollment.Weights) {
+			query := `
+			INSERT INTO enrollment_weights (enrollment_id, exercise_id, weight)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (enrollment_id, exercise_id) DO UPDATE SET weight = EXCLUDED.weight
+			`
+			_, err = tx.Exec(query, enrollment.ID, exerciseID, weight)
+			if err != nil {
+				return nil, err
+			}
+			enrollment.Weights[exerciseID] = weight
+		}
+	}
+
+	nextWeek, nextDay, ok := program.next(enrollment.CurrentWeek, enrollment.CurrentDay)
+	if ok {
+		enrollment.CurrentWeek, enrollment.CurrentDay = nextWeek, nextDay
+		_, err = tx.Exec(`UPDATE program_enrollments SET current_week = $1, current_day = $2 WHERE id = $3`, nextWeek, nextDay, enrollment.ID)
+	} else {
+		now := time.Now()
+		enrollment.CompletedAt = &now
+		_, err = tx.Exec(`UPDATE program_enrollments SET completed_at = $1 WHERE id = $2`, now, enrollment.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return enrollment, nil
+}
+
+func getTemplateTargets(q queryer, templateID int) ([]TemplateEntry, error) {
+	query := `
+	SELECT exercise_id, target_sets, target_reps_min, target_duration_seconds
+	FROM template_entries
+	WHERE template_id = $1
+	ORDER BY order_index
+	`
+
+	rows, err := q.Query(query, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []TemplateEntry{}
+	for rows.Next() {
+		var target TemplateEntry
+		err = rows.Scan(&target.ExerciseID, &target.TargetSets, &target.TargetRepsMin, &target.TargetDurationSeconds)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, rows.Err()
+}
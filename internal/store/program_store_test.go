@@ -0,0 +1,180 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgramProgression(t *testing.T) {
+	squatID, benchID := 1, 2
+	program := &Program{
+		DeloadPercent: 60,
+		Weeks: []ProgramWeek{
+			{WeekNumber: 1, Days: []ProgramDay{{DayNumber: 1}, {DayNumber: 2}}},
+			{WeekNumber: 2, Deload: true, Days: []ProgramDay{{DayNumber: 1}}},
+		},
+		Rules: []ProgressionRule{
+			{ExerciseID: &squatID, RuleType: RuleLinear, Increment: testutil.FloatPtr(2.5)},
+			{ExerciseID: &benchID, RuleType: RulePercentOneRepMax, Percent: testutil.FloatPtr(75)},
+		},
+	}
+
+	t.Run("Positions advance through weeks and then finish", func(t *testing.T) {
+		week, day, ok := program.next(1, 1)
+		assert.True(t, ok)
+		assert.Equal(t, []int{1, 2}, []int{week, day})
+
+		week, day, ok = program.next(1, 2)
+		assert.True(t, ok)
+		assert.Equal(t, []int{2, 1}, []int{week, day})
+
+		_, _, ok = program.next(2, 1)
+		assert.False(t, ok)
+	})
+
+	targets := []TemplateEntry{
+		{ExerciseID: &squatID, TargetSets: 2, TargetRepsMin: testutil.IntPtr(5)},
+	}
+
+	t.Run("Completed linear sets add the increment", func(t *testing.T) {
+		workout := &Workout{Entries: []WorkoutEntry{{
+			ExerciseID: &squatID,
+			LoggedSets: []WorkoutSet{
+				{Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(100)},
+				{Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(100)},
+			},
+		}}}
+		weights := program.progress(false, targets, workout, map[int]float64{})
+		assert.Equal(t, map[int]float64{squatID: 102.5}, weights)
+	})
+
+	t.Run("Missed reps keep the weight", func(t *testing.T) {
+		workout := &Workout{Entries: []WorkoutEntry{{
+			ExerciseID: &squatID,
+			LoggedSets: []WorkoutSet{
+				{Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(100)},
+				{Reps: testutil.IntPtr(3), Weight: testutil.FloatPtr(100)},
+			},
+		}}}
+		weights := program.progress(false, targets, workout, map[int]float64{squatID: 100})
+		assert.Equal(t, map[int]float64{squatID: 100}, weights)
+	})
+
+	t.Run("Deload weeks don't progress", func(t *testing.T) {
+		workout := &Workout{Entries: []WorkoutEntry{{
+			ExerciseID: &squatID,
+			LoggedSets: []WorkoutSet{{Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(60)}, {Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(60)}},
+		}}}
+		assert.Empty(t, program.progress(true, targets, workout, map[int]float64{squatID: 100}))
+	})
+
+	t.Run("Prescriptions apply rules and deloads", func(t *testing.T) {
+		template := &WorkoutTemplate{
+			Entries: []TemplateEntry{
+				{ExerciseID: &squatID, TargetSets: 1, TargetRepsMin: testutil.IntPtr(5)},
+				{ExerciseID: &benchID, TargetSets: 1, TargetRepsMin: testutil.IntPtr(5)},
+			},
+		}
+		enrollment := &Enrollment{ID: 3, CurrentWeek: 1, CurrentDay: 1, Weights: map[int]float64{squatID: 102.5}}
+		oneRepMaxes := map[int]float64{benchID: 100}
+
+		workout := program.Prescribe(enrollment, template, nil, oneRepMaxes)
+		assert.Equal(t, 3, *workout.EnrollmentID)
+		assert.Equal(t, 102.5, *workout.Entries[0].LoggedSets[0].Weight)
+		assert.Equal(t, 75.0, *workout.Entries[1].LoggedSets[0].Weight)
+
+		enrollment.CurrentWeek = 2
+		workout = program.Prescribe(enrollment, template, nil, oneRepMaxes)
+		assert.Equal(t, 62.5, *workout.Entries[0].LoggedSets[0].Weight)
+		assert.Equal(t, 45.0, *workout.Entries[1].LoggedSets[0].Weight)
+	})
+
+	t.Run("Exercises without a rule get their own weight per set", func(t *testing.T) {
+		rowID := 3
+		template := &WorkoutTemplate{
+			Entries: []TemplateEntry{
+				{ExerciseID: &rowID, TargetSets: 2, TargetRepsMin: testutil.IntPtr(8), TargetWeight: testutil.FloatPtr(60)},
+			},
+		}
+		enrollment := &Enrollment{ID: 3, CurrentWeek: 1, CurrentDay: 1, Weights: map[int]float64{}}
+
+		sets := program.Prescribe(enrollment, template, nil, nil).Entries[0].LoggedSets
+		require.Len(t, sets, 2)
+		assert.Equal(t, 60.0, *sets[1].Weight)
+		assert.NotSame(t, sets[0].Weight, sets[1].Weight)
+		assert.NotSame(t, template.Entries[0].TargetWeight, sets[0].Weight)
+	})
+}
+
+func TestProgramEnrollment(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	programStore := NewPostgresProgramStore(db)
+	templateStore := NewPostgresTemplateStore(db)
+	workoutStore := NewPostgresWorkoutStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	template := &WorkoutTemplate{
+		UserID: testUser.ID,
+		Title:  "Squat day",
+		Entries: []TemplateEntry{
+			{ExerciseName: "Squat", TargetSets: 2, TargetRepsMin: testutil.IntPtr(5), TargetWeight: testutil.FloatPtr(100), OrderIndex: 1},
+		},
+	}
+	err = templateStore.CreateTemplate(template)
+	require.NoError(t, err)
+
+	program := &Program{
+		UserID:        testUser.ID,
+		Name:          "Linear",
+		DeloadPercent: 60,
+		Weeks: []ProgramWeek{
+			{Days: []ProgramDay{{TemplateID: template.ID}}},
+			{Deload: true, Days: []ProgramDay{{TemplateID: template.ID}}},
+		},
+		Rules: []ProgressionRule{
+			{ExerciseName: "Squat", RuleType: RuleLinear, Increment: testutil.FloatPtr(2.5)},
+		},
+	}
+	err = programStore.CreateProgram(program)
+	require.NoError(t, err)
+
+	enrollment, err := programStore.Enroll(int64(program.ID), testUser.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, enrollment.CurrentWeek)
+
+	_, err = programStore.Enroll(int64(program.ID), testUser.ID)
+	assert.ErrorIs(t, err, ErrAlreadyEnrolled)
+
+	workout := program.Prescribe(enrollment, template, nil, nil)
+	created, err := workoutStore.CreateWorkout(workout)
+	require.NoError(t, err)
+	require.NotNil(t, created.Enrollment)
+	assert.Equal(t, 2, created.Enrollment.CurrentWeek)
+	assert.Equal(t, 102.5, created.Enrollment.Weights[*template.Entries[0].ExerciseID])
+
+	enrollment, err = programStore.GetEnrollment(int64(enrollment.ID), testUser.ID)
+	require.NoError(t, err)
+	workout = program.Prescribe(enrollment, template, nil, nil)
+	created, err = workoutStore.CreateWorkout(workout)
+	require.NoError(t, err)
+	assert.True(t, created.Enrollment.IsCompleted())
+
+	_, err = workoutStore.CreateWorkout(program.Prescribe(enrollment, template, nil, nil))
+	assert.ErrorIs(t, err, ErrInvalidEnrollment)
+}
@@ -0,0 +1,332 @@
+package store
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+type RecordType string
+
+const (
+	RecordHeaviestWeight  RecordType = "heaviest_weight"
+	RecordMostReps        RecordType = "most_reps"
+	RecordEstimated1RM    RecordType = "estimated_1rm"
+	RecordLongestDuration RecordType = "longest_duration"
+)
+
+type PersonalRecord struct {
+	ID           int        `json:"id"`
+	ExerciseID   int        `json:"exercise_id"`
+	ExerciseName string     `json:"exercise_name"`
+	WorkoutID    int        `json:"workout_id"`
+	RecordType   RecordType `json:"record_type"`
+	Weight       *float64   `json:"weight,omitempty"`
+	Value        float64    `json:"value"`
+	AchievedAt   time.Time  `json:"achieved_at"`
+}
+
+type PostgresRecordStore struct {
+	db *sql.DB
+}
+
+func NewPostgresRecordStore(db *sql.DB) *PostgresRecordStore {
+	return &PostgresRecordStore{db: db}
+}
+
+type RecordStore interface {
+	ListPersonalRecords(userID int, exerciseID *int) ([]*PersonalRecord, error)
+}
+
+// ListPersonalRecords returns the user's standing records, one per exercise
+// and record type (and per weight for most_reps)
+func (pg *PostgresRecordStore) ListPersonalRecords(userID int, exerciseID *int) ([]*PersonalRecord, error) {
+	query := `
+	SELECT * FROM (
+		SELECT DISTINCT ON (pr.exercise_id, pr.record_type, pr.weight)
+			pr.id, pr.exercise_id, e.name, pr.workout_id, pr.record_type, pr.weight, pr.value, pr.achieved_at
+		FROM personal_records pr
+		JOIN exercises e ON e.id = pr.exercise_id
+		WHERE pr.user_id = $1 AND ($2::BIGINT IS NULL OR pr.exercise_id = $2)
+		ORDER BY pr.exercise_id, pr.record_type, pr.weight, pr.value DESC, pr.achieved_at
+	) records
+	ORDER BY lower(name), record_type, weight DESC NULLS FIRST
+	`
+
+	rows, err := pg.db.Query(query, userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*PersonalRecord{}
+	for rows.Next() {
+		var record PersonalRecord
+		err = rows.Scan(
+			&record.ID,
+			&record.ExerciseID,
+			&record.ExerciseName,
+			&record.WorkoutID,
+			&record.RecordType,
+			&record.Weight,
+			&record.Value,
+			&record.AchievedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+
+	return records, rows.Err()
+}
+
+// EstimateOneRepMax uses Brzycki up to 10 reps, where it's the more accurate
+// of the two, and Epley above that since Brzycki falls apart as reps approach
+// 37
+func EstimateOneRepMax(weight float64, reps int) float64 {
+	switch {
+	case reps <= 0:
+		return 0
+	case reps == 1:
+		return weight
+	case reps <= 10:
+		return weight * 36 / float64(37-reps)
+	default:
+		return weight * (1 + float64(reps)/30)
+	}
+}
+
+type recordKey struct {
+	exerciseID int
+	recordType RecordType
+	weight     float64
+	hasWeight  bool
+}
+
+// recordCandidates picks the best value this workout reached for each record
+// key. Warm-up sets don't count.
+func recordCandidates(workout *Workout) map[recordKey]*PersonalRecord {
+	candidates := map[recordKey]*PersonalRecord{}
+	consider := func(key recordKey, entry *WorkoutEntry, value float64) {
+		value = math.Round(value*100) / 100
+		if current, ok := candidates[key]; ok && current.Value >= value {
+			return
+		}
+		record := &PersonalRecord{
+			ExerciseID:   key.exerciseID,
+			ExerciseName: entry.ExerciseName,
+			WorkoutID:    workout.ID,
+			RecordType:   key.recordType,
+			Value:        value,
+			AchievedAt:   workout.PerformedAt,
+		}
+		if key.hasWeight {
+			weight := key.weight
+			record.Weight = &weight
+		}
+		candidates[key] = record
+	}
+
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		if entry.ExerciseID == nil {
+			continue
+		}
+		exerciseID := *entry.ExerciseID
+
+		for _, set := range entry.LoggedSets {
+			if set.SetType == SetTypeWarmup {
+				continue
+			}
+
+			if set.DurationSeconds != nil {
+				consider(recordKey{exerciseID: exerciseID, recordType: RecordLongestDuration}, entry, float64(*set.DurationSeconds))
+				continue
+			}
+
+			if set.Reps == nil || *set.Reps <= 0 {
+				continue
+			}
+
+			// Bodyweight sets without a weight still track most reps
+			repsKey := recordKey{exerciseID: exerciseID, recordType: RecordMostReps}
+			if set.Weight != nil {
+				repsKey.weight, repsKey.hasWeight = *set.Weight, true
+			}
+			consider(repsKey, entry, float64(*set.Reps))
+
+			if set.Weight == nil || *set.Weight <= 0 {
+				continue
+			}
+			consider(recordKey{exerciseID: exerciseID, recordType: RecordHeaviestWeight}, entry, *set.Weight)
+			consider(recordKey{exerciseID: exerciseID, recordType: RecordEstimated1RM}, entry, EstimateOneRepMax(*set.Weight, *set.Reps))
+		}
+	}
+
+	return candidates
+}
+
+// detectRecords stores every record a new workout beats and returns them. It
+// runs in the same transaction that wrote the workout.
+func detectRecords(tx *sql.Tx, workout *Workout) ([]PersonalRecord, error) {
+	var err error
+	records := []PersonalRecord{}
+	for key, candidate := range recordCandidates(workout) {
+		var best sql.NullFloat64
+		query := `
+		SELECT MAX(value)
+		FROM personal_records
+		WHERE user_id = $1 AND exercise_id = $2 AND record_type = $3 AND weight IS NOT DISTINCT FROM $4
+		`
+		err = tx.QueryRow(query, workout.UserID, key.exerciseID, string(key.recordType), candidate.Weight).Scan(&best)
+		if err != nil {
+			return nil, fmt.Errorf("personal record lookup: %w", err)
+		}
+
+		if best.Valid && candidate.Value <= best.Float64 {
+			continue
+		}
+
+		query = `
+		INSERT INTO personal_records (user_id, exercise_id, workout_id, record_type, weight, value, achieved_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+		`
+		err = tx.QueryRow(query,
+			workout.UserID,
+			candidate.ExerciseID,
+			candidate.WorkoutID,
+			string(candidate.RecordType),
+			candidate.Weight,
+			candidate.Value,
+			candidate.AchievedAt,
+		).Scan(&candidate.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, *candidate)
+	}
+
+	sortRecords(records)
+	return records, nil
+}
+
+// recomputeRecords rebuilds the user's records for the given exercises by
+// replaying every workout that logged them, oldest first. Editing or deleting a
+// workout can change which of the others set a record, so dropping only its own
+// records isn't enough. It returns the records the replay stored.
+func recomputeRecords(tx *sql.Tx, userID int, exerciseIDs []int) ([]PersonalRecord, error) {
+	records := []PersonalRecord{}
+	for _, exerciseID := range exerciseIDs {
+		_, err := tx.Exec(`DELETE FROM personal_records WHERE user_id = $1 AND exercise_id = $2`, userID, exerciseID)
+		if err != nil {
+			return nil, err
+		}
+
+		workouts, err := exerciseHistory(tx, userID, exerciseID)
+		if err != nil {
+			return nil, err
+		}
+
+		best := map[recordKey]float64{}
+		for _, workout := range workouts {
+			for key, candidate := range recordCandidates(workout) {
+				if current, ok := best[key]; ok && candidate.Value <= current {
+					continue
+				}
+				best[key] = candidate.Value
+
+				query := `
+				INSERT INTO personal_records (user_id, exercise_id, workout_id, record_type, weight, value, achieved_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				RETURNING id
+				`
+				err = tx.QueryRow(query,
+					userID,
+					candidate.ExerciseID,
+					candidate.WorkoutID,
+					string(candidate.RecordType),
+					candidate.Weight,
+					candidate.Value,
+					candidate.AchievedAt,
+				).Scan(&candidate.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				records = append(records, *candidate)
+			}
+		}
+	}
+
+	sortRecords(records)
+	return records, nil
+}
+
+// exerciseHistory loads the user's workouts that logged the exercise, oldest
+// first, with only that exercise's entries and sets filled in
+func exerciseHistory(tx *sql.Tx, userID, exerciseID int) ([]*Workout, error) {
+	query := `
+	SELECT w.id, w.performed_at, we.id, we.exercise_name, s.set_type, s.reps, s.duration_seconds, s.weight
+	FROM workouts w
+	JOIN workout_entries we ON we.workout_id = w.id
+	JOIN workout_sets s ON s.workout_entry_id = we.id
+	WHERE w.user_id = $1 AND we.exercise_id = $2
+	ORDER BY w.performed_at, w.id, we.order_index, we.id, s.set_number
+	`
+
+	rows, err := tx.Query(query, userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workouts := []*Workout{}
+	var workout *Workout
+	var entry *WorkoutEntry
+	for rows.Next() {
+		var workoutID, entryID int
+		var performedAt time.Time
+		var exerciseName string
+		var set WorkoutSet
+		err = rows.Scan(&workoutID, &performedAt, &entryID, &exerciseName, &set.SetType, &set.Reps, &set.DurationSeconds, &set.Weight)
+		if err != nil {
+			return nil, err
+		}
+
+		if workout == nil || workout.ID != workoutID {
+			workout = &Workout{ID: workoutID, UserID: userID, PerformedAt: performedAt}
+			workouts = append(workouts, workout)
+			entry = nil
+		}
+		if entry == nil || entry.ID != entryID {
+			workout.Entries = append(workout.Entries, WorkoutEntry{ID: entryID, ExerciseID: &exerciseID, ExerciseName: exerciseName})
+			entry = &workout.Entries[len(workout.Entries)-1]
+		}
+		entry.LoggedSets = append(entry.LoggedSets, set)
+	}
+
+	return workouts, rows.Err()
+}
+
+func sortRecords(records []PersonalRecord) {
+	slices.SortFunc(records, func(a, b PersonalRecord) int {
+		return cmp.Or(
+			cmp.Compare(a.ExerciseID, b.ExerciseID),
+			cmp.Compare(a.RecordType, b.RecordType),
+			cmp.Compare(valueOr(a.Weight), valueOr(b.Weight)),
+		)
+	})
+}
+
+func valueOr(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateOneRepMax(t *testing.T) {
+	assert.Equal(t, 100.0, EstimateOneRepMax(100, 1))
+	assert.InDelta(t, 116.13, EstimateOneRepMax(100, 6), 0.01) // Brzycki
+	assert.InDelta(t, 140.0, EstimateOneRepMax(100, 12), 0.01) // Epley
+	assert.Equal(t, 0.0, EstimateOneRepMax(100, 0))
+}
+
+func TestDetectRecords(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	workoutStore := NewPostgresWorkoutStore(db)
+	recordStore := NewPostgresRecordStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	first, err := workoutStore.CreateWorkout(&Workout{
+		UserID:          testUser.ID,
+		Title:           "Week 1",
+		DurationMinutes: 60,
+		Entries: []WorkoutEntry{
+			{
+				ExerciseName: "Squat",
+				OrderIndex:   1,
+				LoggedSets: []WorkoutSet{
+					{SetType: SetTypeWarmup, Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(200)},
+					{Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(100)},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("First workout sets every record except warm-ups", func(t *testing.T) {
+		types := map[RecordType]float64{}
+		for _, record := range first.NewRecords {
+			types[record.RecordType] = record.Value
+		}
+		assert.Len(t, first.NewRecords, 3)
+		assert.Equal(t, 100.0, types[RecordHeaviestWeight])
+		assert.Equal(t, 5.0, types[RecordMostReps])
+	})
+
+	second, err := workoutStore.CreateWorkout(&Workout{
+		UserID:          testUser.ID,
+		Title:           "Week 2",
+		DurationMinutes: 60,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Squat", Sets: 3, Reps: testutil.IntPtr(3), Weight: testutil.FloatPtr(100), OrderIndex: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("Weaker workout sets no records", func(t *testing.T) {
+		assert.Empty(t, second.NewRecords)
+	})
+
+	third, err := workoutStore.CreateWorkout(&Workout{
+		UserID:          testUser.ID,
+		Title:           "Week 3",
+		DurationMinutes: 60,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Squat", Sets: 3, Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(105), OrderIndex: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("Standing records point at the workout that set them", func(t *testing.T) {
+		records, err := recordStore.ListPersonalRecords(testUser.ID, nil)
+		require.NoError(t, err)
+
+		// heaviest weight, estimated 1RM and most reps at 100 and 105
+		require.Len(t, records, 4)
+		for _, record := range records {
+			if record.RecordType == RecordMostReps && *record.Weight == 100 {
+				assert.Equal(t, first.ID, record.WorkoutID)
+				continue
+			}
+			assert.Equal(t, third.ID, record.WorkoutID)
+		}
+	})
+
+	standing := func() map[string]int {
+		records, err := recordStore.ListPersonalRecords(testUser.ID, nil)
+		require.NoError(t, err)
+
+		byKey := map[string]int{}
+		for _, record := range records {
+			key := string(record.RecordType)
+			if record.Weight != nil {
+				key += fmt.Sprintf("@%g", *record.Weight)
+			}
+			byKey[key] = record.WorkoutID
+		}
+		return byKey
+	}
+
+	t.Run("Editing a workout hands the records it lost to the next best", func(t *testing.T) {
+		first.Entries = []WorkoutEntry{
+			{ExerciseName: "Squat", Sets: 1, Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(90), OrderIndex: 1},
+		}
+		err := workoutStore.UpdateWorkout(first)
+		require.NoError(t, err)
+
+		// It was still the best at the time, but only holds the reps record at
+		// its new weight now
+		assert.Len(t, first.NewRecords, 3)
+		assert.Equal(t, map[string]int{
+			"heaviest_weight": third.ID,
+			"estimated_1rm":   third.ID,
+			"most_reps@90":    first.ID,
+			"most_reps@100":   second.ID,
+			"most_reps@105":   third.ID,
+		}, standing())
+	})
+
+	t.Run("Deleting a workout hands its records to the next best", func(t *testing.T) {
+		err := workoutStore.DeleteWorkout(int64(third.ID))
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]int{
+			"heaviest_weight": second.ID,
+			"estimated_1rm":   second.ID,
+			"most_reps@90":    first.ID,
+			"most_reps@100":   second.ID,
+		}, standing())
+	})
+}
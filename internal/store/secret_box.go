@@ -0,0 +1,112 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SecretKey is an AES-256 key for a SecretBox. Its ID is stored with every
+// value it seals, so values sealed with an older key can still be opened.
+type SecretKey struct {
+	ID  string
+	Key []byte
+}
+
+// SecretBox seals secrets the store has to read back, like TOTP secrets, with
+// AES-256-GCM. It seals with its first key and opens with any of them.
+// Rotating means putting a new key first; values sealed with the old key keep
+// working until they're rewritten.
+type SecretBox struct {
+	keys []SecretKey
+}
+
+// sealedPrefix starts every sealed value. TOTP secrets are base32, so they
+// never contain the colon and can't be mistaken for one.
+const sealedPrefix = "sealed:"
+
+var ErrUnsealable = errors.New("secret can't be opened with any of the keys")
+
+func NewSecretBox(keys ...SecretKey) (*SecretBox, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("secret box needs at least one key")
+	}
+
+	for _, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, ":") || len(key.Key) != 32 {
+			return nil, fmt.Errorf("invalid secret key %q", key.ID)
+		}
+	}
+
+	return &SecretBox{keys: keys}, nil
+}
+
+// Sealed tells sealed values apart from plaintext written before sealing
+func Sealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Seal encrypts plaintext. The value can only be opened with the same
+// context, which ties it to the row it's stored in.
+func (b *SecretBox) Seal(plaintext, context string) (string, error) {
+	key := b.keys[0]
+	aead, err := newAEAD(key.Key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return sealedPrefix + key.ID + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (b *SecretBox) Open(value, context string) (string, error) {
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(value, sealedPrefix), ":")
+	if !Sealed(value) || !ok {
+		return "", ErrUnsealable
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrUnsealable
+	}
+
+	for _, key := range b.keys {
+		if key.ID != keyID {
+			continue
+		}
+
+		aead, err := newAEAD(key.Key)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return "", ErrUnsealable
+		}
+
+		plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(context))
+		if err != nil {
+			return "", ErrUnsealable
+		}
+		return string(plaintext), nil
+	}
+
+	return "", ErrUnsealable
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
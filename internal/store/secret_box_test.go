@@ -0,0 +1,48 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretBox(t *testing.T) {
+	oldKey := SecretKey{ID: "old", Key: bytes.Repeat([]byte{1}, 32)}
+	newKey := SecretKey{ID: "new", Key: bytes.Repeat([]byte{2}, 32)}
+
+	_, err := NewSecretBox()
+	assert.Error(t, err)
+	_, err = NewSecretBox(SecretKey{ID: "short", Key: []byte("too short")})
+	assert.Error(t, err)
+
+	oldBox, err := NewSecretBox(oldKey)
+	require.NoError(t, err)
+
+	sealed, err := oldBox.Seal("JBSWY3DPEHPK3PXP", "totp:1")
+	require.NoError(t, err)
+	assert.True(t, Sealed(sealed))
+	assert.False(t, Sealed("JBSWY3DPEHPK3PXP"))
+	assert.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+
+	opened, err := oldBox.Open(sealed, "totp:1")
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", opened)
+
+	// A value copied to another user's row doesn't open
+	_, err = oldBox.Open(sealed, "totp:2")
+	assert.ErrorIs(t, err, ErrUnsealable)
+
+	// After rotating, old values still open and new ones use the new key
+	rotated, err := NewSecretBox(newKey, oldKey)
+	require.NoError(t, err)
+	opened, err = rotated.Open(sealed, "totp:1")
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", opened)
+
+	resealed, err := rotated.Seal("JBSWY3DPEHPK3PXP", "totp:1")
+	require.NoError(t, err)
+	_, err = oldBox.Open(resealed, "totp:1")
+	assert.ErrorIs(t, err, ErrUnsealable)
+}
@@ -0,0 +1,367 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+type WorkoutTemplate struct {
+	ID          int             `json:"id"`
+	UserID      int             `json:"user_id"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Entries     []TemplateEntry `json:"entries"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+type TemplateEntry struct {
+	ID                    int      `json:"id"`
+	ExerciseID            *int     `json:"exercise_id"`
+	ExerciseName          string   `json:"exercise_name"`
+	TargetSets            int      `json:"target_sets"`
+	TargetRepsMin         *int     `json:"target_reps_min"`
+	TargetRepsMax         *int     `json:"target_reps_max"`
+	TargetDurationSeconds *int     `json:"target_duration_seconds"`
+	TargetWeight          *float64 `json:"target_weight"`
+	Notes                 string   `json:"notes"`
+	OrderIndex            int      `json:"order_index"`
+}
+
+type PostgresTemplateStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTemplateStore(db *sql.DB) *PostgresTemplateStore {
+	return &PostgresTemplateStore{db: db}
+}
+
+type TemplateStore interface {
+	CreateTemplate(*WorkoutTemplate) error
+	GetTemplateByID(id int64, userID int) (*WorkoutTemplate, error)
+	ListTemplates(userID int) ([]*WorkoutTemplate, error)
+	UpdateTemplate(*WorkoutTemplate) error
+	DeleteTemplate(id int64, userID int) error
+}
+
+func (pg *PostgresTemplateStore) CreateTemplate(template *WorkoutTemplate) (err error) {
+	defer translate(&err)
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO workout_templates (user_id, title, description)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(query, template.UserID, template.Title, template.Description).Scan(&template.ID, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertTemplateEntries(tx, template)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PostgresTemplateStore) GetTemplateByID(id int64, userID int) (*WorkoutTemplate, error) {
+	template := &WorkoutTemplate{}
+	var description sql.NullString
+	query := `
+	SELECT id, user_id, title, description, created_at, updated_at
+	FROM workout_templates
+	WHERE id = $1 AND user_id = $2
+	`
+	err := pg.db.QueryRow(query, id, userID).Scan(
+		&template.ID,
+		&template.UserID,
+		&template.Title,
+		&description,
+		&template.CreatedAt,
+		&template.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	template.Description = description.String
+
+	err = pg.loadTemplateEntries([]*WorkoutTemplate{template})
+	if err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+func (pg *PostgresTemplateStore) ListTemplates(userID int) ([]*WorkoutTemplate, error) {
+	query := `
+	SELECT id, user_id, title, description, created_at, updated_at
+	FROM workout_templates
+	WHERE user_id = $1
+	ORDER BY lower(title), id
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*WorkoutTemplate{}
+	for rows.Next() {
+		var template WorkoutTemplate
+		var description sql.NullString
+		err = rows.Scan(
+			&template.ID,
+			&template.UserID,
+			&template.Title,
+			&description,
+			&template.CreatedAt,
+			&template.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		template.Description = description.String
+		templates = append(templates, &template)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = pg.loadTemplateEntries(templates)
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+func (pg *PostgresTemplateStore) UpdateTemplate(template *WorkoutTemplate) (err error) {
+	defer translate(&err)
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE workout_templates
+	SET title = $1, description = $2, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $3
+	RETURNING updated_at
+	`
+	err = tx.QueryRow(query, template.Title, template.Description, template.ID).Scan(&template.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM template_entries WHERE template_id = $1`, template.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertTemplateEntries(tx, template)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteTemplate leaves it to the program_days foreign key to refuse templates
+// a program still uses. Matching on the owner first means someone else's
+// template is not found rather than in use.
+func (pg *PostgresTemplateStore) DeleteTemplate(id int64, userID int) error {
+	result, err := pg.db.Exec(`DELETE FROM workout_templates WHERE id = $1 AND user_id = $2`, id, userID)
+	if isCode(err, codeForeignKeyViolation) {
+		return ErrTemplateInUse
+	}
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func insertTemplateEntries(tx *sql.Tx, template *WorkoutTemplate) error {
+	for i := range template.Entries {
+		entry := &template.Entries[i]
+		exerciseID, name, err := resolveExercise(tx, template.UserID, entry.ExerciseID, entry.ExerciseName)
+		if err != nil {
+			return err
+		}
+		entry.ExerciseID, entry.ExerciseName = &exerciseID, name
+
+		query := `
+		INSERT INTO template_entries (template_id, exercise_id, exercise_name, target_sets, target_reps_min, target_reps_max, target_duration_seconds, target_weight, notes, order_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+		`
+		err = tx.QueryRow(query,
+			template.ID,
+			entry.ExerciseID,
+			entry.ExerciseName,
+			entry.TargetSets,
+			entry.TargetRepsMin,
+			entry.TargetRepsMax,
+			entry.TargetDurationSeconds,
+			entry.TargetWeight,
+			entry.Notes,
+			entry.OrderIndex,
+		).Scan(&entry.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pg *PostgresTemplateStore) loadTemplateEntries(templates []*WorkoutTemplate) error {
+	if len(templates) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(templates))
+	byID := make(map[int]*WorkoutTemplate, len(templates))
+	for i, template := range templates {
+		ids[i] = int64(template.ID)
+		byID[template.ID] = template
+		template.Entries = []TemplateEntry{}
+	}
+
+	query := `
+	SELECT template_id, id, exercise_id, exercise_name, target_sets, target_reps_min, target_reps_max, target_duration_seconds, target_weight, notes, order_index
+	FROM template_entries
+	WHERE template_id = ANY($1)
+	ORDER BY template_id, order_index
+	`
+
+	rows, err := pg.db.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var templateID int
+		var entry TemplateEntry
+		var notes sql.NullString
+		err = rows.Scan(
+			&templateID,
+			&entry.ID,
+			&entry.ExerciseID,
+			&entry.ExerciseName,
+			&entry.TargetSets,
+			&entry.TargetRepsMin,
+			&entry.TargetRepsMax,
+			&entry.TargetDurationSeconds,
+			&entry.TargetWeight,
+			&notes,
+			&entry.OrderIndex,
+		)
+		if err != nil {
+			return err
+		}
+		entry.Notes = notes.String
+		template := byID[templateID]
+		template.Entries = append(template.Entries, entry)
+	}
+
+	return rows.Err()
+}
+
+// NewWorkout turns the template into a workout for today. Each entry gets its
+// target number of working sets. Weights come from the template when it has
+// one and from the last time the exercise was logged otherwise. Reps and
+// durations also come from the last time, with reps kept inside the
+// template's range.
+func (t *WorkoutTemplate) NewWorkout(last map[int]WorkoutEntry) *Workout {
+	templateID := t.ID
+	workout := &Workout{
+		UserID:      t.UserID,
+		TemplateID:  &templateID,
+		Title:       t.Title,
+		Description: t.Description,
+		PerformedAt: time.Now(),
+		Entries:     make([]WorkoutEntry, 0, len(t.Entries)),
+	}
+
+	for _, templateEntry := range t.Entries {
+		entry := WorkoutEntry{
+			ExerciseID:   templateEntry.ExerciseID,
+			ExerciseName: templateEntry.ExerciseName,
+			Notes:        templateEntry.Notes,
+			OrderIndex:   templateEntry.OrderIndex,
+			LoggedSets:   make([]WorkoutSet, 0, templateEntry.TargetSets),
+		}
+
+		var previous []WorkoutSet
+		if templateEntry.ExerciseID != nil {
+			for _, set := range last[*templateEntry.ExerciseID].LoggedSets {
+				if set.SetType != SetTypeWarmup {
+					previous = append(previous, set)
+				}
+			}
+		}
+
+		for i := range templateEntry.TargetSets {
+			set := WorkoutSet{
+				SetNumber:       i + 1,
+				SetType:         SetTypeWorking,
+				Reps:            templateEntry.TargetRepsMin,
+				DurationSeconds: templateEntry.TargetDurationSeconds,
+			}
+
+			// Weights are copied per set so converting one set's weight
+			// leaves the template and the other sets alone
+			weight := templateEntry.TargetWeight
+			if len(previous) > 0 {
+				before := previous[min(i, len(previous)-1)]
+				if weight == nil {
+					weight = before.Weight
+				}
+				if set.Reps != nil && before.Reps != nil {
+					reps := max(*before.Reps, *templateEntry.TargetRepsMin)
+					if templateEntry.TargetRepsMax != nil {
+						reps = min(reps, *templateEntry.TargetRepsMax)
+					}
+					set.Reps = &reps
+				}
+				if set.DurationSeconds != nil && before.DurationSeconds != nil {
+					set.DurationSeconds = before.DurationSeconds
+				}
+			}
+			if weight != nil {
+				w := *weight
+				set.Weight = &w
+			}
+
+			entry.LoggedSets = append(entry.LoggedSets, set)
+		}
+
+		workout.Entries = append(workout.Entries, entry)
+	}
+
+	return workout
+}
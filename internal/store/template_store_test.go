@@ -0,0 +1,115 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateNewWorkout(t *testing.T) {
+	squatID, plankID := 1, 2
+	template := &WorkoutTemplate{
+		ID:     10,
+		UserID: 7,
+		Title:  "Leg day",
+		Entries: []TemplateEntry{
+			{ExerciseID: &squatID, ExerciseName: "Squat", TargetSets: 3, TargetRepsMin: testutil.IntPtr(5), TargetRepsMax: testutil.IntPtr(8), OrderIndex: 1},
+			{ExerciseID: &plankID, ExerciseName: "Plank", TargetSets: 2, TargetDurationSeconds: testutil.IntPtr(45), TargetWeight: testutil.FloatPtr(10), OrderIndex: 2},
+		},
+	}
+
+	last := map[int]WorkoutEntry{
+		squatID: {
+			LoggedSets: []WorkoutSet{
+				{SetType: SetTypeWarmup, Reps: testutil.IntPtr(10), Weight: testutil.FloatPtr(60)},
+				{Reps: testutil.IntPtr(10), Weight: testutil.FloatPtr(100)},
+				{Reps: testutil.IntPtr(6), Weight: testutil.FloatPtr(105)},
+			},
+		},
+		plankID: {
+			LoggedSets: []WorkoutSet{{DurationSeconds: testutil.IntPtr(60)}},
+		},
+	}
+
+	workout := template.NewWorkout(last)
+	require.Len(t, workout.Entries, 2)
+	assert.Equal(t, 10, *workout.TemplateID)
+	assert.Equal(t, 7, workout.UserID)
+
+	squat := workout.Entries[0].LoggedSets
+	require.Len(t, squat, 3)
+	// Warm-ups are skipped, reps are clamped to the range and the last
+	// working set carries over to any extra sets
+	assert.Equal(t, 8, *squat[0].Reps)
+	assert.Equal(t, 100.0, *squat[0].Weight)
+	assert.Equal(t, 6, *squat[1].Reps)
+	assert.Equal(t, 105.0, *squat[2].Weight)
+	assert.NotSame(t, squat[1].Weight, squat[2].Weight)
+
+	plank := workout.Entries[1].LoggedSets
+	require.Len(t, plank, 2)
+	assert.Equal(t, 60, *plank[1].DurationSeconds)
+	assert.Equal(t, 10.0, *plank[1].Weight)
+	assert.NotSame(t, plank[0].Weight, plank[1].Weight)
+	assert.NotSame(t, template.Entries[1].TargetWeight, plank[0].Weight)
+}
+
+func TestTemplateStore(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	templateStore := NewPostgresTemplateStore(db)
+	workoutStore := NewPostgresWorkoutStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	template := &WorkoutTemplate{
+		UserID: testUser.ID,
+		Title:  "Push day",
+		Entries: []TemplateEntry{
+			{ExerciseName: "Bench press", TargetSets: 3, TargetRepsMin: testutil.IntPtr(8), TargetRepsMax: testutil.IntPtr(12), OrderIndex: 1},
+		},
+	}
+	err = templateStore.CreateTemplate(template)
+	require.NoError(t, err)
+
+	retrieved, err := templateStore.GetTemplateByID(int64(template.ID), testUser.ID)
+	require.NoError(t, err)
+	require.Len(t, retrieved.Entries, 1)
+	assert.Equal(t, *template.Entries[0].ExerciseID, *retrieved.Entries[0].ExerciseID)
+
+	workout, err := workoutStore.CreateWorkout(retrieved.NewWorkout(nil))
+	require.NoError(t, err)
+	assert.Equal(t, template.ID, *workout.TemplateID)
+	assert.Equal(t, 3, workout.Entries[0].Sets)
+
+	err = templateStore.DeleteTemplate(int64(template.ID), testUser.ID+1)
+	assert.Error(t, err)
+
+	program := &Program{
+		UserID: testUser.ID,
+		Name:   "Push only",
+		Weeks:  []ProgramWeek{{Days: []ProgramDay{{TemplateID: template.ID}}}},
+	}
+	err = NewPostgresProgramStore(db).CreateProgram(program)
+	require.NoError(t, err)
+
+	// Someone else's template is not found, whether or not it's in use
+	err = templateStore.DeleteTemplate(int64(template.ID), testUser.ID+1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = templateStore.DeleteTemplate(int64(template.ID), testUser.ID)
+	assert.ErrorIs(t, err, ErrTemplateInUse)
+}
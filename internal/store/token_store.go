@@ -1,12 +1,26 @@
 package store
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/gonstoll/workouts/internal/tokens"
 )
 
+var (
+	ErrInvalidToken = errors.New("token is invalid or expired")
+	ErrTokenReused  = errors.New("refresh token was already used")
+)
+
+// TokenPair is what a login or a refresh hands out: a short-lived access token
+// and the refresh token that replaces it
+type TokenPair struct {
+	Access  *tokens.Token `json:"auth_token"`
+	Refresh *tokens.Token `json:"refresh_token"`
+}
+
 type PostgresTokenStore struct {
 	db *sql.DB
 }
@@ -20,17 +34,34 @@ func NewPostgresTokenStore(db *sql.DB) *PostgresTokenStore {
 type TokenStore interface {
 	Insert(token *tokens.Token) error
 	CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error)
+	CreateTokenPair(userID int, accessTTL, refreshTTL time.Duration) (*TokenPair, error)
+	RotateRefreshToken(refreshPlainText string, accessTTL, refreshTTL time.Duration) (*TokenPair, error)
 	DeleteAllTokensForUser(userID int, scope string) error
+	DeleteOtherSessions(userID int, familyID string) error
+	GetTokenFamily(tokenPlainText, scope string) (string, error)
+	DeleteToken(tokenPlainText, scope string) error
+	DeleteTokenByID(id int64, userID int, scope string) error
+	ListTokens(userID int, scope string) ([]*tokens.Token, error)
+	EachToken(userID int, fn func(*tokens.Token) error) error
 }
 
 func (pg *PostgresTokenStore) Insert(token *tokens.Token) error {
+	return insertToken(pg.db, token)
+}
+
+func insertToken(q queryer, token *tokens.Token) error {
+	var familyID *string
+	if token.FamilyID != "" {
+		familyID = &token.FamilyID
+	}
+
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO tokens (hash, user_id, expiry, scope, family_id, parent_id)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
 	`
 
-	_, err := pg.db.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope)
-	return err
+	return q.QueryRow(query, token.Hash, token.UserID, token.Expiry, token.Scope, familyID, token.ParentID).Scan(&token.ID, &token.CreatedAt)
 }
 
 func (pg *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
@@ -43,12 +74,257 @@ func (pg *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scop
 	return token, err
 }
 
+// CreateTokenPair starts a new token family for a login
+func (pg *PostgresTokenStore) CreateTokenPair(userID int, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+	familyID, err := tokens.NewFamily()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pair, err := insertTokenPair(tx, userID, familyID, nil, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, tx.Commit()
+}
+
+// RotateRefreshToken trades a refresh token for a new pair in the same family.
+// Each refresh token works once: presenting one that was already used means it
+// leaked, so the whole family is revoked and ErrTokenReused returned.
+func (pg *PostgresTokenStore) RotateRefreshToken(refreshPlainText string, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+	tokenHash := sha256.Sum256([]byte(refreshPlainText))
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id, userID int
+	var familyID sql.NullString
+	var expiry time.Time
+	var usedAt sql.NullTime
+	query := `
+	SELECT id, user_id, family_id, expiry, used_at
+	FROM tokens
+	WHERE hash = $1 AND scope = $2
+	FOR UPDATE
+	`
+	err = tx.QueryRow(query, tokenHash[:], tokens.ScopeRefresh).Scan(&id, &userID, &familyID, &expiry, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		_, err = tx.Exec(`DELETE FROM tokens WHERE family_id = $1`, familyID.String)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+
+	if !expiry.After(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+
+	_, err = tx.Exec(`UPDATE tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := insertTokenPair(tx, userID, familyID.String, &id, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, tx.Commit()
+}
+
+func insertTokenPair(q queryer, userID int, familyID string, parentID *int, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+	access, err := insertFamilyToken(q, userID, accessTTL, tokens.ScopeAuth, familyID, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := insertFamilyToken(q, userID, refreshTTL, tokens.ScopeRefresh, familyID, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{Access: access, Refresh: refresh}, nil
+}
+
+func insertFamilyToken(q queryer, userID int, ttl time.Duration, scope, familyID string, parentID *int) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.FamilyID = familyID
+	token.ParentID = parentID
+
+	err = insertToken(q, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
 func (pg *PostgresTokenStore) DeleteAllTokensForUser(userID int, scope string) error {
 	query := `
 	DELETE FROM tokens
-	WHERE scope = $1 AND user_id $2
+	WHERE scope = $1 AND user_id = $2
 	`
 
 	_, err := pg.db.Exec(query, scope, userID)
 	return err
 }
+
+// DeleteOtherSessions logs the user out everywhere except the session familyID
+// belongs to
+func (pg *PostgresTokenStore) DeleteOtherSessions(userID int, familyID string) error {
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $1 AND scope IN ($2, $3) AND family_id IS DISTINCT FROM $4
+	`
+
+	_, err := pg.db.Exec(query, userID, tokens.ScopeAuth, tokens.ScopeRefresh, familyID)
+	return err
+}
+
+// GetTokenFamily returns the family of an unexpired token, or an empty string
+// when there's no such token or it predates token families
+func (pg *PostgresTokenStore) GetTokenFamily(tokenPlainText, scope string) (string, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+	SELECT COALESCE(family_id, '')
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > $3
+	`
+
+	var familyID string
+	err := pg.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(&familyID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return familyID, err
+}
+
+// DeleteToken revokes the token the client presented along with the rest of
+// its family, so logging out also invalidates the refresh token
+func (pg *PostgresTokenStore) DeleteToken(tokenPlainText, scope string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+	DELETE FROM tokens
+	WHERE (hash = $1 AND scope = $2)
+	OR family_id = (SELECT family_id FROM tokens WHERE hash = $1 AND scope = $2)
+	`
+
+	_, err := pg.db.Exec(query, tokenHash[:], scope)
+	return err
+}
+
+func (pg *PostgresTokenStore) DeleteTokenByID(id int64, userID int, scope string) error {
+	query := `
+	WITH target AS (
+		SELECT id, family_id FROM tokens WHERE id = $1 AND user_id = $2 AND scope = $3
+	)
+	DELETE FROM tokens
+	WHERE id IN (SELECT id FROM target)
+	OR family_id = (SELECT family_id FROM target)
+	`
+
+	result, err := pg.db.Exec(query, id, userID, scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListTokens returns the user's unexpired tokens in scope, newest first. The
+// plaintext is never stored, so only ids, creation and expiry come back.
+func (pg *PostgresTokenStore) ListTokens(userID int, scope string) ([]*tokens.Token, error) {
+	query := `
+	SELECT id, user_id, expiry, scope, created_at
+	FROM tokens
+	WHERE user_id = $1 AND scope = $2 AND expiry > $3
+	ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := pg.db.Query(query, userID, scope, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*tokens.Token{}
+	for rows.Next() {
+		var token tokens.Token
+		err = rows.Scan(&token.ID, &token.UserID, &token.Expiry, &token.Scope, &token.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, &token)
+	}
+
+	return list, rows.Err()
+}
+
+// EachToken calls fn with every token the user has, of any scope and expired or
+// not, oldest first. Only metadata comes back, as with ListTokens. An error
+// from fn stops the walk and is returned as is.
+func (pg *PostgresTokenStore) EachToken(userID int, fn func(*tokens.Token) error) error {
+	query := `
+	SELECT id, user_id, expiry, scope, COALESCE(family_id, ''), created_at
+	FROM tokens
+	WHERE user_id = $1
+	ORDER BY created_at, id
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token tokens.Token
+		err = rows.Scan(&token.ID, &token.UserID, &token.Expiry, &token.Scope, &token.FamilyID, &token.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
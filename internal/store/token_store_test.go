@@ -0,0 +1,142 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenRevocation(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	phone, err := tokenStore.CreateNewToken(testUser.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	laptop, err := tokenStore.CreateNewToken(testUser.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	tablet, err := tokenStore.CreateNewToken(testUser.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	list, err := tokenStore.ListTokens(testUser.ID, tokens.ScopeAuth)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+	assert.Empty(t, list[0].Plaintext)
+	assert.False(t, list[0].CreatedAt.IsZero())
+
+	err = tokenStore.DeleteToken(phone.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	_, err = userStore.GetUserToken(tokens.ScopeAuth, phone.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = tokenStore.DeleteTokenByID(int64(laptop.ID), testUser.ID+1, tokens.ScopeAuth)
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = tokenStore.DeleteTokenByID(int64(laptop.ID), testUser.ID, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	list, err = tokenStore.ListTokens(testUser.ID, tokens.ScopeAuth)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, tablet.ID, list[0].ID)
+
+	err = tokenStore.DeleteAllTokensForUser(testUser.ID, tokens.ScopeAuth)
+	require.NoError(t, err)
+	list, err = tokenStore.ListTokens(testUser.ID, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	login, err := tokenStore.CreateTokenPair(testUser.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	rotated, err := tokenStore.RotateRefreshToken(login.Refresh.Plaintext, time.Minute, time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, login.Refresh.Plaintext, rotated.Refresh.Plaintext)
+
+	user, err := userStore.GetUserToken(tokens.ScopeAuth, rotated.Access.Plaintext)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, testUser.ID, user.ID)
+
+	// Replaying the first refresh token revokes everything issued since login
+	_, err = tokenStore.RotateRefreshToken(login.Refresh.Plaintext, time.Minute, time.Hour)
+	assert.ErrorIs(t, err, ErrTokenReused)
+
+	_, err = userStore.GetUserToken(tokens.ScopeAuth, rotated.Access.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = tokenStore.RotateRefreshToken(rotated.Refresh.Plaintext, time.Minute, time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = tokenStore.RotateRefreshToken("not-a-token", time.Minute, time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{Username: "gonzalo", Email: "gonzalo@example.com"}
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	current, err := tokenStore.CreateTokenPair(testUser.ID, time.Hour, 24*time.Hour)
+	require.NoError(t, err)
+	other, err := tokenStore.CreateTokenPair(testUser.ID, time.Hour, 24*time.Hour)
+	require.NoError(t, err)
+
+	familyID, err := tokenStore.GetTokenFamily(current.Access.Plaintext, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Equal(t, current.Access.FamilyID, familyID)
+
+	err = tokenStore.DeleteOtherSessions(testUser.ID, familyID)
+	require.NoError(t, err)
+
+	user, err := userStore.GetUserToken(tokens.ScopeAuth, current.Access.Plaintext)
+	require.NoError(t, err)
+	assert.NotNil(t, user)
+	user, err = userStore.GetUserToken(tokens.ScopeRefresh, current.Refresh.Plaintext)
+	require.NoError(t, err)
+	assert.NotNil(t, user)
+
+	_, err = userStore.GetUserToken(tokens.ScopeAuth, other.Access.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = userStore.GetUserToken(tokens.ScopeRefresh, other.Refresh.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
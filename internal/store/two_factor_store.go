@@ -0,0 +1,280 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// TOTP is a user's authenticator secret. It only protects logins once the user
+// has confirmed it with a code.
+type TOTP struct {
+	UserID       int
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep *int64
+	CreatedAt    time.Time
+}
+
+func (t *TOTP) Enabled() bool {
+	return t != nil && t.ConfirmedAt != nil
+}
+
+// PostgresTwoFactorStore keeps TOTP secrets sealed by box, since unlike
+// passwords and recovery codes they have to be read back to check a code
+type PostgresTwoFactorStore struct {
+	db  *sql.DB
+	box *SecretBox
+}
+
+func NewPostgresTwoFactorStore(db *sql.DB, box *SecretBox) *PostgresTwoFactorStore {
+	return &PostgresTwoFactorStore{
+		db:  db,
+		box: box,
+	}
+}
+
+// totpContext binds a sealed secret to its user, so it can't be copied over to
+// another account's row
+func totpContext(userID int) string {
+	return "totp:" + strconv.Itoa(userID)
+}
+
+type TwoFactorStore interface {
+	SetupTOTP(userID int, secret string) error
+	GetTOTP(userID int) (*TOTP, error)
+	// ConfirmTOTP turns the secret on, spends the step of the code that
+	// confirmed it and stores the recovery codes, all or nothing
+	ConfirmTOTP(userID int, step int64, recoveryCodes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, codes []string) error
+	UseRecoveryCode(userID int, code string) (bool, error)
+}
+
+// SetupTOTP stores a new unconfirmed secret, replacing any earlier one that
+// was never confirmed
+func (pg *PostgresTwoFactorStore) SetupTOTP(userID int, secret string) error {
+	query := `
+	INSERT INTO user_totp (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = NULL, created_at = CURRENT_TIMESTAMP
+	WHERE user_totp.confirmed_at IS NULL
+	`
+
+	sealed, err := pg.box.Seal(secret, totpContext(userID))
+	if err != nil {
+		return err
+	}
+
+	_, err = pg.db.Exec(query, userID, sealed)
+	return err
+}
+
+func (pg *PostgresTwoFactorStore) GetTOTP(userID int) (*TOTP, error) {
+	query := `
+	SELECT user_id, secret, confirmed_at, last_used_step, created_at
+	FROM user_totp
+	WHERE user_id = $1
+	`
+
+	totp := &TOTP{}
+	err := pg.db.QueryRow(query, userID).Scan(&totp.UserID, &totp.Secret, &totp.ConfirmedAt, &totp.LastUsedStep, &totp.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Secrets stored before they were sealed stay readable until
+	// SealPlaintextSecrets gets to them
+	if Sealed(totp.Secret) {
+		totp.Secret, err = pg.box.Open(totp.Secret, totpContext(userID))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return totp, nil
+}
+
+// SealPlaintextSecrets seals the secrets stored before they were sealed. It
+// returns how many it sealed.
+func (pg *PostgresTwoFactorStore) SealPlaintextSecrets() (int, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT user_id, secret FROM user_totp WHERE secret NOT LIKE $1 || '%' FOR UPDATE`, sealedPrefix)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	secrets := map[int]string{}
+	for rows.Next() {
+		var userID int
+		var secret string
+		err = rows.Scan(&userID, &secret)
+		if err != nil {
+			return 0, err
+		}
+		secrets[userID] = secret
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for userID, secret := range secrets {
+		sealed, err := pg.box.Seal(secret, totpContext(userID))
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(`UPDATE user_totp SET secret = $1 WHERE user_id = $2`, sealed, userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(secrets), tx.Commit()
+}
+
+func (pg *PostgresTwoFactorStore) ConfirmTOTP(userID int, step int64, recoveryCodes []string) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE user_totp
+	SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+	WHERE user_id = $1 AND confirmed_at IS NULL AND (last_used_step IS NULL OR last_used_step < $2)
+	`
+
+	result, err := tx.Exec(query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP removes the secret along with the recovery codes
+func (pg *PostgresTwoFactorStore) DisableTOTP(userID int) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as used. It reports false when the step, or a later
+// one, was already used, so each code only works once.
+func (pg *PostgresTwoFactorStore) UseTOTPStep(userID int, step int64) (bool, error) {
+	query := `
+	UPDATE user_totp
+	SET last_used_step = $2
+	WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)
+	`
+
+	result, err := pg.db.Exec(query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// ReplaceRecoveryCodes throws away the user's old codes. Codes must already be
+// normalized, only their hashes are kept.
+func (pg *PostgresTwoFactorStore) ReplaceRecoveryCodes(userID int, codes []string) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(tx, userID, codes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codes []string) error {
+	_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		hash := sha256.Sum256([]byte(code))
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode spends a normalized recovery code. It reports false when the
+// code doesn't exist or was already used.
+func (pg *PostgresTwoFactorStore) UseRecoveryCode(userID int, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(code))
+
+	query := `
+	UPDATE recovery_codes
+	SET used_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+	`
+
+	result, err := pg.db.Exec(query, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
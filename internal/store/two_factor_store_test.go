@@ -0,0 +1,108 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactor(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	box, err := NewSecretBox(SecretKey{ID: "test", Key: bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	twoFactorStore := NewPostgresTwoFactorStore(db, box)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{Username: "gonzalo", Email: "gonzalo@example.com"}
+	err = testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	_, err = twoFactorStore.GetTOTP(testUser.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = twoFactorStore.SetupTOTP(testUser.ID, "FIRSTSECRET")
+	require.NoError(t, err)
+	err = twoFactorStore.SetupTOTP(testUser.ID, "SECONDSECRET")
+	require.NoError(t, err)
+
+	totp, err := twoFactorStore.GetTOTP(testUser.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "SECONDSECRET", totp.Secret)
+	assert.False(t, totp.Enabled())
+
+	// Only the sealed secret reaches the database
+	var stored string
+	err = db.QueryRow(`SELECT secret FROM user_totp WHERE user_id = $1`, testUser.ID).Scan(&stored)
+	require.NoError(t, err)
+	assert.True(t, Sealed(stored))
+
+	err = twoFactorStore.ConfirmTOTP(testUser.ID, 99, []string{"aaaaabbbbb", "cccccddddd"})
+	require.NoError(t, err)
+	err = twoFactorStore.ConfirmTOTP(testUser.ID, 100, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A confirmed secret can't be swapped out without disabling first
+	err = twoFactorStore.SetupTOTP(testUser.ID, "THIRDSECRET")
+	require.NoError(t, err)
+	totp, err = twoFactorStore.GetTOTP(testUser.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "SECONDSECRET", totp.Secret)
+	assert.True(t, totp.Enabled())
+
+	// Confirming spent its step
+	used, err := twoFactorStore.UseTOTPStep(testUser.ID, 99)
+	require.NoError(t, err)
+	assert.False(t, used)
+	used, err = twoFactorStore.UseTOTPStep(testUser.ID, 100)
+	require.NoError(t, err)
+	assert.True(t, used)
+	used, err = twoFactorStore.UseTOTPStep(testUser.ID, 100)
+	require.NoError(t, err)
+	assert.False(t, used)
+
+	// and stored the recovery codes
+	used, err = twoFactorStore.UseRecoveryCode(testUser.ID, "cccccddddd")
+	require.NoError(t, err)
+	assert.True(t, used)
+
+	err = twoFactorStore.ReplaceRecoveryCodes(testUser.ID, []string{"aaaaabbbbb", "cccccddddd"})
+	require.NoError(t, err)
+	used, err = twoFactorStore.UseRecoveryCode(testUser.ID, "aaaaabbbbb")
+	require.NoError(t, err)
+	assert.True(t, used)
+	used, err = twoFactorStore.UseRecoveryCode(testUser.ID, "aaaaabbbbb")
+	require.NoError(t, err)
+	assert.False(t, used)
+
+	err = twoFactorStore.DisableTOTP(testUser.ID)
+	require.NoError(t, err)
+	totp, err = twoFactorStore.GetTOTP(testUser.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	used, err = twoFactorStore.UseRecoveryCode(testUser.ID, "cccccddddd")
+	require.NoError(t, err)
+	assert.False(t, used)
+
+	// Secrets stored before sealing still work and get sealed
+	_, err = db.Exec(`INSERT INTO user_totp (user_id, secret) VALUES ($1, 'PLAINSECRET')`, testUser.ID)
+	require.NoError(t, err)
+	totp, err = twoFactorStore.GetTOTP(testUser.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "PLAINSECRET", totp.Secret)
+
+	sealedCount, err := twoFactorStore.SealPlaintextSecrets()
+	require.NoError(t, err)
+	assert.Equal(t, 1, sealedCount)
+	err = db.QueryRow(`SELECT secret FROM user_totp WHERE user_id = $1`, testUser.ID).Scan(&stored)
+	require.NoError(t, err)
+	assert.True(t, Sealed(stored))
+	totp, err = twoFactorStore.GetTOTP(testUser.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "PLAINSECRET", totp.Secret)
+}
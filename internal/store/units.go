@@ -0,0 +1,107 @@
+package store
+
+import "math"
+
+// WeightUnit is the unit a user enters and reads weights in. Weights are
+// always stored in kilograms and converted at the API edge.
+type WeightUnit string
+
+const (
+	UnitKilograms WeightUnit = "kg"
+	UnitPounds    WeightUnit = "lb"
+)
+
+const poundsPerKilogram = 2.20462262185
+
+func (u WeightUnit) Valid() bool {
+	return u == UnitKilograms || u == UnitPounds
+}
+
+// ToKilograms converts a weight given in u to the stored unit, keeping the
+// three decimals the weight columns hold
+func (u WeightUnit) ToKilograms(weight float64) float64 {
+	if u == UnitPounds {
+		weight /= poundsPerKilogram
+	}
+	return math.Round(weight*1000) / 1000
+}
+
+// FromKilograms converts a stored weight to u, rounded to two decimals
+func (u WeightUnit) FromKilograms(weight float64) float64 {
+	if u == UnitPounds {
+		weight *= poundsPerKilogram
+	}
+	return math.Round(weight*100) / 100
+}
+
+// RoundToPlates rounds a weight given in u to the smallest jump most gyms can
+// load: 2.5kg or 5lb
+func (u WeightUnit) RoundToPlates(weight float64) float64 {
+	step := 2.5
+	if u == UnitPounds {
+		step = 5
+	}
+	return math.Round(weight/step) * step
+}
+
+func convertWeight(weight *float64, convert func(float64) float64) {
+	if weight != nil {
+		*weight = convert(*weight)
+	}
+}
+
+// ConvertWeights applies convert to every weight in the workout, including
+// its sets, new records and enrollment. An entry and its sets can point at
+// the same weight, so each one is only converted once.
+func (w *Workout) ConvertWeights(convert func(float64) float64) {
+	converted := map[*float64]bool{}
+	convertOnce := func(weight *float64) {
+		if weight != nil && !converted[weight] {
+			converted[weight] = true
+			*weight = convert(*weight)
+		}
+	}
+
+	for i := range w.Entries {
+		entry := &w.Entries[i]
+		convertOnce(entry.Weight)
+		for j := range entry.LoggedSets {
+			convertOnce(entry.LoggedSets[j].Weight)
+		}
+	}
+	for i := range w.NewRecords {
+		w.NewRecords[i].ConvertWeights(convert)
+	}
+	if w.Enrollment != nil {
+		w.Enrollment.ConvertWeights(convert)
+	}
+}
+
+func (t *WorkoutTemplate) ConvertWeights(convert func(float64) float64) {
+	for i := range t.Entries {
+		convertWeight(t.Entries[i].TargetWeight, convert)
+	}
+}
+
+// ConvertWeights converts the increments of linear rules. Percentages are
+// unitless and stay as they are.
+func (p *Program) ConvertWeights(convert func(float64) float64) {
+	for i := range p.Rules {
+		convertWeight(p.Rules[i].Increment, convert)
+	}
+}
+
+func (e *Enrollment) ConvertWeights(convert func(float64) float64) {
+	for exerciseID, weight := range e.Weights {
+		e.Weights[exerciseID] = convert(weight)
+	}
+}
+
+// ConvertWeights converts the weight the record was set with and, for weight
+// based records, the record value itself
+func (r *PersonalRecord) ConvertWeights(convert func(float64) float64) {
+	convertWeight(r.Weight, convert)
+	if r.RecordType == RecordHeaviestWeight || r.RecordType == RecordEstimated1RM {
+		r.Value = convert(r.Value)
+	}
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightUnit(t *testing.T) {
+	t.Run("Round trips pounds through kilograms", func(t *testing.T) {
+		for _, weight := range []float64{45, 135, 225, 1250.5} {
+			assert.Equal(t, weight, UnitPounds.FromKilograms(UnitPounds.ToKilograms(weight)))
+		}
+	})
+
+	t.Run("Leaves kilograms alone", func(t *testing.T) {
+		assert.Equal(t, 102.5, UnitKilograms.ToKilograms(102.5))
+		assert.Equal(t, 102.5, UnitKilograms.FromKilograms(102.5))
+	})
+
+	t.Run("Rounds to plates", func(t *testing.T) {
+		assert.Equal(t, 102.5, UnitKilograms.RoundToPlates(101.4))
+		assert.Equal(t, 225.0, UnitPounds.RoundToPlates(226))
+	})
+
+	t.Run("Converts every weight in a workout", func(t *testing.T) {
+		workout := &Workout{
+			Entries: []WorkoutEntry{{
+				Weight:     testutil.FloatPtr(100),
+				LoggedSets: []WorkoutSet{{Weight: testutil.FloatPtr(100)}, {Reps: testutil.IntPtr(5)}},
+			}},
+			NewRecords: []PersonalRecord{
+				{RecordType: RecordHeaviestWeight, Weight: testutil.FloatPtr(100), Value: 100},
+				{RecordType: RecordMostReps, Weight: testutil.FloatPtr(100), Value: 12},
+			},
+		}
+
+		workout.ConvertWeights(UnitPounds.FromKilograms)
+
+		assert.Equal(t, 220.46, *workout.Entries[0].Weight)
+		assert.Equal(t, 220.46, *workout.Entries[0].LoggedSets[0].Weight)
+		assert.Nil(t, workout.Entries[0].LoggedSets[1].Weight)
+		assert.Equal(t, 220.46, workout.NewRecords[0].Value)
+		assert.Equal(t, 220.46, *workout.NewRecords[1].Weight)
+		assert.Equal(t, 12.0, workout.NewRecords[1].Value)
+	})
+}
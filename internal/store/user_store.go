@@ -4,6 +4,8 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -38,14 +40,45 @@ func (p *password) Matches(plainTextPassword string) (bool, error) {
 	return true, nil
 }
 
+// dummyPasswordHash stands in for the hash of a user that doesn't exist. It's
+// generated once with the same cost as real hashes.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), 12)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// SimulatePasswordCheck does the same bcrypt work as Matches without a user, so
+// a login for an unknown username takes as long as one with a wrong password
+func SimulatePasswordCheck(plainTextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(plainTextPassword))
+}
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	return r == RoleUser || r == RoleAdmin
+}
+
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash password  `json:"-"`
-	Bio          string    `json:"bio"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            int        `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	PasswordHash  password   `json:"-"`
+	Bio           string     `json:"bio"`
+	PreferredUnit WeightUnit `json:"preferred_unit"`
+	Activated     bool       `json:"activated"`
+	Role          Role       `json:"role"`
+	DisabledAt    *time.Time `json:"disabled_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
 var AnonymousUser = &User{}
@@ -54,6 +87,30 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// userColumns and userDest keep every query that loads a whole user in sync.
+// Queries must alias users as u.
+const userColumns = `u.id, u.username, u.email, u.password_hash, u.bio, u.preferred_unit, u.activated, u.role, u.disabled_at, u.created_at, u.updated_at`
+
+func userDest(user *User) []any {
+	return []any{
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash.hash,
+		&user.Bio,
+		&user.PreferredUnit,
+		&user.Activated,
+		&user.Role,
+		&user.DisabledAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	}
+}
+
 type PostgresUserStore struct {
 	db *sql.DB
 }
@@ -67,17 +124,33 @@ func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
 type UserStore interface {
 	CreateUser(*User) error
 	GetUserByUsername(username string) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	GetUserByID(id int64) (*User, error)
+	ListUsers(search string, limit, offset int) ([]*User, error)
 	UpdateUser(*User) error
+	UpdatePassword(*User) error
 	GetUserToken(scope, tokenPlainText string) (*User, error)
+	DeleteUser(id int) error
 }
 
 func (pg *PostgresUserStore) CreateUser(user *User) error {
+	return translateError(insertUser(pg.db, user))
+}
+
+func insertUser(q queryer, user *User) error {
+	if user.PreferredUnit == "" {
+		user.PreferredUnit = UnitKilograms
+	}
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+
 	query := `
-	INSERT INTO users (username, email, password_hash, bio)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO users (username, email, password_hash, bio, preferred_unit, activated, role)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING id, created_at, updated_at
 	`
-	err := pg.db.QueryRow(query, user.Username, user.Email, user.PasswordHash.hash, user.Bio).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err := q.QueryRow(query, user.Username, user.Email, user.PasswordHash.hash, user.Bio, user.PreferredUnit, user.Activated, user.Role).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return err
 	}
@@ -85,28 +158,34 @@ func (pg *PostgresUserStore) CreateUser(user *User) error {
 }
 
 func (pg *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
+	return pg.getUser("u.username", username)
+}
+
+// GetUserByEmail matches the email case-insensitively, since that's how people
+// type it into a reset form
+func (pg *PostgresUserStore) GetUserByEmail(email string) (*User, error) {
+	return pg.getUser("lower(u.email)", strings.ToLower(email))
+}
+
+func (pg *PostgresUserStore) GetUserByID(id int64) (*User, error) {
+	return pg.getUser("u.id", id)
+}
+
+func (pg *PostgresUserStore) getUser(column string, value any) (*User, error) {
 	user := &User{
 		PasswordHash: password{},
 	}
 
 	query := `
-	SELECT id, username, email, password_hash, bio, created_at, updated_at
-	FROM users
-	WHERE username = $1
+	SELECT ` + userColumns + `
+	FROM users u
+	WHERE ` + column + ` = $1
 	`
 
-	err := pg.db.QueryRow(query, username).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash.hash,
-		&user.Bio,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	err := pg.db.QueryRow(query, value).Scan(userDest(user)...)
 
 	if err == sql.ErrNoRows {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	if err != nil {
@@ -116,15 +195,68 @@ func (pg *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
 	return user, nil
 }
 
+// ListUsers pages through every account, optionally narrowed down to usernames
+// or emails containing search
+func (pg *PostgresUserStore) ListUsers(search string, limit, offset int) ([]*User, error) {
+	query := `
+	SELECT ` + userColumns + `
+	FROM users u
+	WHERE $1 = '' OR u.username ILIKE '%' || $1 || '%' ESCAPE '\' OR u.email ILIKE '%' || $1 || '%' ESCAPE '\'
+	ORDER BY u.id
+	LIMIT $2 OFFSET $3
+	`
+
+	rows, err := pg.db.Query(query, escapeLike(search), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user := &User{PasswordHash: password{}}
+		err = rows.Scan(userDest(user)...)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
 func (pg *PostgresUserStore) UpdateUser(user *User) error {
 	query := `
 	UPDATE users
-	SET username = $1, email = $2, bio = $3, updated_at = CURRENT_TIMESTAMP
-	WHERE id = $4
+	SET username = $1, email = $2, bio = $3, preferred_unit = $4, activated = $5, role = $6, disabled_at = $7, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $8
 	RETURNING updated_at
 	`
 
-	result, err := pg.db.Exec(query, user.Username, user.Email, user.Bio, user.ID)
+	result, err := pg.db.Exec(query, user.Username, user.Email, user.Bio, user.PreferredUnit, user.Activated, user.Role, user.DisabledAt, user.ID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (pg *PostgresUserStore) UpdatePassword(user *User) error {
+	query := `
+	UPDATE users
+	SET password_hash = $1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $2
+	`
+
+	result, err := pg.db.Exec(query, user.PasswordHash.hash, user.ID)
 	if err != nil {
 		return err
 	}
@@ -134,7 +266,31 @@ func (pg *PostgresUserStore) UpdateUser(user *User) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return sql.ErrNoRows
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteUser removes the user for good. Everything they own goes with them
+// through the foreign keys' ON DELETE CASCADE.
+func (pg *PostgresUserStore) DeleteUser(id int) error {
+	query := `
+	DELETE FROM users
+	WHERE id = $1
+	`
+
+	result, err := pg.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
 	}
 
 	return nil
@@ -144,7 +300,7 @@ func (pg *PostgresUserStore) GetUserToken(scope, plainTextPassword string) (*Use
 	tokenHash := sha256.Sum256([]byte(plainTextPassword))
 
 	query := `
-	SELECT u.id, u.username, u.email, u.password_hash, u.bio, u.created_at, u.updated_at
+	SELECT ` + userColumns + `
 	FROM users u
 	INNER JOIN tokens t ON t.user_id = u.id
 	WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3
@@ -154,18 +310,10 @@ func (pg *PostgresUserStore) GetUserToken(scope, plainTextPassword string) (*Use
 		PasswordHash: password{},
 	}
 
-	err := pg.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash.hash,
-		&user.Bio,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	err := pg.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(userDest(user)...)
 
 	if err == sql.ErrNoRows {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	if err != nil {
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/testutil"
+	"github.com/gonstoll/workouts/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListAndDisableUsers(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+
+	for _, username := range []string{"gonzalo", "maria", "gonzo"} {
+		user := &User{Username: username, Email: username + "@example.com"}
+		err := user.PasswordHash.Set("securepassword")
+		require.NoError(t, err)
+		err = userStore.CreateUser(user)
+		require.NoError(t, err)
+		assert.Equal(t, RoleUser, user.Role)
+	}
+
+	// Taken usernames and emails come back as conflicts naming the field
+	duplicate := &User{Username: "gonzalo", Email: "other@example.com"}
+	err := duplicate.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(duplicate)
+	var conflict *ErrConflict
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "username", conflict.Field)
+
+	duplicate.Username, duplicate.Email = "other", "maria@example.com"
+	err = userStore.CreateUser(duplicate)
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "email", conflict.Field)
+
+	// Emails differing only in case are the same address
+	duplicate.Email = "Maria@Example.com"
+	err = userStore.CreateUser(duplicate)
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "email", conflict.Field)
+
+	users, err := userStore.ListUsers("", 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, users, 3)
+
+	users, err = userStore.ListUsers("GONZ", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "gonzalo", users[0].Username)
+
+	users, err = userStore.ListUsers("gonz", 1, 1)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "gonzo", users[0].Username)
+
+	wildcards, err := userStore.ListUsers("%", 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, wildcards, "wildcards match literally")
+
+	user := users[0]
+	now := time.Now()
+	user.DisabledAt = &now
+	user.Role = RoleAdmin
+	err = userStore.UpdateUser(user)
+	require.NoError(t, err)
+
+	found, err := userStore.GetUserByID(int64(user.ID))
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.True(t, found.IsDisabled())
+	assert.Equal(t, RoleAdmin, found.Role)
+
+	found.DisabledAt = nil
+	err = userStore.UpdateUser(found)
+	require.NoError(t, err)
+
+	found, err = userStore.GetUserByID(int64(user.ID))
+	require.NoError(t, err)
+	assert.False(t, found.IsDisabled())
+}
+
+func TestExportAndDeleteUser(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+	workoutStore := NewPostgresWorkoutStore(db)
+	tokenStore := NewPostgresTokenStore(db)
+
+	user := &User{Username: "gonzalo", Email: "gonzalo@example.com"}
+	err := user.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(user)
+	require.NoError(t, err)
+
+	// More than a page, so the walk has to follow the cursor
+	total := exportPageSize + 5
+	for i := range total {
+		_, err = workoutStore.CreateWorkout(&Workout{
+			UserID:          user.ID,
+			Title:           "Workout",
+			DurationMinutes: 30,
+			PerformedAt:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i),
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Squat", Sets: 1, Reps: testutil.IntPtr(5), OrderIndex: 1},
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	_, err = tokenStore.CreateTokenPair(user.ID, time.Hour, 24*time.Hour)
+	require.NoError(t, err)
+
+	seen := map[int]bool{}
+	err = workoutStore.EachWorkout(user.ID, func(workout *Workout) error {
+		assert.Len(t, workout.Entries, 1)
+		seen[workout.ID] = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, seen, total)
+
+	scopes := []string{}
+	err = tokenStore.EachToken(user.ID, func(token *tokens.Token) error {
+		scopes = append(scopes, token.Scope)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{tokens.ScopeAuth, tokens.ScopeRefresh}, scopes)
+
+	err = userStore.DeleteUser(user.ID)
+	require.NoError(t, err)
+
+	_, err = userStore.GetUserByID(int64(user.ID))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	var remaining int
+	err = db.QueryRow(`SELECT (SELECT COUNT(*) FROM workouts) + (SELECT COUNT(*) FROM workout_entries) + (SELECT COUNT(*) FROM tokens)`).Scan(&remaining)
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+
+	err = userStore.DeleteUser(user.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGetUserByEmailAndUpdatePassword(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+
+	user := &User{Username: "gonzalo", Email: "gonzalo@example.com"}
+	err := user.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(user)
+	require.NoError(t, err)
+
+	found, err := userStore.GetUserByEmail("Gonzalo@Example.COM")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	_, err = userStore.GetUserByEmail("nobody@example.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = found.PasswordHash.Set("newpassword")
+	require.NoError(t, err)
+	err = userStore.UpdatePassword(found)
+	require.NoError(t, err)
+
+	reloaded, err := userStore.GetUserByID(int64(user.ID))
+	require.NoError(t, err)
+	matches, err := reloaded.PasswordHash.Matches("newpassword")
+	require.NoError(t, err)
+	assert.True(t, matches)
+	matches, err = reloaded.PasswordHash.Matches("securepassword")
+	require.NoError(t, err)
+	assert.False(t, matches)
+
+	missing := &User{ID: user.ID + 1}
+	err = missing.PasswordHash.Set("newpassword")
+	require.NoError(t, err)
+	err = userStore.UpdatePassword(missing)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
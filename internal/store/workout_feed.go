@@ -0,0 +1,132 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// visibleTo is the condition for a workout w, owned by the user u, that the
+// viewer in $1 may see: their own, anything public and, when the owner has
+// accepted them as a follower, anything shared with followers. Disabled users'
+// workouts stay hidden from everyone else. The anonymous viewer has id 0 and
+// follows no one.
+const visibleTo = `(w.user_id = $1 OR (u.disabled_at IS NULL AND (
+	w.visibility = 'public'
+	OR (w.visibility = 'followers' AND EXISTS (
+		SELECT 1 FROM follows f WHERE f.follower_id = $1 AND f.followee_id = w.user_id AND f.accepted_at IS NOT NULL
+	))
+)))`
+
+// SharedWorkout is a workout seen by someone other than its owner, along
+// with whose it is
+type SharedWorkout struct {
+	Username string   `json:"username"`
+	Workout  *Workout `json:"workout"`
+}
+
+// SharedFilter pages through the workouts ViewerID can see. With an OwnerID
+// it's that user's profile, without one it's the feed: the shared workouts
+// of everyone who accepted the viewer as a follower.
+type SharedFilter struct {
+	ViewerID int
+	OwnerID  int
+	Cursor   *Cursor
+	Limit    int
+}
+
+// GetVisibleWorkout is GetWorkoutByID for any viewer. It returns ErrNotFound
+// both when the workout doesn't exist and when the viewer isn't allowed to
+// see it.
+func (pg *PostgresWorkoutStore) GetVisibleWorkout(id int64, viewerID int) (*Workout, error) {
+	workout := &Workout{}
+	query := `
+	SELECT ` + workoutColumns + `
+	FROM workouts w
+	JOIN users u ON u.id = w.user_id
+	WHERE w.id = $2 AND ` + visibleTo
+
+	err := pg.db.QueryRow(query, viewerID, id).Scan(workoutDest(workout)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = pg.loadEntries([]*Workout{workout})
+	if err != nil {
+		return nil, err
+	}
+
+	return workout, nil
+}
+
+// ListSharedWorkouts returns a page of the workouts the filter selects, most
+// recently performed first, along with the cursor for the next page (nil when
+// there are no more). Private workouts never show up, not even the viewer's
+// own on their profile, so it shows what everyone else sees.
+func (pg *PostgresWorkoutStore) ListSharedWorkouts(filter SharedFilter) ([]*SharedWorkout, *Cursor, error) {
+	conditions := []string{visibleTo, "w.visibility <> 'private'"}
+	args := []any{filter.ViewerID}
+
+	if filter.OwnerID != 0 {
+		args = append(args, filter.OwnerID)
+		conditions = append(conditions, fmt.Sprintf("w.user_id = $%d", len(args)))
+	} else {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM follows f WHERE f.follower_id = $1 AND f.followee_id = w.user_id AND f.accepted_at IS NOT NULL
+		)`)
+	}
+
+	if filter.Cursor != nil {
+		args = append(args, filter.Cursor.Time, filter.Cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(w.performed_at, w.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	// Fetch one extra row so we know whether there's a next page
+	args = append(args, filter.Limit+1)
+	query := fmt.Sprintf(`
+	SELECT `+workoutColumns+`, u.username
+	FROM workouts w
+	JOIN users u ON u.id = w.user_id
+	WHERE %s
+	ORDER BY w.performed_at DESC, w.id DESC
+	LIMIT $%d
+	`, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	shared := []*SharedWorkout{}
+	workouts := []*Workout{}
+	for rows.Next() {
+		item := &SharedWorkout{Workout: &Workout{}}
+		err = rows.Scan(append(workoutDest(item.Workout), &item.Username)...)
+		if err != nil {
+			return nil, nil, err
+		}
+		shared = append(shared, item)
+		workouts = append(workouts, item.Workout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	if len(shared) > filter.Limit {
+		shared, workouts = shared[:filter.Limit], workouts[:filter.Limit]
+		last := workouts[len(workouts)-1]
+		next = &Cursor{Time: last.PerformedAt, ID: last.ID}
+	}
+
+	err = pg.loadEntries(workouts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return shared, next, nil
+}
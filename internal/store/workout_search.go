@@ -0,0 +1,164 @@
+package store
+
+import (
+	"html"
+	"strings"
+)
+
+type SearchResult struct {
+	Workout    *Workout         `json:"workout"`
+	Rank       float64          `json:"rank"`
+	Highlights SearchHighlights `json:"highlights"`
+}
+
+// SearchHighlights holds the matched fields as HTML: the text is escaped and
+// the matching terms are wrapped in <mark> tags. Entries only lists the
+// entries that matched.
+type SearchHighlights struct {
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Entries     []EntryHighlight `json:"entries"`
+}
+
+type EntryHighlight struct {
+	EntryID      int    `json:"entry_id"`
+	ExerciseName string `json:"exercise_name"`
+	Notes        string `json:"notes"`
+}
+
+// ts_headline marks matches with these private use characters rather than the
+// <mark> tags themselves, so the user's text can be HTML escaped before the
+// tags go in. They're stripped from the text first so it can't fake a match.
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+
+	titleHeadlineOptions = "HighlightAll=TRUE, StartSel=" + highlightStart + ", StopSel=" + highlightStop
+	headlineOptions      = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxFragments=2, MinWords=5, MaxWords=20"
+)
+
+var highlightReplacer = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// toHighlightHTML escapes a ts_headline result and turns its match markers
+// into <mark> tags
+func toHighlightHTML(headline string) string {
+	return highlightReplacer.Replace(html.EscapeString(headline))
+}
+
+// SearchWorkouts runs a web-style full text search (quoted phrases, OR and
+// -exclusions work) over the user's workout titles and descriptions and their
+// entries' exercise names and notes. A workout's rank adds up the rank of the
+// workout itself and of every entry that matched.
+func (pg *PostgresWorkoutStore) SearchWorkouts(userID int, search string, limit, offset int) ([]*SearchResult, error) {
+	query := `
+	WITH q AS (
+		SELECT websearch_to_tsquery('english', $2) AS query
+	),
+	matches AS (
+		SELECT w.id AS workout_id, ts_rank(w.search_vector, q.query) AS rank
+		FROM workouts w, q
+		WHERE w.user_id = $1 AND w.search_vector @@ q.query
+		UNION ALL
+		SELECT we.workout_id, ts_rank(we.search_vector, q.query)
+		FROM workout_entries we
+		JOIN workouts w ON w.id = we.workout_id, q
+		WHERE w.user_id = $1 AND we.search_vector @@ q.query
+	),
+	ranked AS (
+		SELECT workout_id, SUM(rank) AS rank
+		FROM matches
+		GROUP BY workout_id
+	)
+	SELECT ` + workoutColumns + `, r.rank,
+		ts_headline('english', translate(w.title, $7, ''), q.query, $6),
+		ts_headline('english', translate(coalesce(w.description, ''), $7, ''), q.query, $5)
+	FROM ranked r
+	JOIN workouts w ON w.id = r.workout_id, q
+	ORDER BY r.rank DESC, w.performed_at DESC, w.id DESC
+	LIMIT $3 OFFSET $4
+	`
+
+	rows, err := pg.db.Query(query, userID, search, limit, offset, headlineOptions, titleHeadlineOptions, highlightStart+highlightStop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	workouts := []*Workout{}
+	for rows.Next() {
+		var workout Workout
+		result := &SearchResult{Workout: &workout}
+		dest := append(workoutDest(&workout), &result.Rank, &result.Highlights.Title, &result.Highlights.Description)
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+		result.Highlights.Title = toHighlightHTML(result.Highlights.Title)
+		result.Highlights.Description = toHighlightHTML(result.Highlights.Description)
+		result.Highlights.Entries = []EntryHighlight{}
+		results = append(results, result)
+		workouts = append(workouts, &workout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = pg.loadEntries(workouts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pg.loadEntryHighlights(results, search)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (pg *PostgresWorkoutStore) loadEntryHighlights(results []*SearchResult, search string) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(results))
+	byID := make(map[int]*SearchResult, len(results))
+	for i, result := range results {
+		ids[i] = int64(result.Workout.ID)
+		byID[result.Workout.ID] = result
+	}
+
+	query := `
+	WITH q AS (
+		SELECT websearch_to_tsquery('english', $2) AS query
+	)
+	SELECT we.workout_id, we.id,
+		ts_headline('english', translate(we.exercise_name, $5, ''), q.query, $4),
+		ts_headline('english', translate(coalesce(we.notes, ''), $5, ''), q.query, $3)
+	FROM workout_entries we, q
+	WHERE we.workout_id = ANY($1) AND we.search_vector @@ q.query
+	ORDER BY we.workout_id, we.order_index
+	`
+
+	rows, err := pg.db.Query(query, ids, search, headlineOptions, titleHeadlineOptions, highlightStart+highlightStop)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var workoutID int
+		var highlight EntryHighlight
+		err = rows.Scan(&workoutID, &highlight.EntryID, &highlight.ExerciseName, &highlight.Notes)
+		if err != nil {
+			return err
+		}
+		highlight.ExerciseName = toHighlightHTML(highlight.ExerciseName)
+		highlight.Notes = toHighlightHTML(highlight.Notes)
+		result := byID[workoutID]
+		result.Highlights.Entries = append(result.Highlights.Entries, highlight)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrInvalidSets = errors.New("invalid sets")
+
+type SetType string
+
+const (
+	SetTypeWorking SetType = "working"
+	SetTypeWarmup  SetType = "warmup"
+	SetTypeDrop    SetType = "drop"
+	SetTypeFailure SetType = "failure"
+)
+
+func (s SetType) Valid() bool {
+	switch s {
+	case SetTypeWorking, SetTypeWarmup, SetTypeDrop, SetTypeFailure:
+		return true
+	}
+	return false
+}
+
+type WorkoutSet struct {
+	ID              int      `json:"id"`
+	SetNumber       int      `json:"set_number"`
+	SetType         SetType  `json:"set_type"`
+	Reps            *int     `json:"reps"`
+	DurationSeconds *int     `json:"duration_seconds"`
+	Weight          *float64 `json:"weight"`
+	RPE             *float64 `json:"rpe"`
+	RestSeconds     *int     `json:"rest_seconds"`
+}
+
+// normalizeSets reconciles an entry's per-set log with the older aggregate
+// fields. Entries sent by clients that only know about sets/reps/weight get
+// that many identical working sets. Entries with a set log get their
+// aggregate fields derived from it: the count of non warm-up sets and the
+// top set's reps, duration and weight.
+func normalizeSets(entry *WorkoutEntry) error {
+	if len(entry.LoggedSets) == 0 {
+		entry.LoggedSets = make([]WorkoutSet, 0, entry.Sets)
+		for i := range entry.Sets {
+			set := WorkoutSet{
+				SetNumber:       i + 1,
+				SetType:         SetTypeWorking,
+				Reps:            entry.Reps,
+				DurationSeconds: entry.DurationSeconds,
+			}
+			// Each set gets its own weight so converting one doesn't convert
+			// the others
+			if entry.Weight != nil {
+				w := *entry.Weight
+				set.Weight = &w
+			}
+			entry.LoggedSets = append(entry.LoggedSets, set)
+		}
+		return nil
+	}
+
+	timed := entry.LoggedSets[0].DurationSeconds != nil
+	var top *WorkoutSet
+	workingSets := 0
+	for i := range entry.LoggedSets {
+		set := &entry.LoggedSets[i]
+		if set.SetNumber == 0 {
+			set.SetNumber = i + 1
+		}
+		if set.SetType == "" {
+			set.SetType = SetTypeWorking
+		}
+		if !set.SetType.Valid() {
+			return ErrInvalidSets
+		}
+
+		// A set is either reps or time based, and an entry doesn't mix the two
+		if (set.Reps == nil) == (set.DurationSeconds == nil) || (set.DurationSeconds != nil) != timed {
+			return ErrInvalidSets
+		}
+		if set.RPE != nil && (*set.RPE < 1 || *set.RPE > 10) {
+			return ErrInvalidSets
+		}
+
+		if set.SetType != SetTypeWarmup {
+			workingSets++
+		}
+		if top == nil || isTopSet(set, top) {
+			top = set
+		}
+	}
+
+	if workingSets == 0 {
+		workingSets = len(entry.LoggedSets)
+	}
+
+	entry.Sets = workingSets
+	entry.Reps = top.Reps
+	entry.DurationSeconds = top.DurationSeconds
+	entry.Weight = nil
+	if top.Weight != nil {
+		w := *top.Weight
+		entry.Weight = &w
+	}
+	return nil
+}
+
+// isTopSet reports whether set beats current as the entry's headline set:
+// warm-ups lose to anything else, then heavier wins, then more reps or time
+func isTopSet(set, current *WorkoutSet) bool {
+	if (set.SetType == SetTypeWarmup) != (current.SetType == SetTypeWarmup) {
+		return current.SetType == SetTypeWarmup
+	}
+
+	weight, currentWeight := 0.0, 0.0
+	if set.Weight != nil {
+		weight = *set.Weight
+	}
+	if current.Weight != nil {
+		currentWeight = *current.Weight
+	}
+	if weight != currentWeight {
+		return weight > currentWeight
+	}
+
+	if set.Reps != nil && current.Reps != nil {
+		return *set.Reps > *current.Reps
+	}
+	if set.DurationSeconds != nil && current.DurationSeconds != nil {
+		return *set.DurationSeconds > *current.DurationSeconds
+	}
+	return false
+}
+
+func insertSets(tx *sql.Tx, entry *WorkoutEntry) error {
+	for i := range entry.LoggedSets {
+		set := &entry.LoggedSets[i]
+		query := `
+		INSERT INTO workout_sets (workout_entry_id, set_number, set_type, reps, duration_seconds, weight, rpe, rest_seconds)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+		`
+		err := tx.QueryRow(query,
+			entry.ID,
+			set.SetNumber,
+			string(set.SetType),
+			set.Reps,
+			set.DurationSeconds,
+			set.Weight,
+			set.RPE,
+			set.RestSeconds,
+		).Scan(&set.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadSets fills in the per-set log for a batch of entries with a single query
+func (pg *PostgresWorkoutStore) loadSets(entries []*WorkoutEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(entries))
+	byID := make(map[int]*WorkoutEntry, len(entries))
+	for i, entry := range entries {
+		ids[i] = int64(entry.ID)
+		byID[entry.ID] = entry
+		entry.LoggedSets = []WorkoutSet{}
+	}
+
+	query := `
+	SELECT workout_entry_id, id, set_number, set_type, reps, duration_seconds, weight, rpe, rest_seconds
+	FROM workout_sets
+	WHERE workout_entry_id = ANY($1)
+	ORDER BY workout_entry_id, set_number
+	`
+
+	rows, err := pg.db.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int
+		var set WorkoutSet
+		err = rows.Scan(
+			&entryID,
+			&set.ID,
+			&set.SetNumber,
+			&set.SetType,
+			&set.Reps,
+			&set.DurationSeconds,
+			&set.Weight,
+			&set.RPE,
+			&set.RestSeconds,
+		)
+		if err != nil {
+			return err
+		}
+		entry := byID[entryID]
+		entry.LoggedSets = append(entry.LoggedSets, set)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"time"
+
+	"github.com/gonstoll/workouts/internal/tokens"
+)
+
+// ShareLink lets anyone holding its token see a workout without logging in
+type ShareLink struct {
+	ID        int        `json:"id"`
+	WorkoutID int        `json:"workout_id"`
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// CreateShareLink fills in the link's token. It's the only time it can be
+// seen, since only its hash is kept.
+func (pg *PostgresWorkoutStore) CreateShareLink(link *ShareLink) error {
+	token, hash, err := tokens.GenerateShareToken()
+	if err != nil {
+		return err
+	}
+	link.Token = token
+
+	query := `
+	INSERT INTO workout_share_links (workout_id, hash, expires_at)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at
+	`
+
+	err = pg.db.QueryRow(query, link.WorkoutID, hash, link.ExpiresAt).Scan(&link.ID, &link.CreatedAt)
+	return translateError(err)
+}
+
+// ListShareLinks returns the workout's links that haven't expired, newest
+// first
+func (pg *PostgresWorkoutStore) ListShareLinks(workoutID int64) ([]*ShareLink, error) {
+	query := `
+	SELECT id, workout_id, expires_at, created_at
+	FROM workout_share_links
+	WHERE workout_id = $1 AND (expires_at IS NULL OR expires_at > $2)
+	ORDER BY created_at DESC, id DESC
+	`
+
+	rows, err := pg.db.Query(query, workoutID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*ShareLink{}
+	for rows.Next() {
+		var link ShareLink
+		err = rows.Scan(&link.ID, &link.WorkoutID, &link.ExpiresAt, &link.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, &link)
+	}
+
+	return links, rows.Err()
+}
+
+// DeleteShareLink revokes a link to one of the user's workouts
+func (pg *PostgresWorkoutStore) DeleteShareLink(id int64, userID int) error {
+	query := `
+	DELETE FROM workout_share_links l
+	USING workouts w
+	WHERE l.id = $1 AND w.id = l.workout_id AND w.user_id = $2
+	`
+
+	result, err := pg.db.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// SharedWorkoutView is the read-only copy of a workout behind a share link.
+// Whoever holds the link doesn't need to know how the workout ties into its
+// owner's account, so it leaves out every ID along with the template, program
+// and visibility.
+type SharedWorkoutView struct {
+	Username string      `json:"username"`
+	Workout  WorkoutView `json:"workout"`
+}
+
+type WorkoutView struct {
+	Title           string      `json:"title"`
+	Description     string      `json:"description"`
+	DurationMinutes int         `json:"duration_minutes"`
+	CaloriesBurned  int         `json:"calories_burned"`
+	PerformedAt     time.Time   `json:"performed_at"`
+	Entries         []EntryView `json:"entries"`
+}
+
+type EntryView struct {
+	ExerciseName    string    `json:"exercise_name"`
+	Sets            int       `json:"sets"`
+	Reps            *int      `json:"reps"`
+	DurationSeconds *int      `json:"duration_seconds"`
+	Weight          *float64  `json:"weight"`
+	Notes           string    `json:"notes"`
+	LoggedSets      []SetView `json:"logged_sets"`
+}
+
+type SetView struct {
+	SetNumber       int      `json:"set_number"`
+	SetType         SetType  `json:"set_type"`
+	Reps            *int     `json:"reps"`
+	DurationSeconds *int     `json:"duration_seconds"`
+	Weight          *float64 `json:"weight"`
+	RPE             *float64 `json:"rpe"`
+	RestSeconds     *int     `json:"rest_seconds"`
+}
+
+func (s *SharedWorkout) View() *SharedWorkoutView {
+	w := s.Workout
+	view := &SharedWorkoutView{
+		Username: s.Username,
+		Workout: WorkoutView{
+			Title:           w.Title,
+			Description:     w.Description,
+			DurationMinutes: w.DurationMinutes,
+			CaloriesBurned:  w.CaloriesBurned,
+			PerformedAt:     w.PerformedAt,
+			Entries:         make([]EntryView, 0, len(w.Entries)),
+		},
+	}
+
+	for _, entry := range w.Entries {
+		entryView := EntryView{
+			ExerciseName:    entry.ExerciseName,
+			Sets:            entry.Sets,
+			Reps:            entry.Reps,
+			DurationSeconds: entry.DurationSeconds,
+			Weight:          entry.Weight,
+			Notes:           entry.Notes,
+			LoggedSets:      make([]SetView, 0, len(entry.LoggedSets)),
+		}
+		for _, set := range entry.LoggedSets {
+			entryView.LoggedSets = append(entryView.LoggedSets, SetView{
+				SetNumber:       set.SetNumber,
+				SetType:         set.SetType,
+				Reps:            set.Reps,
+				DurationSeconds: set.DurationSeconds,
+				Weight:          set.Weight,
+				RPE:             set.RPE,
+				RestSeconds:     set.RestSeconds,
+			})
+		}
+		view.Workout.Entries = append(view.Workout.Entries, entryView)
+	}
+
+	return view
+}
+
+// GetSharedWorkout returns the workout a share link points to, or ErrNotFound
+// when the token is unknown, expired or revoked, or the owner has been
+// disabled
+func (pg *PostgresWorkoutStore) GetSharedWorkout(token string) (*SharedWorkout, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	query := `
+	SELECT ` + workoutColumns + `, u.username
+	FROM workout_share_links l
+	JOIN workouts w ON w.id = l.workout_id
+	JOIN users u ON u.id = w.user_id
+	WHERE l.hash = $1 AND (l.expires_at IS NULL OR l.expires_at > $2) AND u.disabled_at IS NULL
+	`
+
+	shared := &SharedWorkout{Workout: &Workout{}}
+	err := pg.db.QueryRow(query, hash[:], time.Now()).Scan(append(workoutDest(shared.Workout), &shared.Username)...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = pg.loadEntries([]*Workout{shared.Workout})
+	if err != nil {
+		return nil, err
+	}
+
+	return shared, nil
+}
@@ -1,26 +1,120 @@
 package store
 
-import "database/sql"
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
 
 type Workout struct {
 	ID              int            `json:"id"`
 	UserID          int            `json:"user_id"`
+	TemplateID      *int           `json:"template_id"`
+	EnrollmentID    *int           `json:"enrollment_id"`
 	Title           string         `json:"title"`
 	Description     string         `json:"description"`
 	DurationMinutes int            `json:"duration_minutes"`
 	CaloriesBurned  int            `json:"calories_burned"`
+	Visibility      Visibility     `json:"visibility"`
+	PerformedAt     time.Time      `json:"performed_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
 	Entries         []WorkoutEntry `json:"entries"`
+	// Records set by this workout, only filled in when it's created or updated
+	NewRecords []PersonalRecord `json:"new_records,omitempty"`
+	// Where the program stands after this workout, only filled in when it's
+	// created as part of one
+	Enrollment *Enrollment `json:"enrollment,omitempty"`
 }
 
 type WorkoutEntry struct {
-	ID              int      `json:"id"`
-	ExerciseName    string   `json:"exercise_name"`
-	Sets            int      `json:"sets"`
-	Reps            *int     `json:"reps"`
-	DurationSeconds *int     `json:"duration_seconds"`
-	Weight          *float64 `json:"weight"`
-	Notes           string   `json:"notes"`
-	OrderIndex      int      `json:"order_index"`
+	ID              int          `json:"id"`
+	ExerciseID      *int         `json:"exercise_id"`
+	ExerciseName    string       `json:"exercise_name"`
+	Sets            int          `json:"sets"`
+	Reps            *int         `json:"reps"`
+	DurationSeconds *int         `json:"duration_seconds"`
+	Weight          *float64     `json:"weight"`
+	Notes           string       `json:"notes"`
+	OrderIndex      int          `json:"order_index"`
+	LoggedSets      []WorkoutSet `json:"logged_sets"`
+}
+
+// Visibility is who besides its owner can see a workout
+type Visibility string
+
+const (
+	VisibilityPrivate   Visibility = "private"
+	VisibilityFollowers Visibility = "followers"
+	VisibilityPublic    Visibility = "public"
+)
+
+func (v Visibility) Valid() bool {
+	return v == VisibilityPrivate || v == VisibilityFollowers || v == VisibilityPublic
+}
+
+// workoutColumns and workoutDest keep every query that loads a whole workout
+// in sync. Queries must alias workouts as w.
+const workoutColumns = `w.id, w.user_id, w.template_id, w.enrollment_id, w.title, COALESCE(w.description, ''), w.duration_minutes, COALESCE(w.calories_burned, 0), w.visibility, w.performed_at, w.created_at, w.updated_at`
+
+func workoutDest(workout *Workout) []any {
+	return []any{
+		&workout.ID,
+		&workout.UserID,
+		&workout.TemplateID,
+		&workout.EnrollmentID,
+		&workout.Title,
+		&workout.Description,
+		&workout.DurationMinutes,
+		&workout.CaloriesBurned,
+		&workout.Visibility,
+		&workout.PerformedAt,
+		&workout.CreatedAt,
+		&workout.UpdatedAt,
+	}
+}
+
+type WorkoutSort string
+
+const (
+	SortPerformedAtDesc WorkoutSort = "-performed_at"
+	SortPerformedAtAsc  WorkoutSort = "performed_at"
+	SortCreatedAtDesc   WorkoutSort = "-created_at"
+	SortCreatedAtAsc    WorkoutSort = "created_at"
+)
+
+func (s WorkoutSort) Valid() bool {
+	switch s {
+	case SortPerformedAtDesc, SortPerformedAtAsc, SortCreatedAtDesc, SortCreatedAtAsc:
+		return true
+	}
+	return false
+}
+
+func (s WorkoutSort) column() string {
+	return "w." + strings.TrimPrefix(string(s), "-")
+}
+
+func (s WorkoutSort) descending() bool {
+	return strings.HasPrefix(string(s), "-")
+}
+
+// WorkoutFilter narrows down ListWorkouts. Zero values are ignored. From and To
+// apply to performed_at.
+type WorkoutFilter struct {
+	UserID       int
+	From         *time.Time
+	To           *time.Time
+	Title        string
+	MinDuration  *int
+	MaxDuration  *int
+	ExerciseID   *int
+	ExerciseName string
+	Sort         WorkoutSort
+	Cursor       *Cursor
+	Limit        int
 }
 
 type PostgresWorkoutStore struct {
@@ -34,36 +128,67 @@ func NewPostgresWorkoutStore(db *sql.DB) *PostgresWorkoutStore {
 type WorkoutStore interface {
 	CreateWorkout(*Workout) (*Workout, error)
 	GetWorkoutByID(id int64, userID int) (*Workout, error)
+	GetVisibleWorkout(id int64, viewerID int) (*Workout, error)
+	ListWorkouts(filter WorkoutFilter) ([]*Workout, *Cursor, error)
+	GetLastEntries(userID int, exerciseIDs []int) (map[int]WorkoutEntry, error)
+	SearchWorkouts(userID int, search string, limit, offset int) ([]*SearchResult, error)
 	UpdateWorkout(*Workout) error
 	DeleteWorkout(id int64) error
 	GetWorkoutOwner(id int64) (int, error)
+	EachWorkout(userID int, fn func(*Workout) error) error
+	ListSharedWorkouts(filter SharedFilter) ([]*SharedWorkout, *Cursor, error)
+	CreateShareLink(link *ShareLink) error
+	ListShareLinks(workoutID int64) ([]*ShareLink, error)
+	DeleteShareLink(id int64, userID int) error
+	GetSharedWorkout(token string) (*SharedWorkout, error)
 }
 
-func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error) {
+func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (_ *Workout, err error) {
+	defer translate(&err)
+
 	tx, err := pg.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
+	// Workouts logged without a date are assumed to have happened just now
+	var performedAt *time.Time
+	if !workout.PerformedAt.IsZero() {
+		performedAt = &workout.PerformedAt
+	}
+	if workout.Visibility == "" {
+		workout.Visibility = VisibilityPrivate
+	}
+
 	query := `
-	INSERT INTO workouts (user_id, title, description, duration_minutes, calories_burned)
-	VALUES ($1, $2, $3, $4, $5)
-	RETURNING id
+	INSERT INTO workouts (user_id, title, description, duration_minutes, calories_burned, performed_at, template_id, enrollment_id, visibility)
+	VALUES ($1, $2, $3, $4, $5, COALESCE($6, CURRENT_TIMESTAMP), (SELECT id FROM workout_templates WHERE id = $7 AND user_id = $1), $8, $9)
+	RETURNING id, template_id, performed_at, created_at, updated_at
 	`
-	err = tx.QueryRow(query, workout.UserID, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned).Scan(&workout.ID)
+	err = tx.QueryRow(query, workout.UserID, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, performedAt, workout.TemplateID, workout.EnrollmentID, workout.Visibility).Scan(
+		&workout.ID,
+		&workout.TemplateID,
+		&workout.PerformedAt,
+		&workout.CreatedAt,
+		&workout.UpdatedAt,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	for i := range workout.Entries {
-		entry := &workout.Entries[i]
-		query := `
-		INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id
-		`
-		err = tx.QueryRow(query, workout.ID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+	err = insertEntries(tx, workout)
+	if err != nil {
+		return nil, err
+	}
+
+	workout.NewRecords, err = detectRecords(tx, workout)
+	if err != nil {
+		return nil, err
+	}
+
+	if workout.EnrollmentID != nil {
+		workout.Enrollment, err = advanceEnrollment(tx, workout)
 		if err != nil {
 			return nil, err
 		}
@@ -80,54 +205,272 @@ func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error
 func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64, userID int) (*Workout, error) {
 	workout := &Workout{}
 	query := `
-	SELECT id, title, description, duration_minutes, calories_burned
-	FROM workouts
-	WHERE id = $1 AND user_id = $2
+	SELECT ` + workoutColumns + `
+	FROM workouts w
+	WHERE w.id = $1 AND w.user_id = $2
 	`
-	err := pg.db.QueryRow(query, id, userID).Scan(&workout.ID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned)
+	err := pg.db.QueryRow(query, id, userID).Scan(workoutDest(workout)...)
 	if err == sql.ErrNoRows {
-		return nil, nil
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
+
+	err = pg.loadEntries([]*Workout{workout})
 	if err != nil {
 		return nil, err
 	}
 
-	// Get workout entries
-	entryQuery := `
-	SELECT id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
+	return workout, nil
+}
+
+// likeEscaper makes user input match literally inside LIKE and ILIKE patterns
+// that declare ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// ListWorkouts returns a page of the user's workouts, most recently performed
+// first unless the filter says otherwise, along with the cursor for the next
+// page (nil when there are no more).
+func (pg *PostgresWorkoutStore) ListWorkouts(filter WorkoutFilter) ([]*Workout, *Cursor, error) {
+	conditions := []string{"w.user_id = $1"}
+	args := []any{filter.UserID}
+
+	addCondition := func(format string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if filter.From != nil {
+		addCondition("w.performed_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("w.performed_at < $%d", *filter.To)
+	}
+	if filter.Title != "" {
+		addCondition(`w.title ILIKE '%%' || $%d || '%%' ESCAPE '\'`, escapeLike(filter.Title))
+	}
+	if filter.MinDuration != nil {
+		addCondition("w.duration_minutes >= $%d", *filter.MinDuration)
+	}
+	if filter.MaxDuration != nil {
+		addCondition("w.duration_minutes <= $%d", *filter.MaxDuration)
+	}
+	if filter.ExerciseID != nil {
+		addCondition(`EXISTS (
+			SELECT 1 FROM workout_entries we
+			WHERE we.workout_id = w.id AND we.exercise_id = $%d
+		)`, *filter.ExerciseID)
+	}
+	if filter.ExerciseName != "" {
+		// Match through the catalog so aliases find the same workouts
+		addCondition(`EXISTS (
+			SELECT 1 FROM workout_entries we
+			JOIN exercises e ON e.id = we.exercise_id
+			WHERE we.workout_id = w.id AND (
+				e.name ILIKE $%[1]d ESCAPE '\'
+				OR EXISTS (SELECT 1 FROM exercise_aliases a WHERE a.exercise_id = e.id AND a.alias ILIKE $%[1]d ESCAPE '\')
+			)
+		)`, escapeLike(filter.ExerciseName))
+	}
+	sort := filter.Sort
+	if sort == "" {
+		sort = SortPerformedAtDesc
+	}
+	column, comparison, direction := sort.column(), ">", "ASC"
+	if sort.descending() {
+		comparison, direction = "<", "DESC"
+	}
+
+	if filter.Cursor != nil {
+		args = append(args, filter.Cursor.Time, filter.Cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, w.id) %s ($%d, $%d)", column, comparison, len(args)-1, len(args)))
+	}
+
+	// Fetch one extra row so we know whether there's a next page
+	args = append(args, filter.Limit+1)
+	query := fmt.Sprintf(`
+	SELECT `+workoutColumns+`
+	FROM workouts w
+	WHERE %s
+	ORDER BY %s %s, w.id %s
+	LIMIT $%d
+	`, strings.Join(conditions, " AND "), column, direction, direction, len(args))
+
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	workouts := []*Workout{}
+	for rows.Next() {
+		var workout Workout
+		err = rows.Scan(workoutDest(&workout)...)
+		if err != nil {
+			return nil, nil, err
+		}
+		workouts = append(workouts, &workout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	if len(workouts) > filter.Limit {
+		workouts = workouts[:filter.Limit]
+		last := workouts[len(workouts)-1]
+		next = &Cursor{Time: last.CreatedAt, ID: last.ID}
+		if sort == SortPerformedAtAsc || sort == SortPerformedAtDesc {
+			next.Time = last.PerformedAt
+		}
+	}
+
+	err = pg.loadEntries(workouts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return workouts, next, nil
+}
+
+// loadEntries fills in the entries, and their sets, for a batch of workouts
+// with a single query per table
+func (pg *PostgresWorkoutStore) loadEntries(workouts []*Workout) error {
+	if len(workouts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(workouts))
+	byID := make(map[int]*Workout, len(workouts))
+	for i, workout := range workouts {
+		ids[i] = int64(workout.ID)
+		byID[workout.ID] = workout
+		workout.Entries = []WorkoutEntry{}
+	}
+
+	query := `
+	SELECT workout_id, id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
 	FROM workout_entries
-	WHERE workout_id = $1
-	ORDER BY order_index
+	WHERE workout_id = ANY($1)
+	ORDER BY workout_id, order_index
 	`
 
-	rows, err := pg.db.Query(entryQuery, id)
+	rows, err := pg.db.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var workoutID int
+		var entry WorkoutEntry
+		var notes sql.NullString
+		err = rows.Scan(
+			&workoutID,
+			&entry.ID,
+			&entry.ExerciseID,
+			&entry.ExerciseName,
+			&entry.Sets,
+			&entry.Reps,
+			&entry.DurationSeconds,
+			&entry.Weight,
+			&notes,
+			&entry.OrderIndex,
+		)
+		if err != nil {
+			return err
+		}
+		entry.Notes = notes.String
+		workout := byID[workoutID]
+		workout.Entries = append(workout.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	entries := []*WorkoutEntry{}
+	for _, workout := range workouts {
+		for i := range workout.Entries {
+			entries = append(entries, &workout.Entries[i])
+		}
+	}
+
+	return pg.loadSets(entries)
+}
+
+// GetLastEntries returns, for each exercise, the entry from the most recently
+// performed workout that included it, sets and all
+func (pg *PostgresWorkoutStore) GetLastEntries(userID int, exerciseIDs []int) (map[int]WorkoutEntry, error) {
+	last := map[int]WorkoutEntry{}
+	if len(exerciseIDs) == 0 {
+		return last, nil
+	}
+
+	ids := make([]int64, len(exerciseIDs))
+	for i, id := range exerciseIDs {
+		ids[i] = int64(id)
+	}
+
+	query := `
+	SELECT DISTINCT ON (we.exercise_id)
+		we.id, we.exercise_id, we.exercise_name, we.sets, we.reps, we.duration_seconds, we.weight, we.notes, we.order_index
+	FROM workout_entries we
+	JOIN workouts w ON w.id = we.workout_id
+	WHERE w.user_id = $1 AND we.exercise_id = ANY($2)
+	ORDER BY we.exercise_id, w.performed_at DESC, we.id DESC
+	`
+
+	rows, err := pg.db.Query(query, userID, ids)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	entries := []*WorkoutEntry{}
 	for rows.Next() {
 		var entry WorkoutEntry
+		var notes sql.NullString
 		err = rows.Scan(
 			&entry.ID,
+			&entry.ExerciseID,
 			&entry.ExerciseName,
 			&entry.Sets,
 			&entry.Reps,
 			&entry.DurationSeconds,
 			&entry.Weight,
-			&entry.Notes,
+			&notes,
 			&entry.OrderIndex,
 		)
 		if err != nil {
 			return nil, err
 		}
-		workout.Entries = append(workout.Entries, entry)
+		entry.Notes = notes.String
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return workout, nil
+	err = pg.loadSets(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		last[*entry.ExerciseID] = *entry
+	}
+
+	return last, nil
 }
 
-func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
+func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) (err error) {
+	defer translate(&err)
+
 	tx, err := pg.db.Begin()
 	if err != nil {
 		return err
@@ -136,36 +479,96 @@ func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
 
 	query := `
 	UPDATE workouts
-	SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4
-	WHERE id = $5
+	SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4, performed_at = $5, visibility = $6, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $7
+	RETURNING updated_at
 	`
-	results, err := tx.Exec(query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, workout.ID)
+	err = tx.QueryRow(query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, workout.PerformedAt, workout.Visibility, workout.ID).Scan(&workout.UpdatedAt)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := results.RowsAffected()
+	exerciseIDs, err := workoutExercises(tx, int64(workout.ID))
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	_, err = tx.Exec(`DELETE FROM workout_entries WHERE workout_id = $1`, workout.ID)
+	if err != nil {
+		return err
 	}
 
-	_, err = tx.Exec(`DELETE FROM workout_entries WHERE workout_id = $1`, workout.ID)
+	err = insertEntries(tx, workout)
 	if err != nil {
 		return err
 	}
 
 	for _, entry := range workout.Entries {
+		if !slices.Contains(exerciseIDs, *entry.ExerciseID) {
+			exerciseIDs = append(exerciseIDs, *entry.ExerciseID)
+		}
+	}
+
+	records, err := recomputeRecords(tx, workout.UserID, exerciseIDs)
+	if err != nil {
+		return err
+	}
+
+	workout.NewRecords = []PersonalRecord{}
+	for _, record := range records {
+		if record.WorkoutID == workout.ID {
+			workout.NewRecords = append(workout.NewRecords, record)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// workoutExercises returns the exercises the workout's entries logged
+func workoutExercises(tx *sql.Tx, workoutID int64) ([]int, error) {
+	rows, err := tx.Query(`SELECT DISTINCT exercise_id FROM workout_entries WHERE workout_id = $1`, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exerciseIDs := []int{}
+	for rows.Next() {
+		var exerciseID int
+		err = rows.Scan(&exerciseID)
+		if err != nil {
+			return nil, err
+		}
+		exerciseIDs = append(exerciseIDs, exerciseID)
+	}
+
+	return exerciseIDs, rows.Err()
+}
+
+// insertEntries writes the workout's entries and their sets, linking each
+// entry to the exercise catalog first
+func insertEntries(tx *sql.Tx, workout *Workout) error {
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		exerciseID, name, err := resolveExercise(tx, workout.UserID, entry.ExerciseID, entry.ExerciseName)
+		if err != nil {
+			return err
+		}
+		entry.ExerciseID, entry.ExerciseName = &exerciseID, name
+
+		err = normalizeSets(entry)
+		if err != nil {
+			return err
+		}
+
 		query := `
-		INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO workout_entries (workout_id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
 		`
-
-		_, err := tx.Exec(query,
+		err = tx.QueryRow(query,
 			workout.ID,
+			entry.ExerciseID,
 			entry.ExerciseName,
 			entry.Sets,
 			entry.Reps,
@@ -173,36 +576,55 @@ func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
 			entry.Weight,
 			entry.Notes,
 			entry.OrderIndex,
-		)
+		).Scan(&entry.ID)
+		if err != nil {
+			return err
+		}
 
+		err = insertSets(tx, entry)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
+// DeleteWorkout recomputes the records of the exercises the workout logged in
+// the same transaction, since the workout's own records go with it
 func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exerciseIDs, err := workoutExercises(tx, id)
+	if err != nil {
+		return err
+	}
+
 	query := `
 	DELETE from workouts
 	WHERE id = $1
+	RETURNING user_id
 	`
 
-	result, err := pg.db.Exec(query, id)
+	var userID int
+	err = tx.QueryRow(query, id).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	_, err = recomputeRecords(tx, userID, exerciseIDs)
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
-	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int, error) {
@@ -216,8 +638,37 @@ func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int, error) {
 
 	err := pg.db.QueryRow(query, workoutID).Scan(&userID)
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 
 	return userID, nil
 }
+
+// exportPageSize is how many workouts EachWorkout holds in memory at a time
+const exportPageSize = 100
+
+// EachWorkout calls fn with every one of the user's workouts, entries and sets
+// included, oldest first. Workouts are loaded a page at a time so walking a
+// long history doesn't hold all of it in memory. An error from fn stops the
+// walk and is returned as is.
+func (pg *PostgresWorkoutStore) EachWorkout(userID int, fn func(*Workout) error) error {
+	filter := WorkoutFilter{UserID: userID, Sort: SortCreatedAtAsc, Limit: exportPageSize}
+	for {
+		workouts, next, err := pg.ListWorkouts(filter)
+		if err != nil {
+			return err
+		}
+
+		for _, workout := range workouts {
+			err = fn(workout)
+			if err != nil {
+				return err
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		filter.Cursor = next
+	}
+}
@@ -1,45 +1,16 @@
 package store
 
 import (
-	"database/sql"
 	"testing"
+	"time"
 
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/gonstoll/workouts/internal/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func IntPtr(i int) *int {
-	return &i
-}
-
-func FloatPtr(i float64) *float64 {
-	return &i
-}
-
-func setupTestDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("pgx", "host=localhost user=postgres password=postgres dbname=postgres port=5433 sslmode=disable")
-	if err != nil {
-		t.Fatalf("Opening test DB: %v", err)
-	}
-
-	// Run the migrations for our test DB
-	err = Migrate(db, "../../migrations/")
-	if err != nil {
-		t.Fatalf("Migrating test DB: %v", err)
-	}
-
-	// Reset test DB
-	_, err = db.Exec("TRUNCATE users, workouts, workout_entries CASCADE")
-	if err != nil {
-		t.Fatalf("Truncating test DB: %v", err)
-	}
-
-	return db
-}
-
 func TestCreateWorkout(t *testing.T) {
-	db := setupTestDB(t)
+	db := testutil.SetupTestDB(t)
 	defer db.Close()
 
 	store := NewPostgresWorkoutStore(db)
@@ -73,8 +44,8 @@ func TestCreateWorkout(t *testing.T) {
 					{
 						ExerciseName: "Bench press",
 						Sets:         3,
-						Reps:         IntPtr(10),
-						Weight:       FloatPtr(135.5),
+						Reps:         testutil.IntPtr(10),
+						Weight:       testutil.FloatPtr(135.5),
 						Notes:        "Warm up properly",
 						OrderIndex:   1,
 					},
@@ -82,6 +53,47 @@ func TestCreateWorkout(t *testing.T) {
 			},
 			wantError: false,
 		},
+		{
+			name: "Valid workout - per-set log",
+			workout: &Workout{
+				UserID:          testUser.ID,
+				Title:           "Pyramid",
+				Description:     "Bench pyramid",
+				DurationMinutes: 45,
+				Entries: []WorkoutEntry{
+					{
+						ExerciseName: "Bench press",
+						OrderIndex:   1,
+						LoggedSets: []WorkoutSet{
+							{SetType: SetTypeWarmup, Reps: testutil.IntPtr(12), Weight: testutil.FloatPtr(95)},
+							{Reps: testutil.IntPtr(10), Weight: testutil.FloatPtr(135)},
+							{Reps: testutil.IntPtr(8), Weight: testutil.FloatPtr(155), RestSeconds: testutil.IntPtr(120)},
+							{Reps: testutil.IntPtr(6), Weight: testutil.FloatPtr(175), RPE: testutil.FloatPtr(9.5)},
+						},
+					},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name: "Invalid workout - mixed reps and timed sets",
+			workout: &Workout{
+				UserID:          testUser.ID,
+				Title:           "Mixed",
+				DurationMinutes: 20,
+				Entries: []WorkoutEntry{
+					{
+						ExerciseName: "Plank",
+						OrderIndex:   1,
+						LoggedSets: []WorkoutSet{
+							{DurationSeconds: testutil.IntPtr(60)},
+							{Reps: testutil.IntPtr(10)},
+						},
+					},
+				},
+			},
+			wantError: true,
+		},
 		{
 			name: "Invalid workout - invalid entries",
 			workout: &Workout{
@@ -94,17 +106,17 @@ func TestCreateWorkout(t *testing.T) {
 					{
 						ExerciseName: "Plank",
 						Sets:         3,
-						Reps:         IntPtr(60),
-						Weight:       FloatPtr(135.5),
+						Reps:         testutil.IntPtr(60),
+						Weight:       testutil.FloatPtr(135.5),
 						Notes:        "Keep form",
 						OrderIndex:   1,
 					},
 					{
 						ExerciseName:    "Squats",
 						Sets:            4,
-						Reps:            IntPtr(12),
-						DurationSeconds: IntPtr(60), // <- This should fail, we can't have Reps and DurationSeconds
-						Weight:          FloatPtr(185.0),
+						Reps:            testutil.IntPtr(12),
+						DurationSeconds: testutil.IntPtr(60), // <- This should fail, we can't have Reps and DurationSeconds
+						Weight:          testutil.FloatPtr(185.0),
 						Notes:           "Full depth",
 						OrderIndex:      2,
 					},
@@ -126,6 +138,8 @@ func TestCreateWorkout(t *testing.T) {
 			assert.Equal(t, tt.workout.Title, createdWorkout.Title)
 			assert.Equal(t, tt.workout.Description, createdWorkout.Description)
 			assert.Equal(t, tt.workout.DurationMinutes, createdWorkout.DurationMinutes)
+			assert.False(t, createdWorkout.PerformedAt.IsZero())
+			assert.False(t, createdWorkout.CreatedAt.IsZero())
 
 			retrieved, err := store.GetWorkoutByID(int64(createdWorkout.ID), tt.workout.UserID)
 
@@ -137,7 +151,294 @@ func TestCreateWorkout(t *testing.T) {
 				assert.Equal(t, tt.workout.Entries[i].ExerciseName, entry.ExerciseName)
 				assert.Equal(t, tt.workout.Entries[i].Sets, entry.Sets)
 				assert.Equal(t, tt.workout.Entries[i].OrderIndex, entry.OrderIndex)
+				assert.Len(t, entry.LoggedSets, len(tt.workout.Entries[i].LoggedSets))
 			}
 		})
 	}
 }
+
+func TestNormalizeSets(t *testing.T) {
+	t.Run("Aggregates expand into working sets", func(t *testing.T) {
+		entry := &WorkoutEntry{Sets: 3, Reps: testutil.IntPtr(10), Weight: testutil.FloatPtr(135)}
+		require.NoError(t, normalizeSets(entry))
+		require.Len(t, entry.LoggedSets, 3)
+		assert.Equal(t, 3, entry.LoggedSets[2].SetNumber)
+		assert.Equal(t, SetTypeWorking, entry.LoggedSets[0].SetType)
+		assert.NotSame(t, entry.Weight, entry.LoggedSets[0].Weight)
+		assert.NotSame(t, entry.LoggedSets[0].Weight, entry.LoggedSets[1].Weight)
+	})
+
+	t.Run("Set log derives aggregates from the top set", func(t *testing.T) {
+		entry := &WorkoutEntry{
+			LoggedSets: []WorkoutSet{
+				{SetType: SetTypeWarmup, Reps: testutil.IntPtr(12), Weight: testutil.FloatPtr(95)},
+				{Reps: testutil.IntPtr(10), Weight: testutil.FloatPtr(135)},
+				{Reps: testutil.IntPtr(6), Weight: testutil.FloatPtr(175)},
+				{SetType: SetTypeDrop, Reps: testutil.IntPtr(12), Weight: testutil.FloatPtr(115)},
+			},
+		}
+		require.NoError(t, normalizeSets(entry))
+		assert.Equal(t, 3, entry.Sets)
+		assert.Equal(t, 6, *entry.Reps)
+		assert.Equal(t, 175.0, *entry.Weight)
+		assert.NotSame(t, entry.LoggedSets[2].Weight, entry.Weight)
+		assert.Nil(t, entry.DurationSeconds)
+	})
+
+	t.Run("Invalid set type is rejected", func(t *testing.T) {
+		entry := &WorkoutEntry{LoggedSets: []WorkoutSet{{SetType: "superset", Reps: testutil.IntPtr(5)}}}
+		assert.ErrorIs(t, normalizeSets(entry), ErrInvalidSets)
+	})
+}
+
+func TestListWorkouts(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresWorkoutStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	testUser := &User{
+		Username: "gonzalo",
+		Email:    "gonzalo@example.com",
+	}
+
+	err := testUser.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+
+	err = userStore.CreateUser(testUser)
+	require.NoError(t, err)
+
+	workouts := []*Workout{
+		{
+			UserID:          testUser.ID,
+			Title:           "Push day",
+			DurationMinutes: 60,
+			PerformedAt:     time.Date(2025, 1, 6, 18, 0, 0, 0, time.UTC),
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Bench press", Sets: 3, Reps: testutil.IntPtr(10), OrderIndex: 1},
+			},
+		},
+		{
+			UserID:          testUser.ID,
+			Title:           "Pull day",
+			DurationMinutes: 45,
+			PerformedAt:     time.Date(2025, 1, 8, 18, 0, 0, 0, time.UTC),
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Deadlift", Sets: 3, Reps: testutil.IntPtr(5), OrderIndex: 1},
+			},
+		},
+		{
+			UserID:          testUser.ID,
+			Title:           "Push day again",
+			DurationMinutes: 30,
+			PerformedAt:     time.Date(2025, 1, 10, 18, 0, 0, 0, time.UTC),
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Bench press", Sets: 5, Reps: testutil.IntPtr(5), OrderIndex: 1},
+				{ExerciseName: "Dips", Sets: 3, Reps: testutil.IntPtr(12), OrderIndex: 2},
+			},
+		},
+	}
+	for _, workout := range workouts {
+		_, err := store.CreateWorkout(workout)
+		require.NoError(t, err)
+	}
+
+	t.Run("Paginates newest first", func(t *testing.T) {
+		page, next, err := store.ListWorkouts(WorkoutFilter{UserID: testUser.ID, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		require.NotNil(t, next)
+		assert.Equal(t, workouts[2].ID, page[0].ID)
+		assert.Equal(t, workouts[1].ID, page[1].ID)
+		assert.Len(t, page[0].Entries, 2)
+
+		page, next, err = store.ListWorkouts(WorkoutFilter{UserID: testUser.ID, Limit: 2, Cursor: next})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Nil(t, next)
+		assert.Equal(t, workouts[0].ID, page[0].ID)
+	})
+
+	t.Run("Sorts and filters by performed_at", func(t *testing.T) {
+		from := time.Date(2025, 1, 7, 0, 0, 0, 0, time.UTC)
+		page, _, err := store.ListWorkouts(WorkoutFilter{UserID: testUser.ID, Limit: 10, From: &from, Sort: SortPerformedAtAsc})
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, workouts[1].ID, page[0].ID)
+		assert.Equal(t, workouts[2].ID, page[1].ID)
+	})
+
+	t.Run("Filters by title, duration and exercise", func(t *testing.T) {
+		page, _, err := store.ListWorkouts(WorkoutFilter{UserID: testUser.ID, Limit: 10, Title: "push"})
+		require.NoError(t, err)
+		assert.Len(t, page, 2)
+
+		page, _, err = store.ListWorkouts(WorkoutFilter{UserID: testUser.ID, Limit: 10, MinDuration: testutil.IntPtr(40), MaxDuration: testutil.IntPtr(50)})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, workouts[1].ID, page[0].ID)
+
+		page, _, err = store.ListWorkouts(WorkoutFilter{UserID: testUser.ID, Limit: 10, ExerciseName: "dips"})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, workouts[2].ID, page[0].ID)
+	})
+
+	t.Run("Wildcards in filters match literally", func(t *testing.T) {
+		page, _, err := store.ListWorkouts(WorkoutFilter{UserID: testUser.ID, Limit: 10, Title: "%"})
+		require.NoError(t, err)
+		assert.Empty(t, page)
+
+		page, _, err = store.ListWorkouts(WorkoutFilter{UserID: testUser.ID, Limit: 10, ExerciseName: "_ips"})
+		require.NoError(t, err)
+		assert.Empty(t, page)
+	})
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, `100\%`, escapeLike("100%"))
+	assert.Equal(t, `\_`, escapeLike("_"))
+	assert.Equal(t, `a\\b`, escapeLike(`a\b`))
+	assert.Equal(t, "push", escapeLike("push"))
+}
+
+func TestSearchWorkouts(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresWorkoutStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	users := []*User{
+		{Username: "gonzalo", Email: "gonzalo@example.com"},
+		{Username: "other", Email: "other@example.com"},
+	}
+	for _, user := range users {
+		err := user.PasswordHash.Set("securepassword")
+		require.NoError(t, err)
+		err = userStore.CreateUser(user)
+		require.NoError(t, err)
+	}
+
+	workouts := []*Workout{
+		{
+			UserID:          users[0].ID,
+			Title:           "Squat session",
+			Description:     "Heavy squats",
+			DurationMinutes: 60,
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Squat", Sets: 5, Reps: testutil.IntPtr(5), Notes: "Squats felt fast", OrderIndex: 1},
+			},
+		},
+		{
+			UserID:          users[0].ID,
+			Title:           "Upper body",
+			DurationMinutes: 45,
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Bench press", Sets: 3, Reps: testutil.IntPtr(8), OrderIndex: 1},
+				{ExerciseName: "Dips", Sets: 3, Reps: testutil.IntPtr(10), Notes: "Finished with squats <img src=x onerror=alert(1)>", OrderIndex: 2},
+			},
+		},
+		{
+			UserID:          users[1].ID,
+			Title:           "Squat day",
+			DurationMinutes: 30,
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Squat", Sets: 3, Reps: testutil.IntPtr(5), OrderIndex: 1},
+			},
+		},
+	}
+	for _, workout := range workouts {
+		_, err := store.CreateWorkout(workout)
+		require.NoError(t, err)
+	}
+
+	results, err := store.SearchWorkouts(users[0].ID, "squat", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, workouts[0].ID, results[0].Workout.ID)
+	assert.Greater(t, results[0].Rank, results[1].Rank)
+	assert.Contains(t, results[0].Highlights.Title, "<mark>Squat</mark>")
+	assert.Len(t, results[0].Workout.Entries, 1)
+
+	assert.Equal(t, workouts[1].ID, results[1].Workout.ID)
+	require.Len(t, results[1].Highlights.Entries, 1)
+	assert.Equal(t, workouts[1].Entries[1].ID, results[1].Highlights.Entries[0].EntryID)
+	assert.Contains(t, results[1].Highlights.Entries[0].Notes, "<mark>squats</mark>")
+	assert.Contains(t, results[1].Highlights.Entries[0].Notes, "&lt;img")
+	assert.NotContains(t, results[1].Highlights.Entries[0].Notes, "<img")
+
+	results, err = store.SearchWorkouts(users[0].ID, "deadlift", 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestToHighlightHTML(t *testing.T) {
+	headline := "Front " + highlightStart + "squat" + highlightStop + ` <script>alert("x")</script> & more`
+	assert.Equal(t, `Front <mark>squat</mark> &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; more`, toHighlightHTML(headline))
+}
+
+func TestShareLinks(t *testing.T) {
+	db := testutil.SetupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresWorkoutStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	owner := &User{Username: "gonzalo", Email: "gonzalo@example.com"}
+	err := owner.PasswordHash.Set("securepassword")
+	require.NoError(t, err)
+	err = userStore.CreateUser(owner)
+	require.NoError(t, err)
+
+	workout, err := store.CreateWorkout(&Workout{
+		UserID:          owner.ID,
+		Title:           "Leg day",
+		DurationMinutes: 60,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Squat", Sets: 3, Reps: testutil.IntPtr(5), Weight: testutil.FloatPtr(100), OrderIndex: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	link := &ShareLink{WorkoutID: workout.ID}
+	err = store.CreateShareLink(link)
+	require.NoError(t, err)
+	require.NotEmpty(t, link.Token)
+
+	// Private workouts can still be shared by link
+	shared, err := store.GetSharedWorkout(link.Token)
+	require.NoError(t, err)
+	require.NotNil(t, shared)
+	assert.Equal(t, "gonzalo", shared.Username)
+	assert.Equal(t, workout.ID, shared.Workout.ID)
+	assert.Len(t, shared.Workout.Entries, 1)
+
+	shared, err = store.GetSharedWorkout("not-a-token")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	past := time.Now().Add(-time.Minute)
+	expired := &ShareLink{WorkoutID: workout.ID, ExpiresAt: &past}
+	err = store.CreateShareLink(expired)
+	require.NoError(t, err)
+
+	shared, err = store.GetSharedWorkout(expired.Token)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	links, err := store.ListShareLinks(int64(workout.ID))
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, link.ID, links[0].ID)
+	assert.Empty(t, links[0].Token)
+
+	// Only the owner can revoke a link
+	err = store.DeleteShareLink(int64(link.ID), owner.ID+1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = store.DeleteShareLink(int64(link.ID), owner.ID)
+	require.NoError(t, err)
+
+	shared, err = store.GetSharedWorkout(link.Token)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,72 @@
+// Package testutil holds what the database tests of several packages share
+package testutil
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/gonstoll/workouts/migrations"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/pressly/goose/v3"
+)
+
+const testDSN = "host=localhost user=postgres password=postgres dbname=postgres port=5433 sslmode=disable"
+
+// SetupTestDB returns a connection to a freshly migrated schema of the test
+// database that only this test uses. go test runs packages in parallel, so
+// sharing tables would let one package's tests wipe another's data. The
+// schema is dropped once the test is done.
+func SetupTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	admin, err := sql.Open("pgx", testDSN)
+	if err != nil {
+		t.Fatalf("Opening test DB: %v", err)
+	}
+
+	schema := "test_" + strings.ToLower(rand.Text())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		admin.Close()
+		t.Fatalf("Creating test schema: %v", err)
+	}
+
+	db, err := sql.Open("pgx", testDSN+" search_path="+schema)
+	if err != nil {
+		t.Fatalf("Opening test DB: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Errorf("Dropping test schema: %v", err)
+		}
+		admin.Close()
+	})
+
+	goose.SetBaseFS(migrations.FS)
+	defer goose.SetBaseFS(nil)
+
+	err = goose.SetDialect("postgres")
+	if err != nil {
+		t.Fatalf("Migrating test DB: %v", err)
+	}
+
+	err = goose.Up(db, ".")
+	if err != nil {
+		t.Fatalf("Migrating test DB: %v", err)
+	}
+
+	return db
+}
+
+func IntPtr(i int) *int {
+	return &i
+}
+
+func FloatPtr(i float64) *float64 {
+	return &i
+}
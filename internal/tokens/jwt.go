@@ -0,0 +1,157 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidJWT = errors.New("jwt is invalid or expired")
+
+// AccessClaims are what a signed access token carries, enough to serve most
+// requests without loading the user
+type AccessClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	UserID    int    `json:"uid"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Activated bool   `json:"activated"`
+	Unit      string `json:"unit"`
+	// TokenID and FamilyID point at the session's rows in the tokens table, so
+	// a signed token can still be logged out
+	TokenID   int    `json:"jti,string"`
+	FamilyID  string `json:"fid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SigningKey is an Ed25519 key and the kid it's published under
+type SigningKey struct {
+	ID         string
+	PrivateKey ed25519.PrivateKey
+}
+
+// Signer signs access tokens with its first key and accepts tokens signed by
+// any of them. Rotating means putting a new key first and dropping the old one
+// once its tokens have expired.
+type Signer struct {
+	issuer string
+	keys   []SigningKey
+}
+
+func NewSigner(issuer string, keys ...SigningKey) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwt signer needs at least one key")
+	}
+
+	for _, key := range keys {
+		if key.ID == "" || len(key.PrivateKey) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("invalid signing key %q", key.ID)
+		}
+	}
+
+	return &Signer{issuer: issuer, keys: keys}, nil
+}
+
+// IsJWT tells signed tokens apart from opaque ones, which never contain a dot
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func encodeSegment(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (s *Signer) Sign(claims AccessClaims) (string, error) {
+	key := s.keys[0]
+	claims.Issuer = s.issuer
+	claims.Subject = strconv.Itoa(claims.UserID)
+
+	header, err := encodeSegment(map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + payload
+	signature := ed25519.Sign(key.PrivateKey, []byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature, issuer and expiry and returns the claims
+func (s *Signer) Verify(token string) (*AccessClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidJWT
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &header) != nil || header.Alg != "EdDSA" {
+		return nil, ErrInvalidJWT
+	}
+
+	var publicKey ed25519.PublicKey
+	for _, key := range s.keys {
+		if key.ID == header.Kid {
+			publicKey = key.PrivateKey.Public().(ed25519.PublicKey)
+			break
+		}
+	}
+	if publicKey == nil {
+		return nil, ErrInvalidJWT
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidJWT
+	}
+
+	claims := &AccessClaims{}
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(data, claims) != nil {
+		return nil, ErrInvalidJWT
+	}
+
+	if claims.Issuer != s.issuer || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidJWT
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys in JWK Set form, for other services to verify
+// access tokens with
+func (s *Signer) JWKS() map[string]any {
+	keys := []map[string]string{}
+	for _, key := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"alg": "EdDSA",
+			"use": "sig",
+			"kid": key.ID,
+			"x":   base64.RawURLEncoding.EncodeToString(key.PrivateKey.Public().(ed25519.PublicKey)),
+		})
+	}
+
+	return map[string]any{"keys": keys}
+}
@@ -0,0 +1,64 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T, id string) SigningKey {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return SigningKey{ID: id, PrivateKey: private}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := NewSigner("workouts", newKey(t, "2025-01"))
+	require.NoError(t, err)
+
+	token, err := signer.Sign(AccessClaims{UserID: 7, Username: "gonzalo", TokenID: 42, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	require.NoError(t, err)
+	assert.True(t, IsJWT(token))
+
+	claims, err := signer.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, 7, claims.UserID)
+	assert.Equal(t, "gonzalo", claims.Username)
+	assert.Equal(t, 42, claims.TokenID)
+
+	// Tampering with the payload breaks the signature
+	_, err = signer.Verify(token[:len(token)-4] + "AAAA")
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+
+	expired, err := signer.Sign(AccessClaims{UserID: 7, ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	require.NoError(t, err)
+	_, err = signer.Verify(expired)
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKey := newKey(t, "old"), newKey(t, "new")
+
+	oldSigner, err := NewSigner("workouts", oldKey)
+	require.NoError(t, err)
+	token, err := oldSigner.Sign(AccessClaims{UserID: 7, ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	require.NoError(t, err)
+
+	// After rotation the old key still verifies until it's dropped
+	rotated, err := NewSigner("workouts", newKey, oldKey)
+	require.NoError(t, err)
+	_, err = rotated.Verify(token)
+	assert.NoError(t, err)
+	assert.Len(t, rotated.JWKS()["keys"], 2)
+
+	dropped, err := NewSigner("workouts", newKey)
+	require.NoError(t, err)
+	_, err = dropped.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+
+	assert.False(t, IsJWT("ABCDEFGHIJKLMNOP"))
+}
@@ -4,19 +4,38 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"strings"
 	"time"
 )
 
 const (
-	ScopeAuth = "authentication"
+	ScopeAuth          = "authentication"
+	ScopeRefresh       = "refresh"
+	ScopePasswordReset = "password-reset"
+	ScopeActivation    = "activation"
+	ScopeTwoFactor     = "two-factor"
 )
 
 type Token struct {
-	Plaintext string    `json:"token"`
+	ID        int       `json:"id"`
+	Plaintext string    `json:"token,omitempty"`
 	Hash      []byte    `json:"-"`
 	UserID    int       `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	FamilyID  string    `json:"-"`
+	ParentID  *int      `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func randomString() (string, error) {
+	emptyBytes := make([]byte, 32)
+	_, err := rand.Read(emptyBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes), nil
 }
 
 func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
@@ -26,14 +45,46 @@ func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error)
 		Scope:  scope,
 	}
 
-	emptyBytes := make([]byte, 32)
-	_, err := rand.Read(emptyBytes)
+	plaintext, err := randomString()
 	if err != nil {
 		return nil, err
 	}
 
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes)
+	token.Plaintext = plaintext
 	hash := sha256.Sum256([]byte(token.Plaintext))
 	token.Hash = hash[:]
 	return token, nil
 }
+
+// NewFamily returns a fresh id for a login's access and refresh tokens
+func NewFamily() (string, error) {
+	return randomString()
+}
+
+// APIKeyPrefix marks API keys so they can be told apart from session tokens
+// in the Authorization header
+const APIKeyPrefix = "wk_"
+
+// GenerateAPIKey returns a new API key and the hash to store for it
+func GenerateAPIKey() (string, []byte, error) {
+	random, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := APIKeyPrefix + strings.ToLower(random)
+	hash := sha256.Sum256([]byte(plaintext))
+	return plaintext, hash[:], nil
+}
+
+// GenerateShareToken returns the token for a new share link and the hash to
+// store for it. The token goes in the link's URL, so it's the only way in.
+func GenerateShareToken() (string, []byte, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash := sha256.Sum256([]byte(plaintext))
+	return plaintext, hash[:], nil
+}
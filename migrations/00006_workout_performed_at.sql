@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workouts
+ADD COLUMN performed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- Workouts logged so far were performed whenever they were logged
+UPDATE workouts SET performed_at = created_at WHERE created_at IS NOT NULL;
+
+CREATE INDEX idx_workouts_user_performed_at ON workouts (user_id, performed_at DESC, id DESC);
+CREATE INDEX idx_workouts_user_created_at ON workouts (user_id, created_at DESC, id DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_workouts_user_created_at;
+DROP INDEX idx_workouts_user_performed_at;
+ALTER TABLE workouts DROP COLUMN performed_at;
+-- +goose StatementEnd
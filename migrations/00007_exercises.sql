@@ -0,0 +1,127 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS exercises (
+  id BIGSERIAL PRIMARY KEY,
+  -- NULL for the global catalog, set for a user's custom exercises
+  user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_exercises_owner_name ON exercises (COALESCE(user_id, 0), lower(name));
+
+CREATE TABLE IF NOT EXISTS exercise_aliases (
+  exercise_id BIGINT NOT NULL REFERENCES exercises(id) ON DELETE CASCADE,
+  alias VARCHAR(255) NOT NULL,
+
+  PRIMARY KEY (exercise_id, alias)
+);
+
+CREATE INDEX idx_exercise_aliases_alias ON exercise_aliases (lower(alias));
+
+INSERT INTO exercises (name) VALUES
+  ('Bench press'),
+  ('Incline bench press'),
+  ('Squat'),
+  ('Front squat'),
+  ('Deadlift'),
+  ('Romanian deadlift'),
+  ('Overhead press'),
+  ('Barbell row'),
+  ('Pull-up'),
+  ('Chin-up'),
+  ('Dips'),
+  ('Push-up'),
+  ('Lunge'),
+  ('Leg press'),
+  ('Bicep curl'),
+  ('Tricep extension'),
+  ('Lateral raise'),
+  ('Plank'),
+  ('Running'),
+  ('Cycling'),
+  ('Rowing');
+
+INSERT INTO exercise_aliases (exercise_id, alias)
+SELECT e.id, a.alias
+FROM exercises e
+JOIN (VALUES
+  ('Bench press', 'Bench'),
+  ('Bench press', 'BB Bench'),
+  ('Bench press', 'Barbell bench press'),
+  ('Bench press', 'Flat bench'),
+  ('Incline bench press', 'Incline bench'),
+  ('Squat', 'Back squat'),
+  ('Squat', 'Squats'),
+  ('Squat', 'Barbell squat'),
+  ('Deadlift', 'Deadlifts'),
+  ('Deadlift', 'Conventional deadlift'),
+  ('Romanian deadlift', 'RDL'),
+  ('Overhead press', 'OHP'),
+  ('Overhead press', 'Military press'),
+  ('Overhead press', 'Shoulder press'),
+  ('Barbell row', 'Bent over row'),
+  ('Pull-up', 'Pull-ups'),
+  ('Pull-up', 'Pullup'),
+  ('Chin-up', 'Chin-ups'),
+  ('Chin-up', 'Chinup'),
+  ('Dips', 'Dip'),
+  ('Push-up', 'Push-ups'),
+  ('Push-up', 'Pushup'),
+  ('Lunge', 'Lunges'),
+  ('Bicep curl', 'Curl'),
+  ('Bicep curl', 'Biceps curl'),
+  ('Tricep extension', 'Triceps extension'),
+  ('Running', 'Run'),
+  ('Cycling', 'Bike')
+) AS a (name, alias) ON a.name = e.name
+WHERE e.user_id IS NULL;
+
+ALTER TABLE workout_entries
+ADD COLUMN exercise_id BIGINT REFERENCES exercises(id);
+
+-- Names that match neither a catalog name nor an alias become custom exercises
+-- for the user that logged them
+INSERT INTO exercises (user_id, name)
+SELECT DISTINCT ON (w.user_id, lower(trim(we.exercise_name))) w.user_id, trim(we.exercise_name)
+FROM workout_entries we
+JOIN workouts w ON w.id = we.workout_id
+WHERE NOT EXISTS (
+  SELECT 1
+  FROM exercises e
+  LEFT JOIN exercise_aliases a ON a.exercise_id = e.id
+  WHERE e.user_id IS NULL
+  AND (lower(e.name) = lower(trim(we.exercise_name)) OR lower(a.alias) = lower(trim(we.exercise_name)))
+)
+ORDER BY w.user_id, lower(trim(we.exercise_name));
+
+UPDATE workout_entries we
+SET exercise_id = (
+  SELECT e.id
+  FROM exercises e
+  LEFT JOIN exercise_aliases a ON a.exercise_id = e.id
+  WHERE (e.user_id IS NULL OR e.user_id = w.user_id)
+  AND (lower(e.name) = lower(trim(we.exercise_name)) OR lower(a.alias) = lower(trim(we.exercise_name)))
+  ORDER BY lower(e.name) = lower(trim(we.exercise_name)) DESC, e.user_id NULLS LAST
+  LIMIT 1
+)
+FROM workouts w
+WHERE w.id = we.workout_id;
+
+UPDATE workout_entries we
+SET exercise_name = e.name
+FROM exercises e
+WHERE e.id = we.exercise_id;
+
+ALTER TABLE workout_entries ALTER COLUMN exercise_id SET NOT NULL;
+
+CREATE INDEX idx_workout_entries_exercise_id ON workout_entries (exercise_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workout_entries DROP COLUMN exercise_id;
+DROP TABLE exercise_aliases;
+DROP TABLE exercises;
+-- +goose StatementEnd
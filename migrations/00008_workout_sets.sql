@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS workout_sets (
+  id BIGSERIAL PRIMARY KEY,
+  workout_entry_id BIGINT NOT NULL REFERENCES workout_entries(id) ON DELETE CASCADE,
+  set_number INTEGER NOT NULL,
+  set_type VARCHAR(20) NOT NULL DEFAULT 'working',
+  reps INTEGER,
+  duration_seconds INTEGER,
+  weight DECIMAL(5, 2),
+  rpe DECIMAL(3, 1),
+  rest_seconds INTEGER,
+
+  CONSTRAINT valid_workout_set CHECK(
+    (reps IS NOT NULL OR duration_seconds IS NOT NULL) AND
+    (reps IS NULL OR duration_seconds IS NULL)
+  ),
+  CONSTRAINT valid_set_type CHECK(set_type IN ('working', 'warmup', 'drop', 'failure')),
+  CONSTRAINT valid_rpe CHECK(rpe IS NULL OR (rpe >= 1 AND rpe <= 10)),
+  UNIQUE (workout_entry_id, set_number)
+);
+
+-- Expand the existing sets/reps/weight triples into identical working sets
+INSERT INTO workout_sets (workout_entry_id, set_number, reps, duration_seconds, weight)
+SELECT we.id, n, we.reps, we.duration_seconds, we.weight
+FROM workout_entries we
+CROSS JOIN LATERAL generate_series(1, GREATEST(we.sets, 1)) AS n;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE workout_sets;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS personal_records (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  exercise_id BIGINT NOT NULL REFERENCES exercises(id) ON DELETE CASCADE,
+  workout_id BIGINT NOT NULL REFERENCES workouts(id) ON DELETE CASCADE,
+  record_type VARCHAR(30) NOT NULL,
+  -- Only set for most_reps, which is tracked separately for every weight
+  weight DECIMAL(8, 2),
+  value DECIMAL(10, 2) NOT NULL,
+  achieved_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT valid_record_type CHECK(record_type IN ('heaviest_weight', 'most_reps', 'estimated_1rm', 'longest_duration'))
+);
+
+CREATE INDEX idx_personal_records_lookup ON personal_records (user_id, exercise_id, record_type, weight, value DESC);
+CREATE INDEX idx_personal_records_workout_id ON personal_records (workout_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE personal_records;
+-- +goose StatementEnd
@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS workout_templates (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  title VARCHAR(255) NOT NULL,
+  description TEXT,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_workout_templates_user_id ON workout_templates (user_id);
+
+CREATE TABLE IF NOT EXISTS template_entries (
+  id BIGSERIAL PRIMARY KEY,
+  template_id BIGINT NOT NULL REFERENCES workout_templates(id) ON DELETE CASCADE,
+  exercise_id BIGINT NOT NULL REFERENCES exercises(id),
+  exercise_name VARCHAR(255) NOT NULL,
+  target_sets INTEGER NOT NULL,
+  target_reps_min INTEGER,
+  target_reps_max INTEGER,
+  target_duration_seconds INTEGER,
+  target_weight DECIMAL(5, 2),
+  notes TEXT,
+  order_index INTEGER NOT NULL,
+
+  CONSTRAINT valid_template_entry CHECK(
+    target_sets > 0 AND
+    (target_reps_min IS NOT NULL OR target_duration_seconds IS NOT NULL) AND
+    (target_reps_min IS NULL OR target_duration_seconds IS NULL) AND
+    (target_reps_max IS NULL OR target_reps_max >= target_reps_min)
+  )
+);
+
+ALTER TABLE workouts
+ADD COLUMN template_id BIGINT REFERENCES workout_templates(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workouts DROP COLUMN template_id;
+DROP TABLE template_entries;
+DROP TABLE workout_templates;
+-- +goose StatementEnd
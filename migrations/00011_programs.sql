@@ -0,0 +1,85 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS programs (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name VARCHAR(255) NOT NULL,
+  description TEXT,
+  -- Share of the usual weight prescribed during deload weeks
+  deload_percent DECIMAL(5, 2) NOT NULL DEFAULT 60,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+  CONSTRAINT valid_deload_percent CHECK(deload_percent > 0 AND deload_percent <= 100)
+);
+
+CREATE INDEX idx_programs_user_id ON programs (user_id);
+
+CREATE TABLE IF NOT EXISTS program_weeks (
+  id BIGSERIAL PRIMARY KEY,
+  program_id BIGINT NOT NULL REFERENCES programs(id) ON DELETE CASCADE,
+  week_number INTEGER NOT NULL,
+  deload BOOLEAN NOT NULL DEFAULT FALSE,
+
+  UNIQUE (program_id, week_number)
+);
+
+CREATE TABLE IF NOT EXISTS program_days (
+  id BIGSERIAL PRIMARY KEY,
+  program_week_id BIGINT NOT NULL REFERENCES program_weeks(id) ON DELETE CASCADE,
+  day_number INTEGER NOT NULL,
+  template_id BIGINT NOT NULL REFERENCES workout_templates(id),
+
+  UNIQUE (program_week_id, day_number)
+);
+
+CREATE TABLE IF NOT EXISTS program_rules (
+  id BIGSERIAL PRIMARY KEY,
+  program_id BIGINT NOT NULL REFERENCES programs(id) ON DELETE CASCADE,
+  exercise_id BIGINT NOT NULL REFERENCES exercises(id),
+  rule_type VARCHAR(20) NOT NULL,
+  increment DECIMAL(6, 2),
+  percent DECIMAL(5, 2),
+
+  UNIQUE (program_id, exercise_id),
+  CONSTRAINT valid_program_rule CHECK(
+    (rule_type = 'linear' AND increment IS NOT NULL AND increment > 0) OR
+    (rule_type = 'percent_1rm' AND percent IS NOT NULL AND percent > 0 AND percent <= 120)
+  )
+);
+
+CREATE TABLE IF NOT EXISTS program_enrollments (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  program_id BIGINT NOT NULL REFERENCES programs(id) ON DELETE CASCADE,
+  current_week INTEGER NOT NULL,
+  current_day INTEGER NOT NULL,
+  started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX idx_program_enrollments_active ON program_enrollments (user_id, program_id) WHERE completed_at IS NULL;
+
+-- Working weights for exercises under a linear progression rule
+CREATE TABLE IF NOT EXISTS enrollment_weights (
+  enrollment_id BIGINT NOT NULL REFERENCES program_enrollments(id) ON DELETE CASCADE,
+  exercise_id BIGINT NOT NULL REFERENCES exercises(id) ON DELETE CASCADE,
+  weight DECIMAL(8, 2) NOT NULL,
+
+  PRIMARY KEY (enrollment_id, exercise_id)
+);
+
+ALTER TABLE workouts
+ADD COLUMN enrollment_id BIGINT REFERENCES program_enrollments(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workouts DROP COLUMN enrollment_id;
+DROP TABLE enrollment_weights;
+DROP TABLE program_enrollments;
+DROP TABLE program_rules;
+DROP TABLE program_days;
+DROP TABLE program_weeks;
+DROP TABLE programs;
+-- +goose StatementEnd
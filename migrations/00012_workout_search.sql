@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workouts
+ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+  setweight(to_tsvector('english', coalesce(description, '')), 'B')
+) STORED;
+
+ALTER TABLE workout_entries
+ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', coalesce(exercise_name, '')), 'A') ||
+  setweight(to_tsvector('english', coalesce(notes, '')), 'C')
+) STORED;
+
+CREATE INDEX idx_workouts_search_vector ON workouts USING GIN (search_vector);
+CREATE INDEX idx_workout_entries_search_vector ON workout_entries USING GIN (search_vector);
+CREATE INDEX idx_workout_entries_workout_id ON workout_entries (workout_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_workout_entries_workout_id;
+ALTER TABLE workout_entries DROP COLUMN search_vector;
+ALTER TABLE workouts DROP COLUMN search_vector;
+-- +goose StatementEnd
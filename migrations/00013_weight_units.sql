@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN preferred_unit VARCHAR(2) NOT NULL DEFAULT 'kg' CHECK (preferred_unit IN ('kg', 'lb'));
+
+-- Weights are stored in kilograms. Three decimals keep pound entries exact
+-- to the hundredth when they are converted back.
+ALTER TABLE workout_entries ALTER COLUMN weight TYPE DECIMAL(9, 3);
+ALTER TABLE workout_sets ALTER COLUMN weight TYPE DECIMAL(9, 3);
+ALTER TABLE template_entries ALTER COLUMN target_weight TYPE DECIMAL(9, 3);
+ALTER TABLE personal_records ALTER COLUMN weight TYPE DECIMAL(9, 3);
+ALTER TABLE personal_records ALTER COLUMN value TYPE DECIMAL(12, 3);
+ALTER TABLE program_rules ALTER COLUMN increment TYPE DECIMAL(9, 3);
+ALTER TABLE enrollment_weights ALTER COLUMN weight TYPE DECIMAL(9, 3);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE enrollment_weights ALTER COLUMN weight TYPE DECIMAL(8, 2);
+ALTER TABLE program_rules ALTER COLUMN increment TYPE DECIMAL(6, 2);
+ALTER TABLE personal_records ALTER COLUMN value TYPE DECIMAL(10, 2);
+ALTER TABLE personal_records ALTER COLUMN weight TYPE DECIMAL(8, 2);
+ALTER TABLE template_entries ALTER COLUMN target_weight TYPE DECIMAL(5, 2);
+ALTER TABLE workout_sets ALTER COLUMN weight TYPE DECIMAL(5, 2);
+ALTER TABLE workout_entries ALTER COLUMN weight TYPE DECIMAL(5, 2);
+ALTER TABLE users DROP COLUMN preferred_unit;
+-- +goose StatementEnd
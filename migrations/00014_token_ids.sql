@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tokens ADD COLUMN id BIGSERIAL UNIQUE;
+ALTER TABLE tokens ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+CREATE INDEX idx_tokens_user_id_scope ON tokens (user_id, scope);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_tokens_user_id_scope;
+ALTER TABLE tokens DROP COLUMN created_at;
+ALTER TABLE tokens DROP COLUMN id;
+-- +goose StatementEnd
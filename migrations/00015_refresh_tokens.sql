@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Tokens issued together at login, and every token rotated from them, share a
+-- family so a replayed refresh token can revoke all of them at once
+ALTER TABLE tokens ADD COLUMN family_id TEXT;
+ALTER TABLE tokens ADD COLUMN parent_id BIGINT REFERENCES tokens(id) ON DELETE SET NULL;
+ALTER TABLE tokens ADD COLUMN used_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX idx_tokens_family_id ON tokens (family_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_tokens_family_id;
+ALTER TABLE tokens DROP COLUMN used_at;
+ALTER TABLE tokens DROP COLUMN parent_id;
+ALTER TABLE tokens DROP COLUMN family_id;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workouts ADD COLUMN visibility VARCHAR(20) NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'followers', 'public'));
+
+CREATE TABLE IF NOT EXISTS follows (
+  follower_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  followee_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (follower_id, followee_id),
+  CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX idx_follows_followee_id ON follows (followee_id);
+
+-- Backs the feed and profile pages, which page through someone's shared
+-- workouts newest first
+CREATE INDEX idx_workouts_shared ON workouts (user_id, performed_at DESC, id DESC) WHERE visibility <> 'private';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_workouts_shared;
+DROP TABLE follows;
+ALTER TABLE workouts DROP COLUMN visibility;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Emails are looked up case-insensitively, so they have to be unique that way
+-- too. Databases that already hold case variants of an email need them merged
+-- or renamed by hand before this runs.
+CREATE UNIQUE INDEX idx_users_email_lower ON users (lower(email));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_users_email_lower;
+-- +goose StatementEnd
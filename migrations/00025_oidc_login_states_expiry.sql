@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX idx_oidc_login_states_expiry ON oidc_login_states (expiry);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_oidc_login_states_expiry;
+-- +goose StatementEnd
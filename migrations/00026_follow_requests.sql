@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A follow is a request until the followee accepts it, and only accepted
+-- follows see followers-only workouts. Follows made before this were never
+-- approved, so they start out as requests too.
+ALTER TABLE follows ADD COLUMN accepted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_follows_pending ON follows (followee_id, created_at) WHERE accepted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_follows_pending;
+ALTER TABLE follows DROP COLUMN accepted_at;
+-- +goose StatementEnd